@@ -0,0 +1,510 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// ANSI terminal rendering backend
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"html"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Ansi is a type that implements the Renderer interface for display on a
+// terminal, using ANSI escape codes for styling.
+//
+// Do not create this directly, instead use the AnsiRenderer function.
+type Ansi struct {
+	width int
+
+	// whether links show numbers in place of their URLs, and the URLs
+	// numbered so far
+	numberLinks bool
+	links       []string
+}
+
+// AnsiRenderer creates and configures an Ansi object, which satisfies the
+// Renderer interface.
+//
+// width is the number of columns to wrap paragraphs to; with width <= 0,
+// paragraphs are not wrapped. Control characters in the input, other than
+// newlines and tabs, are left out of the output.
+func AnsiRenderer(width int) Renderer {
+	return &Ansi{width: width}
+}
+
+// SetNumberedLinks makes links show a number, as in text[1], in place of
+// their URL, and lists the URLs by number at the end of the document, as
+// for printing. Numbers go up in the order links first appear, and a URL
+// linked again keeps its number. The list is written by DocumentFooter,
+// which Markdown and Render call last; after MarkdownInline, which does
+// not, call DocumentFooter to write it.
+func (options *Ansi) SetNumberedLinks(numbered bool) {
+	options.numberLinks = numbered
+}
+
+// ANSI escape codes, each style paired with the code that ends only it, so
+// that styles can nest
+const (
+	ansiBold          = "\x1b[1m"
+	ansiBoldOff       = "\x1b[22m"
+	ansiItalic        = "\x1b[3m"
+	ansiItalicOff     = "\x1b[23m"
+	ansiUnderline     = "\x1b[4m"
+	ansiUnderlineOff  = "\x1b[24m"
+	ansiStrike        = "\x1b[9m"
+	ansiStrikeOff     = "\x1b[29m"
+	ansiReverse       = "\x1b[7m"
+	ansiReverseOff    = "\x1b[27m"
+	ansiCode          = "\x1b[33m"
+	ansiCodeOff       = "\x1b[39m"
+	ansiHeader        = "\x1b[1;35m"
+	ansiSubheader     = "\x1b[1;36m"
+	ansiHeaderOff     = "\x1b[22;39m"
+	ansiQuotePrefix   = "│ "
+	ansiRuleWidth     = 40
+	ansiCellSeparator = '\x1e' // ends a table cell
+)
+
+func (options *Ansi) BlockCode(out *bytes.Buffer, text []byte, info string) {
+	doubleSpace(out)
+
+	lines := strings.Split(strings.TrimRight(string(stripControls(text)), "\n"), "\n")
+	width := 0
+	for _, line := range lines {
+		if n := visibleWidth(line); n > width {
+			width = n
+		}
+	}
+	label := ""
+	if langs := infoLanguages(info); len(langs) > 0 {
+		label = "─ " + langs[0] + " "
+	}
+	if n := utf8.RuneCountInString(label); n > width+2 {
+		width = n - 2
+	}
+
+	out.WriteString("┌" + label + strings.Repeat("─", width+2-utf8.RuneCountInString(label)) + "┐\n")
+	for _, line := range lines {
+		out.WriteString("│ " + line + strings.Repeat(" ", width-visibleWidth(line)) + " │\n")
+	}
+	out.WriteString("└" + strings.Repeat("─", width+2) + "┘\n")
+}
+
+func (options *Ansi) BlockQuote(out *bytes.Buffer, text []byte) {
+	doubleSpace(out)
+	writePrefixedLines(out, bytes.TrimRight(text, "\n"), ansiQuotePrefix, ansiQuotePrefix)
+}
+
+func (options *Ansi) Alert(out *bytes.Buffer, text []byte, kind int) {
+	doubleSpace(out)
+	out.WriteString(ansiQuotePrefix + ansiBold + alertTitle(kind) + ansiBoldOff + "\n")
+	if text = bytes.TrimRight(text, "\n"); len(text) > 0 {
+		writePrefixedLines(out, text, ansiQuotePrefix, ansiQuotePrefix)
+	}
+}
+
+func (options *Ansi) Details(out *bytes.Buffer, summary, text []byte, flags int) {
+	doubleSpace(out)
+	marker := "▸ "
+	if flags&DETAILS_OPEN != 0 {
+		marker = "▾ "
+	}
+	if len(summary) == 0 {
+		summary = []byte("Details")
+	}
+	out.WriteString(ansiBold + marker)
+	out.Write(summary)
+	out.WriteString(ansiBoldOff + "\n")
+	if text = bytes.TrimRight(text, "\n"); len(text) > 0 {
+		writePrefixedLines(out, text, "  ", "  ")
+	}
+}
+
+func (options *Ansi) Directive(out *bytes.Buffer, name string, attrs []DirectiveAttr, text []byte) {
+	if text = bytes.TrimRight(text, "\n"); len(text) > 0 {
+		doubleSpace(out)
+		out.Write(text)
+		out.WriteByte('\n')
+	}
+}
+
+func (options *Ansi) BlockHtml(out *bytes.Buffer, text []byte) {
+	doubleSpace(out)
+	out.Write(stripControls(text))
+	out.WriteByte('\n')
+}
+
+func (options *Ansi) Header(out *bytes.Buffer, text func() bool, level int, id string) {
+	marker := out.Len()
+	doubleSpace(out)
+
+	if level == 1 {
+		out.WriteString(ansiHeader)
+	} else {
+		out.WriteString(ansiSubheader)
+	}
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteString(ansiHeaderOff)
+	out.WriteByte('\n')
+}
+
+func (options *Ansi) HRule(out *bytes.Buffer) {
+	doubleSpace(out)
+	width := options.width
+	if width <= 0 {
+		width = ansiRuleWidth
+	}
+	out.WriteString(strings.Repeat("─", width))
+	out.WriteByte('\n')
+}
+
+func (options *Ansi) List(out *bytes.Buffer, text func() bool, flags int) {
+	marker := out.Len()
+	doubleSpace(out)
+
+	if !text() {
+		out.Truncate(marker)
+	}
+}
+
+func (options *Ansi) ListItem(out *bytes.Buffer, text []byte, flags, index, level int) {
+	if flags&LIST_ITEM_CONTAINS_BLOCK != 0 && flags&LIST_ITEM_BEGINNING_OF_LIST == 0 {
+		out.WriteByte('\n')
+	}
+
+	bullet := "• "
+	if flags&LIST_TYPE_ORDERED != 0 {
+		bullet = listOrdinal(index, flags) + ". "
+	}
+	if flags&LIST_ITEM_TASK_DONE != 0 {
+		bullet += "☑ "
+	} else if flags&LIST_ITEM_TASK != 0 {
+		bullet += "☐ "
+	}
+	indent := strings.Repeat(" ", utf8.RuneCountInString(bullet))
+
+	if flags&LIST_ITEM_CONTAINS_BLOCK == 0 {
+		text = markInlineLine(text)
+	}
+	writePrefixedLines(out, text, bullet, indent)
+}
+
+func (options *Ansi) Paragraph(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	doubleSpace(out)
+
+	out.WriteByte(wrapMark)
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteByte('\n')
+}
+
+func (options *Ansi) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int) {
+	doubleSpace(out)
+
+	var rows [][]string
+	for _, part := range [][]byte{header, body} {
+		for _, row := range strings.Split(strings.TrimRight(string(part), "\n"), "\n") {
+			if row != "" {
+				rows = append(rows, strings.Split(strings.TrimSuffix(row, string(ansiCellSeparator)),
+					string(ansiCellSeparator)))
+			}
+		}
+	}
+
+	widths := make([]int, len(columnData))
+	for _, row := range rows {
+		for i, cell := range row {
+			if i < len(widths) && visibleWidth(cell) > widths[i] {
+				widths[i] = visibleWidth(cell)
+			}
+		}
+	}
+
+	headerRows := strings.Count(string(header), "\n")
+	for r, row := range rows {
+		for i, width := range widths {
+			if i > 0 {
+				out.WriteString(" │ ")
+			}
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			pad := width - visibleWidth(cell)
+			switch columnData[i] {
+			case TABLE_ALIGNMENT_RIGHT:
+				cell = strings.Repeat(" ", pad) + cell
+			case TABLE_ALIGNMENT_CENTER:
+				cell = strings.Repeat(" ", pad/2) + cell + strings.Repeat(" ", pad-pad/2)
+			default:
+				cell += strings.Repeat(" ", pad)
+			}
+			if r < headerRows {
+				cell = ansiBold + cell + ansiBoldOff
+			}
+			out.WriteString(cell)
+		}
+		out.WriteByte('\n')
+
+		if r == headerRows-1 {
+			for i, width := range widths {
+				if i > 0 {
+					out.WriteString("─┼─")
+				}
+				out.WriteString(strings.Repeat("─", width))
+			}
+			out.WriteByte('\n')
+		}
+	}
+}
+
+func (options *Ansi) TableRow(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+	out.WriteByte('\n')
+}
+
+func (options *Ansi) TableHeaderCell(out *bytes.Buffer, text []byte, align int) {
+	options.TableCell(out, text, align)
+}
+
+func (options *Ansi) TableCell(out *bytes.Buffer, text []byte, align int) {
+	out.Write(text)
+	out.WriteByte(ansiCellSeparator)
+}
+
+func (options *Ansi) Footnotes(out *bytes.Buffer, text func() bool) {
+	options.HRule(out)
+	text()
+}
+
+func (options *Ansi) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	if flags&LIST_ITEM_BEGINNING_OF_LIST == 0 {
+		out.WriteByte('\n')
+	}
+	if flags&LIST_ITEM_CONTAINS_BLOCK == 0 {
+		text = markInlineLine(text)
+	}
+	label := "[" + string(name) + "] "
+	writePrefixedLines(out, bytes.TrimRight(text, "\n"), label, strings.Repeat(" ", len(label)))
+}
+
+func (options *Ansi) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	link = stripControls(link)
+	out.WriteString(ansiUnderline)
+	switch kind {
+	case LINK_TYPE_EMAIL:
+		out.Write(bytes.TrimPrefix(link, []byte("mailto:")))
+	case LINK_TYPE_ISSUE:
+		out.WriteByte('#')
+		out.Write(link)
+	default:
+		out.Write(link)
+	}
+	out.WriteString(ansiUnderlineOff)
+}
+
+func (options *Ansi) CodeSpan(out *bytes.Buffer, text []byte) {
+	out.WriteString(ansiCode)
+	out.Write(stripControls(text))
+	out.WriteString(ansiCodeOff)
+}
+
+func (options *Ansi) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString(ansiBold)
+	out.Write(text)
+	out.WriteString(ansiBoldOff)
+}
+
+func (options *Ansi) Emphasis(out *bytes.Buffer, text []byte) {
+	if len(text) == 0 {
+		return
+	}
+	out.WriteString(ansiItalic)
+	out.Write(text)
+	out.WriteString(ansiItalicOff)
+}
+
+func (options *Ansi) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+	out.WriteString("[image:")
+	out.WriteByte(nonBreakingSpace)
+	out.Write(nonBreaking(alt))
+	out.WriteByte(']')
+	out.WriteByte(nonBreakingSpace)
+	out.WriteByte('(')
+	out.Write(stripControls(link))
+	out.WriteByte(')')
+}
+
+func (options *Ansi) LineBreak(out *bytes.Buffer) {
+	out.WriteByte('\n')
+	out.WriteByte(wrapMark)
+}
+
+func (options *Ansi) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	// links are never broken across lines
+	link = stripControls(link)
+	out.WriteString(ansiUnderline)
+	out.Write(nonBreaking(content))
+	out.WriteString(ansiUnderlineOff)
+	if options.numberLinks && !bytes.Equal(content, link) {
+		out.WriteByte('[')
+		out.WriteString(strconv.Itoa(options.linkNumber(string(link))))
+		out.WriteByte(']')
+	} else if !bytes.Equal(content, link) {
+		out.WriteByte(nonBreakingSpace)
+		out.WriteByte('(')
+		out.Write(link)
+		out.WriteByte(')')
+	}
+}
+
+// The number of a link for SetNumberedLinks.
+func (options *Ansi) linkNumber(link string) int {
+	for i, l := range options.links {
+		if l == link {
+			return i + 1
+		}
+	}
+	options.links = append(options.links, link)
+	return len(options.links)
+}
+
+func (options *Ansi) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+}
+
+func (options *Ansi) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString(ansiBold + ansiItalic)
+	out.Write(text)
+	out.WriteString(ansiItalicOff + ansiBoldOff)
+}
+
+func (options *Ansi) StrikeThrough(out *bytes.Buffer, text []byte) {
+	out.WriteString(ansiStrike)
+	out.Write(text)
+	out.WriteString(ansiStrikeOff)
+}
+
+func (options *Ansi) CriticMarkup(out *bytes.Buffer, text, replacement []byte, kind int) {
+	switch kind {
+	case CRITIC_INSERTION:
+		out.WriteString(ansiUnderline)
+		out.Write(text)
+		out.WriteString(ansiUnderlineOff)
+	case CRITIC_DELETION:
+		options.StrikeThrough(out, text)
+	case CRITIC_SUBSTITUTION:
+		options.StrikeThrough(out, text)
+		out.WriteString(ansiUnderline)
+		out.Write(replacement)
+		out.WriteString(ansiUnderlineOff)
+	case CRITIC_HIGHLIGHT:
+		out.WriteString(ansiReverse)
+		out.Write(text)
+		out.WriteString(ansiReverseOff)
+	case CRITIC_COMMENT:
+		out.WriteString(ansiItalic + "(")
+		out.Write(text)
+		out.WriteString(")" + ansiItalicOff)
+	}
+}
+
+func (options *Ansi) Time(out *bytes.Buffer, datetime []byte) {
+	out.Write(datetime)
+}
+
+func (options *Ansi) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	out.WriteByte('[')
+	out.Write(ref)
+	out.WriteByte(']')
+}
+
+func (options *Ansi) Entity(out *bytes.Buffer, entity []byte) {
+	out.Write(stripControls([]byte(html.UnescapeString(string(entity)))))
+}
+
+func (options *Ansi) NormalText(out *bytes.Buffer, text []byte) {
+	for _, c := range text {
+		switch c {
+		case '\n':
+			out.WriteByte(' ')
+		default:
+			// control characters, such as the escapes of the input and
+			// the marks of wrapping and tables, are left out
+			if c >= ' ' || c == '\t' {
+				out.WriteByte(c)
+			}
+		}
+	}
+}
+
+// Return text without the control characters other than newlines and
+// tabs, so that the input cannot send escape sequences to the terminal or
+// pass for the marks the renderer writes.
+func stripControls(text []byte) []byte {
+	return stripBytes(text, func(c byte) bool {
+		return c < ' ' && c != '\n' && c != '\t'
+	})
+}
+
+func (options *Ansi) DocumentHeader(out *bytes.Buffer) {
+}
+
+// List the numbered links, then wrap the marked lines now that the prefixes
+// of enclosing blocks are known.
+func (options *Ansi) DocumentFooter(out *bytes.Buffer) {
+	if len(options.links) > 0 {
+		options.HRule(out)
+		for i, link := range options.links {
+			out.WriteByte('[')
+			out.WriteString(strconv.Itoa(i + 1))
+			out.WriteString("] ")
+			out.WriteString(link)
+			out.WriteByte('\n')
+		}
+		options.links = nil
+	}
+	wrapMarkedLines(out, options.width, "│", visibleWidth, anyWordStartsLine)
+}
+
+func (options *Ansi) finishInline(out *bytes.Buffer) {
+	markInlineOutput(out)
+	options.DocumentFooter(out)
+}
+
+// Count the columns a string takes on the terminal, skipping escape codes.
+func visibleWidth(s string) int {
+	width := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' {
+			for i < len(s) && s[i] != 'm' {
+				i++
+			}
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		width++
+	}
+	return width
+}
@@ -0,0 +1,135 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for ANSI terminal rendering
+//
+
+package blackfriday
+
+import (
+	"strings"
+	"testing"
+)
+
+func doTestsAnsi(t *testing.T, tests []string, width int, extensions int) {
+	for i := 0; i+1 < len(tests); i += 2 {
+		input := tests[i]
+		expected := tests[i+1]
+		actual := string(Markdown([]byte(input), AnsiRenderer(width), extensions))
+		if actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				input, expected, actual)
+		}
+	}
+}
+
+func TestAnsiInline(t *testing.T) {
+	var tests = []string{
+		"*em* **strong** ~~gone~~ `code`\n",
+		"\x1b[3mem\x1b[23m \x1b[1mstrong\x1b[22m \x1b[9mgone\x1b[29m \x1b[33mcode\x1b[39m\n",
+
+		"[text](http://example.com/) and <http://example.com/>\n",
+		"\x1b[4mtext\x1b[24m (http://example.com/) and \x1b[4mhttp://example.com/\x1b[24m\n",
+
+		"AT&amp;T <b>bold</b>\n",
+		"AT&T bold\n",
+	}
+	doTestsAnsi(t, tests, 0, EXTENSION_STRIKETHROUGH)
+}
+
+func TestAnsiBlocks(t *testing.T) {
+	var tests = []string{
+		"# Title\n\n## Section\n",
+		"\x1b[1;35mTitle\x1b[22;39m\n\n\x1b[1;36mSection\x1b[22;39m\n",
+
+		"> quote\n>\n> > nested\n",
+		"│ quote\n│\n│ │ nested\n",
+
+		"* one\n* two\n    1. sub\n",
+		"• one\n• two\n  1. sub\n",
+
+		"```go\nx := 1\n```\n",
+		"┌─ go ───┐\n│ x := 1 │\n└────────┘\n",
+
+		"| a | bee |\n|--:|:-:|\n| 333 | x |\n",
+		"\x1b[1m  a\x1b[22m │ \x1b[1mbee\x1b[22m\n────┼────\n333 │  x \n",
+
+		"> [!TIP]\n> Try it.\n",
+		"│ \x1b[1mTip\x1b[22m\n│ Try it.\n",
+	}
+	doTestsAnsi(t, tests, 0, EXTENSION_FENCED_CODE|EXTENSION_TABLES|EXTENSION_ALERTS)
+}
+
+func TestAnsiControls(t *testing.T) {
+	// escape sequences in the input must not reach the terminal
+	var tests = []string{
+		"a\x1b[2Jb \x1d\x1e\x1f c\n",
+		"a[2Jb c\n",
+
+		"`\x1b[2J`\n",
+		"\x1b[33m[2J\x1b[39m\n",
+
+		"```\n\x1b[2J\tx\n```\n",
+		"┌──────────┐\n│ [2J    x │\n└──────────┘\n",
+
+		"<div>\x1b[2J</div>\n",
+		"<div>[2J</div>\n",
+
+		"<http://example.com/\x1b[2J> &#27;[2J\n",
+		"\x1b[4mhttp://example.com/[2J\x1b[24m [2J\n",
+	}
+	doTestsAnsi(t, tests, 0, EXTENSION_FENCED_CODE)
+}
+
+func TestAnsiWrap(t *testing.T) {
+	var tests = []string{
+		"one two three four five six\n",
+		"one two\nthree\nfour five\nsix\n",
+
+		"*one two* three\n",
+		"\x1b[3mone two\x1b[23m\nthree\n",
+
+		"> one two three\n",
+		"│ one two\n│ three\n",
+
+		"* one two three\n",
+		"• one two\n  three\n",
+
+		"unbreakable-word\n",
+		"unbreakable-word\n",
+
+		"    code is never wrapped\n",
+		"┌───────────────────────┐\n│ code is never wrapped │\n└───────────────────────┘\n",
+	}
+	doTestsAnsi(t, tests, 9, 0)
+}
+
+func TestAnsiWrapLinks(t *testing.T) {
+	var tests = []string{
+		"see [the manual](http://example.com/) now\n",
+		"see\n\x1b[4mthe manual\x1b[24m (http://example.com/)\nnow\n",
+	}
+	doTestsAnsi(t, tests, 9, 0)
+}
+
+func TestAnsiNumberedLinks(t *testing.T) {
+	input := "see [one](http://a/) and [two](http://b/),\n" +
+		"[one again](http://a/), and <http://c/>\n"
+	expected := "see \x1b[4mone\x1b[24m[1] and \x1b[4mtwo\x1b[24m[2], \x1b[4mone again\x1b[24m[1], and\n" +
+		"\x1b[4mhttp://c/\x1b[24m\n" +
+		"\n" + strings.Repeat("─", 40) + "\n[1] http://a/\n[2] http://b/\n"
+	r := AnsiRenderer(40).(*Ansi)
+	r.SetNumberedLinks(true)
+	for i := 0; i < 2; i++ {
+		if actual := string(Markdown([]byte(input), r, EXTENSION_AUTOLINK)); actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+		}
+	}
+}
@@ -15,6 +15,7 @@ package blackfriday
 
 import (
 	"bytes"
+	"strings"
 )
 
 // Parse block-level data.
@@ -25,14 +26,25 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 		panic("block input is missing terminating newline")
 	}
 
-	// this is called recursively: enforce a maximum depth
+	// this is called recursively: enforce a maximum depth, keeping what is
+	// nested too deeply as plain text
 	if p.nesting >= p.maxNesting {
+		if text := bytes.TrimRight(data, "\n"); len(bytes.TrimSpace(text)) > 0 {
+			p.r.Paragraph(out, func() bool {
+				p.r.NormalText(out, text)
+				return true
+			})
+		}
 		return
 	}
 	p.nesting++
 
 	// parse out one block-level construct at a time
 	for len(data) > 0 {
+		if p.topBlock != nil && p.nesting == 1 && !p.topBlock(out, data) {
+			break
+		}
+
 		// prefixed header:
 		//
 		// # Header 1
@@ -92,6 +104,30 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 			}
 		}
 
+		// collapsible section:
+		//
+		// ::: details Summary
+		// Content
+		// :::
+		if p.flags&EXTENSION_DETAILS != 0 {
+			if i := p.details(out, data); i > 0 {
+				data = data[i:]
+				continue
+			}
+		}
+
+		// directive:
+		//
+		// ::: warning {#id .class key="value"}
+		// Content
+		// :::
+		if p.flags&EXTENSION_DIRECTIVES != 0 {
+			if i := p.directive(out, data); i > 0 {
+				data = data[i:]
+				continue
+			}
+		}
+
 		// horizontal rule:
 		//
 		// ------
@@ -100,10 +136,11 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 		// or
 		// ______
 		if p.isHRule(data) {
-			p.r.HRule(out)
 			var i int
 			for i = 0; data[i] != '\n'; i++ {
 			}
+			p.markSource(data, 0, i)
+			p.r.HRule(out)
 			data = data[i:]
 			continue
 		}
@@ -130,6 +167,20 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 			}
 		}
 
+		// grid table:
+		//
+		// +------+-----+
+		// | Name | Age |
+		// +======+=====+
+		// | Bob  | 31  |
+		// +------+-----+
+		if p.flags&EXTENSION_GRID_TABLES != 0 && data[0] == '+' {
+			if i := p.gridTable(out, data); i > 0 {
+				data = data[i:]
+				continue
+			}
+		}
+
 		// an itemized/unordered list:
 		//
 		// * Item 1
@@ -145,8 +196,12 @@ func (p *parser) block(out *bytes.Buffer, data []byte) {
 		//
 		// 1. Item 1
 		// 2. Item 2
+		// with EXTENSION_LETTERED_LISTS, this also accepts
+		//
+		// a. Item a    A. Item A    i. Item i    I. Item I
+		// b. Item b    B. Item B    ii. Item ii  II. Item II
 		if p.oliPrefix(data) > 0 {
-			data = data[p.list(out, data, LIST_TYPE_ORDERED):]
+			data = data[p.list(out, data, p.oliType(data)):]
 			continue
 		}
 
@@ -186,6 +241,8 @@ func (p *parser) prefixHeader(out *bytes.Buffer, data []byte) int {
 	for end = i; data[end] != '\n'; end++ {
 	}
 	skip := end
+	size, id := p.headerID(data[i:end])
+	end = i + size
 	for end > 0 && data[end-1] == '#' {
 		end--
 	}
@@ -197,15 +254,62 @@ func (p *parser) prefixHeader(out *bytes.Buffer, data []byte) int {
 			p.inline(out, data[i:end])
 			return true
 		}
-		p.r.Header(out, work, level)
+		p.markSource(data, 0, skip)
+		p.r.Header(out, work, level, id)
 	}
 	return skip
 }
 
+// Check for a header id such as {#intro} ending the text of a header, with
+// EXTENSION_HEADER_IDS. Returns the size of the text before it, without
+// trailing spaces, and the id, or the size of the whole text and "" if
+// there is none. An id is made of letters, digits, and - _ : . characters.
+func (p *parser) headerID(text []byte) (size int, id string) {
+	end := len(text)
+	for end > 0 && text[end-1] == ' ' {
+		end--
+	}
+	if p.flags&EXTENSION_HEADER_IDS == 0 || end == 0 || text[end-1] != '}' {
+		return len(text), ""
+	}
+	start := bytes.LastIndex(text[:end], []byte("{#"))
+	if start < 0 || start+3 > end-1 || isBackslashEscaped(text, start) {
+		return len(text), ""
+	}
+	for _, c := range text[start+2 : end-1] {
+		if !isalnum(c) && c != '-' && c != '_' && c != ':' && c != '.' {
+			return len(text), ""
+		}
+	}
+	id = string(text[start+2 : end-1])
+	for start > 0 && text[start-1] == ' ' {
+		start--
+	}
+	return start, id
+}
+
+// Setext underlines made of '-' look just like horizontal rules. They are
+// told apart as follows:
+//
+//  1. A line of only '-' characters (optionally indented up to three spaces
+//     and followed by spaces) directly after paragraph text underlines that
+//     text as a level 2 header.
+//  2. Any other horizontal rule line is a horizontal rule, including one at
+//     the start of a block, after a blank line, after a non-paragraph block,
+//     or one with spaces between the dashes ("- - -").
+//  3. A horizontal rule line never lazily continues a blockquote or a list
+//     item; it ends them and is rendered as a horizontal rule.
+//
+// Returns the header level for an underline, or 0 if data does not start
+// with one.
 func (p *parser) isUnderlinedHeader(data []byte) int {
+	i := 0
+	for i < 3 && data[i] == ' ' {
+		i++
+	}
+
 	// test of level 1 header
-	if data[0] == '=' {
-		i := 1
+	if data[i] == '=' {
 		for data[i] == '=' {
 			i++
 		}
@@ -220,8 +324,7 @@ func (p *parser) isUnderlinedHeader(data []byte) int {
 	}
 
 	// test of level 2 header
-	if data[0] == '-' {
-		i := 1
+	if data[i] == '-' {
 		for data[i] == '-' {
 			i++
 		}
@@ -263,6 +366,12 @@ func (p *parser) html(out *bytes.Buffer, data []byte, doRender bool) int {
 		return 0
 	}
 
+	if p.flags&EXTENSION_MARKDOWN_IN_HTML != 0 {
+		if size := p.htmlMarkdown(out, data, curtag, doRender); size > 0 {
+			return size
+		}
+	}
+
 	// look for an unindented matching closing tag
 	// followed by a blank line
 	found := false
@@ -336,6 +445,125 @@ func (p *parser) html(out *bytes.Buffer, data []byte, doRender bool) int {
 	return i
 }
 
+// Block tag marked markdown="1", whose contents are parsed as markdown. The
+// block ends at the closing tag that matches the opening one, counting nested
+// tags of the same name, and nothing but spaces may follow it on its line.
+func (p *parser) htmlMarkdown(out *bytes.Buffer, data []byte, tag string, doRender bool) int {
+	open, openTag := markdownAttribute(data)
+	if open == 0 {
+		return 0
+	}
+
+	// find the matching closing tag
+	closeTag := []byte("</" + tag + ">")
+	depth, i := 1, open
+	for ; i < len(data); i++ {
+		if data[i] != '<' {
+			continue
+		}
+		if bytes.HasPrefix(data[i:], closeTag) {
+			if depth--; depth == 0 {
+				break
+			}
+		} else if bytes.HasPrefix(data[i+1:], []byte(tag)) && i+1+len(tag) < len(data) {
+			if c := data[i+1+len(tag)]; isspace(c) || c == '>' || c == '/' {
+				depth++
+			}
+		}
+	}
+	if i >= len(data) {
+		return 0
+	}
+	end := i + len(closeTag)
+	skip := p.isEmpty(data[end:])
+	if skip == 0 && end < len(data) {
+		return 0
+	}
+
+	if doRender {
+		contents := data[open:i]
+		if skip := p.isEmpty(contents); skip > 0 {
+			// the opening tag ends its line
+			contents = contents[skip:]
+		}
+		p.r.BlockHtml(out, openTag)
+		if len(bytes.TrimSpace(contents)) > 0 {
+			if contents[len(contents)-1] != '\n' {
+				contents = append(append([]byte(nil), contents...), '\n')
+			}
+			p.block(out, contents)
+		}
+		p.r.BlockHtml(out, closeTag)
+	}
+	return end + skip
+}
+
+// Find the end of the opening tag at the start of data, if the tag has a
+// markdown="1" attribute. Returns the size of the tag and the tag without
+// the attribute, or 0 if the tag is unmarked or does not end.
+func markdownAttribute(data []byte) (int, []byte) {
+	i := 1
+	for i < len(data) && isalnum(data[i]) {
+		i++
+	}
+	cutStart, cutEnd := 0, 0
+	for i < len(data) {
+		attrStart := i
+		for i < len(data) && isspace(data[i]) {
+			i++
+		}
+		if i >= len(data) {
+			break
+		}
+		if data[i] == '>' || (data[i] == '/' && i+1 < len(data) && data[i+1] == '>') {
+			if cutEnd == 0 || data[i] == '/' {
+				return 0, nil
+			}
+			tag := append(append([]byte(nil), data[:cutStart]...), data[cutEnd:i+1]...)
+			return i + 1, tag
+		}
+
+		// an attribute name, then an optional quoted or unquoted value
+		nameStart := i
+		for i < len(data) && !isspace(data[i]) && data[i] != '=' && data[i] != '>' && data[i] != '/' {
+			i++
+		}
+		name := data[nameStart:i]
+		var value []byte
+		if i < len(data) && data[i] == '=' {
+			i++
+			if i < len(data) && (data[i] == '"' || data[i] == '\'') {
+				quote := data[i]
+				i++
+				valueStart := i
+				for i < len(data) && data[i] != quote {
+					i++
+				}
+				if i >= len(data) {
+					break
+				}
+				value = data[valueStart:i]
+				i++
+			} else {
+				valueStart := i
+				for i < len(data) && !isspace(data[i]) && data[i] != '>' {
+					i++
+				}
+				value = data[valueStart:i]
+			}
+		}
+		if len(name) == 0 {
+			// a stray character, such as a slash inside the tag
+			i++
+			continue
+		}
+		if bytes.EqualFold(name, []byte("markdown")) && string(value) == "1" {
+			cutStart, cutEnd = attrStart, i
+		}
+	}
+	return 0, nil
+}
+
 // HTML comment, lax form
 func (p *parser) htmlComment(out *bytes.Buffer, data []byte, doRender bool) int {
 	if data[0] != '<' || data[1] != '!' || data[2] != '-' || data[3] != '-' {
@@ -523,54 +751,49 @@ func (p *parser) isFencedCode(data []byte, syntax **string, oldmarker string) (s
 	}
 	marker = string(data[i-size : i])
 
-	// if this is the end marker, it must match the beginning marker
-	if oldmarker != "" && marker != oldmarker {
-		return
+	// if this is the end marker, it must match the beginning marker, so
+	// a block can hold lines of shorter or longer fences as its content;
+	// in CommonMark it may be longer, and only shorter fences are content
+	if oldmarker != "" {
+		if p.flags&EXTENSION_COMMONMARK != 0 {
+			if c != oldmarker[0] || size < len(oldmarker) {
+				return
+			}
+		} else if marker != oldmarker {
+			return
+		}
 	}
 
 	if syntax != nil {
-		syn := 0
-
 		for data[i] == ' ' {
 			i++
 		}
 
-		syntaxStart := i
-
-		if data[i] == '{' {
+		// the info string is the rest of the line, kept verbatim
+		infoStart := i
+		for data[i] != '\n' {
 			i++
-			syntaxStart++
-
-			for data[i] != '}' && data[i] != '\n' {
-				syn++
-				i++
-			}
-
-			if data[i] != '}' {
-				return
-			}
-
-			// strip all whitespace at the beginning and the end
-			// of the {} block
-			for syn > 0 && isspace(data[syntaxStart]) {
-				syntaxStart++
-				syn--
-			}
+		}
+		infoEnd := i
+		for infoEnd > infoStart && isspace(data[infoEnd-1]) {
+			infoEnd--
+		}
 
-			for syn > 0 && isspace(data[syntaxStart+syn-1]) {
-				syn--
-			}
+		// a brace-delimited language list must be closed
+		if infoEnd > infoStart && data[infoStart] == '{' &&
+			bytes.IndexByte(data[infoStart:infoEnd], '}') < 0 {
+			return
+		}
 
-			i++
-		} else {
-			for !isspace(data[i]) {
-				syn++
-				i++
-			}
+		// in CommonMark, the info string of a backtick fence cannot hold
+		// backticks, so that a line starting with a code span is not one
+		if c == '`' && p.flags&EXTENSION_COMMONMARK != 0 &&
+			bytes.IndexByte(data[infoStart:infoEnd], '`') >= 0 {
+			return
 		}
 
-		language := string(data[syntaxStart : syntaxStart+syn])
-		*syntax = &language
+		info := string(data[infoStart:infoEnd])
+		*syntax = &info
 	}
 
 	for data[i] == ' ' {
@@ -625,11 +848,310 @@ func (p *parser) fencedCode(out *bytes.Buffer, data []byte) int {
 		syntax = *lang
 	}
 
+	p.markSource(data, 0, beg)
 	p.r.BlockCode(out, work.Bytes(), syntax)
 
 	return beg
 }
 
+// Return the size of the fenced code block at the start of data, without
+// rendering it, or 0 if there is none.
+func (p *parser) fencedCodeSize(data []byte) int {
+	var info *string
+	beg, marker := p.isFencedCode(data, &info, "")
+	if beg == 0 {
+		return 0
+	}
+	for beg < len(data) {
+		if end, _ := p.isFencedCode(data[beg:], nil, marker); end > 0 {
+			return beg + end
+		}
+		for data[beg] != '\n' {
+			beg++
+		}
+		beg++
+	}
+	return 0
+}
+
+// Check for the line opening a collapsible section: three or more colons,
+// the word details, an optional lowercase open, and the summary. Returns
+// the size of the line, the colons, the summary, and the DETAILS_* flags.
+func (p *parser) isDetailsStart(data []byte) (skip int, fence string, summary []byte, flags int) {
+	i := 0
+	for i < 3 && data[i] == ' ' {
+		i++
+	}
+	start := i
+	for data[i] == ':' {
+		i++
+	}
+	if i-start < 3 {
+		return 0, "", nil, 0
+	}
+	fence = string(data[start:i])
+	for data[i] == ' ' {
+		i++
+	}
+	if !bytes.HasPrefix(data[i:], []byte("details")) {
+		return 0, "", nil, 0
+	}
+	i += len("details")
+	if data[i] != ' ' && data[i] != '\n' {
+		return 0, "", nil, 0
+	}
+
+	end := i
+	for data[end] != '\n' {
+		end++
+	}
+	summary = bytes.TrimSpace(data[i:end])
+	if bytes.Equal(summary, []byte("open")) || bytes.HasPrefix(summary, []byte("open ")) {
+		flags |= DETAILS_OPEN
+		summary = bytes.TrimSpace(summary[len("open"):])
+	}
+	return end + 1, fence, summary, flags
+}
+
+// Check for the line opening a directive: three or more colons, a name
+// made of letters, digits, - and _ starting with a letter, and optionally
+// attributes in braces. Returns the size of the line, the colons, the
+// name, and the attributes.
+func (p *parser) isDirectiveStart(data []byte) (skip int, fence, name string, attrs []DirectiveAttr) {
+	i := 0
+	for i < 3 && data[i] == ' ' {
+		i++
+	}
+	start := i
+	for data[i] == ':' {
+		i++
+	}
+	if i-start < 3 {
+		return 0, "", "", nil
+	}
+	fence = string(data[start:i])
+	for data[i] == ' ' {
+		i++
+	}
+	nameStart := i
+	if !isletter(data[i]) {
+		return 0, "", "", nil
+	}
+	for isalnum(data[i]) || data[i] == '-' || data[i] == '_' {
+		i++
+	}
+	name = string(data[nameStart:i])
+	for data[i] == ' ' {
+		i++
+	}
+	if data[i] == '{' {
+		size := bytes.IndexByte(data[i:], '}')
+		if size < 0 || bytes.IndexByte(data[i:i+size], '\n') >= 0 {
+			return 0, "", "", nil
+		}
+		var ok bool
+		if attrs, ok = parseDirectiveAttrs(data[i+1 : i+size]); !ok {
+			return 0, "", "", nil
+		}
+		i += size + 1
+		for data[i] == ' ' {
+			i++
+		}
+	}
+	if data[i] != '\n' {
+		return 0, "", "", nil
+	}
+	return i + 1, fence, name, attrs
+}
+
+// Parse the attributes of a directive, separated by spaces: #id, .class,
+// key=value, or key="value". A key is made of letters, digits, and - _ :
+// characters starting with a letter. No value holds braces, and a bare
+// value has no spaces or quotes either. Returns false if the attributes do
+// not follow this form.
+func parseDirectiveAttrs(data []byte) ([]DirectiveAttr, bool) {
+	var attrs []DirectiveAttr
+	isValueChar := func(c byte) bool {
+		return c != ' ' && c != '"' && c != '\'' && c != '{' && c != '}' && c != '='
+	}
+	i := 0
+	for {
+		for i < len(data) && data[i] == ' ' {
+			i++
+		}
+		if i >= len(data) {
+			return attrs, true
+		}
+
+		var attr DirectiveAttr
+		switch data[i] {
+		case '#', '.':
+			attr.Key = "id"
+			if data[i] == '.' {
+				attr.Key = "class"
+			}
+			i++
+			start := i
+			for i < len(data) && isValueChar(data[i]) {
+				i++
+			}
+			if i == start {
+				return nil, false
+			}
+			attr.Value = string(data[start:i])
+
+		default:
+			start := i
+			if !isletter(data[i]) {
+				return nil, false
+			}
+			for i < len(data) && (isalnum(data[i]) || data[i] == '-' || data[i] == '_' || data[i] == ':') {
+				i++
+			}
+			attr.Key = string(data[start:i])
+			if i >= len(data) || data[i] != '=' {
+				return nil, false
+			}
+			i++
+			if i < len(data) && data[i] == '"' {
+				end := bytes.IndexByte(data[i+1:], '"')
+				if end < 0 {
+					return nil, false
+				}
+				attr.Value = string(data[i+1 : i+1+end])
+				i += end + 2
+			} else {
+				start = i
+				for i < len(data) && isValueChar(data[i]) {
+					i++
+				}
+				if i == start {
+					return nil, false
+				}
+				attr.Value = string(data[start:i])
+			}
+		}
+		if i < len(data) && data[i] != ' ' {
+			return nil, false
+		}
+		attrs = append(attrs, attr)
+	}
+}
+
+// Check for a line opening a collapsible section or a directive, with the
+// extensions that enable them. Returns the size of the line and the colons.
+func (p *parser) isContainerStart(data []byte) (skip int, fence string) {
+	if p.flags&EXTENSION_DETAILS != 0 {
+		if skip, fence, _, _ = p.isDetailsStart(data); skip > 0 {
+			return skip, fence
+		}
+	}
+	if p.flags&EXTENSION_DIRECTIVES != 0 {
+		if skip, fence, _, _ = p.isDirectiveStart(data); skip > 0 {
+			return skip, fence
+		}
+	}
+	return 0, ""
+}
+
+// Check for the line closing a collapsible section or a directive, which
+// has the same number of colons as the opening line. Returns the size of
+// the line.
+func (p *parser) isContainerEnd(data []byte, fence string) int {
+	i := 0
+	for i < 3 && data[i] == ' ' {
+		i++
+	}
+	if !bytes.HasPrefix(data[i:], []byte(fence)) {
+		return 0
+	}
+	i += len(fence)
+	for data[i] == ' ' {
+		i++
+	}
+	if data[i] != '\n' {
+		return 0
+	}
+	return i + 1
+}
+
+// Find the closing fence of a collapsible section or a directive whose
+// content starts at beg. The content can hold containers with the same
+// fence, and fenced code blocks whose lines are never taken as fences.
+// Returns the end of the content and the size of the container, or 0 if
+// there is no closing fence.
+func (p *parser) containerEnd(data []byte, beg int, fence string) (end, size int) {
+	i := beg
+	for depth := 1; depth > 0; {
+		if i >= len(data) {
+			// no closing fence
+			return 0, 0
+		}
+		if p.flags&EXTENSION_FENCED_CODE != 0 {
+			if size := p.fencedCodeSize(data[i:]); size > 0 {
+				i += size
+				continue
+			}
+		}
+		if skip, inner := p.isContainerStart(data[i:]); skip > 0 && inner == fence {
+			depth++
+			i += skip
+			continue
+		}
+		if skip := p.isContainerEnd(data[i:], fence); skip > 0 {
+			if depth--; depth == 0 {
+				end = i
+			}
+			i += skip
+			continue
+		}
+		for data[i] != '\n' {
+			i++
+		}
+		i++
+	}
+	return end, i
+}
+
+// parse a collapsible section
+func (p *parser) details(out *bytes.Buffer, data []byte) int {
+	beg, fence, summary, flags := p.isDetailsStart(data)
+	if beg == 0 {
+		return 0
+	}
+	end, i := p.containerEnd(data, beg, fence)
+	if i == 0 {
+		return 0
+	}
+
+	var title, content bytes.Buffer
+	p.inline(&title, summary)
+	if len(bytes.TrimSpace(data[beg:end])) > 0 {
+		p.block(&content, data[beg:end])
+	}
+	p.r.Details(out, title.Bytes(), content.Bytes(), flags)
+	return i
+}
+
+// parse a directive
+func (p *parser) directive(out *bytes.Buffer, data []byte) int {
+	beg, fence, name, attrs := p.isDirectiveStart(data)
+	if beg == 0 {
+		return 0
+	}
+	end, i := p.containerEnd(data, beg, fence)
+	if i == 0 {
+		return 0
+	}
+
+	var content bytes.Buffer
+	if len(bytes.TrimSpace(data[beg:end])) > 0 {
+		p.block(&content, data[beg:end])
+	}
+	p.r.Directive(out, name, attrs, content.Bytes())
+	return i
+}
+
 func (p *parser) table(out *bytes.Buffer, data []byte) int {
 	var header bytes.Buffer
 	i, columns := p.tableHeader(&header, data)
@@ -657,6 +1179,7 @@ func (p *parser) table(out *bytes.Buffer, data []byte) int {
 		p.tableRow(&body, data[rowStart:i], columns, false)
 	}
 
+	p.markSource(data, 0, i)
 	p.r.Table(out, header.Bytes(), body.Bytes(), columns)
 
 	return i
@@ -671,13 +1194,68 @@ func isBackslashEscaped(data []byte, i int) bool {
 	return backslashes&1 == 1
 }
 
+// The index in data, a table line, of the pipe or newline that ends the
+// cell at i. Escaped pipes and pipes in code spans do not end it.
+func tableCellEnd(data []byte, i int) int {
+	for data[i] != '\n' {
+		switch {
+		case data[i] == '|' && !isBackslashEscaped(data, i):
+			return i
+		case data[i] == '`' && !isBackslashEscaped(data, i):
+			i = skipCodeSpan(data, i)
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+// Skip the code span starting at data[i], or only its opening backticks if
+// it does not end on the same line.
+func skipCodeSpan(data []byte, i int) int {
+	n := 0
+	for data[i+n] == '`' {
+		n++
+	}
+	end := i + n
+	for data[end] != '\n' {
+		if data[end] != '`' {
+			end++
+			continue
+		}
+		run := 0
+		for data[end+run] == '`' {
+			run++
+		}
+		if run >= n {
+			return end + n
+		}
+		end += run
+	}
+	return i + n
+}
+
+// Remove the backslashes that escape pipes in a table cell, which the
+// inline parser would keep in code spans.
+func unescapePipes(cell []byte) []byte {
+	if !bytes.Contains(cell, []byte("\\|")) {
+		return cell
+	}
+	var out []byte
+	for i, c := range cell {
+		if c == '|' && isBackslashEscaped(cell, i) {
+			out = out[:len(out)-1]
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
 func (p *parser) tableHeader(out *bytes.Buffer, data []byte) (size int, columns []int) {
 	i := 0
 	colCount := 1
-	for i = 0; data[i] != '\n'; i++ {
-		if data[i] == '|' && !isBackslashEscaped(data, i) {
-			colCount++
-		}
+	for i = tableCellEnd(data, 0); data[i] != '\n'; i = tableCellEnd(data, i+1) {
+		colCount++
 	}
 
 	// doesn't look like a table header
@@ -791,10 +1369,7 @@ func (p *parser) tableRow(out *bytes.Buffer, data []byte, columns []int, header
 
 		cellStart := i
 
-		for (data[i] != '|' || isBackslashEscaped(data, i)) && data[i] != '\n' {
-			i++
-		}
-
+		i = tableCellEnd(data, i)
 		cellEnd := i
 
 		// skip the end-of-cell marker, possibly taking us past end of buffer
@@ -804,13 +1379,18 @@ func (p *parser) tableRow(out *bytes.Buffer, data []byte, columns []int, header
 			cellEnd--
 		}
 
+		align, skip := cellAlignment(data[cellStart:cellEnd])
+		if skip == 0 {
+			align = columns[col]
+		}
+
 		var cellWork bytes.Buffer
-		p.inline(&cellWork, data[cellStart:cellEnd])
+		p.inline(&cellWork, unescapePipes(data[cellStart+skip:cellEnd]))
 
 		if header {
-			p.r.TableHeaderCell(&rowWork, cellWork.Bytes(), columns[col])
+			p.r.TableHeaderCell(&rowWork, cellWork.Bytes(), align)
 		} else {
-			p.r.TableCell(&rowWork, cellWork.Bytes(), columns[col])
+			p.r.TableCell(&rowWork, cellWork.Bytes(), align)
 		}
 	}
 
@@ -828,6 +1408,183 @@ func (p *parser) tableRow(out *bytes.Buffer, data []byte, columns []int, header
 	p.r.TableRow(out, rowWork.Bytes())
 }
 
+// Check for an alignment override at the start of a table cell: {:-} for
+// left, {-:} for right or {:-:} for center, followed by a space or the end
+// of the cell. Returns the alignment and the size of the marker with its
+// trailing spaces, or 0 if there is none.
+func cellAlignment(data []byte) (align, size int) {
+	for _, m := range []struct {
+		marker string
+		align  int
+	}{
+		{"{:-}", TABLE_ALIGNMENT_LEFT},
+		{"{-:}", TABLE_ALIGNMENT_RIGHT},
+		{"{:-:}", TABLE_ALIGNMENT_CENTER},
+	} {
+		if !bytes.HasPrefix(data, []byte(m.marker)) {
+			continue
+		}
+		size = len(m.marker)
+		if size < len(data) && data[size] != ' ' {
+			return 0, 0
+		}
+		for size < len(data) && data[size] == ' ' {
+			size++
+		}
+		return m.align, size
+	}
+	return 0, 0
+}
+
+// Check for a border line of a grid table, such as +---+:==:+, with its
+// plus signs at the given columns, or anywhere if columns is nil. Returns
+// the size of the line, the columns of the plus signs, whether the line is
+// drawn with = to end the header, and the alignment of each column.
+func gridBorder(data []byte, columns []int) (size int, plus []int, header bool, align []int) {
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 || data[0] != '+' {
+		return 0, nil, false, nil
+	}
+	line := bytes.TrimRight(data[:end], " ")
+
+	dashes := false
+	for i, c := range line {
+		switch c {
+		case '+':
+			if len(plus) > 0 && plus[len(plus)-1] == i-1 {
+				// every column needs some width
+				return 0, nil, false, nil
+			}
+			plus = append(plus, i)
+		case '-':
+			dashes = true
+		case '=':
+			header = true
+		case ':':
+		default:
+			return 0, nil, false, nil
+		}
+	}
+	if (dashes && header) || len(plus) < 2 || plus[len(plus)-1] != len(line)-1 {
+		return 0, nil, false, nil
+	}
+	if columns != nil {
+		if len(plus) != len(columns) {
+			return 0, nil, false, nil
+		}
+		for i := range plus {
+			if plus[i] != columns[i] {
+				return 0, nil, false, nil
+			}
+		}
+	}
+
+	align = make([]int, len(plus)-1)
+	for i := range align {
+		if line[plus[i]+1] == ':' {
+			align[i] |= TABLE_ALIGNMENT_LEFT
+		}
+		if line[plus[i+1]-1] == ':' {
+			align[i] |= TABLE_ALIGNMENT_RIGHT
+		}
+	}
+	return end + 1, plus, header, align
+}
+
+// Split a line of a grid table row into the text of its cells, if it has
+// a bar at each of the columns. Columns count characters, not bytes.
+func gridRowLine(data []byte, columns []int) (size int, cells []string) {
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 || data[0] != '|' {
+		return 0, nil
+	}
+	line := []rune(string(bytes.TrimRight(data[:end], " ")))
+	if len(line) != columns[len(columns)-1]+1 {
+		return 0, nil
+	}
+	for _, col := range columns {
+		if line[col] != '|' {
+			return 0, nil
+		}
+	}
+	for i := 0; i+1 < len(columns); i++ {
+		cells = append(cells, strings.TrimSpace(string(line[columns[i]+1:columns[i+1]])))
+	}
+	return end + 1, cells
+}
+
+// parse a grid table. Each row ends at a border line; the lines of a cell
+// are joined into one line of text. A border drawn with = ends the header,
+// and its colons, or those of the top border if there is no header, set
+// the alignment of the columns.
+func (p *parser) gridTable(out *bytes.Buffer, data []byte) int {
+	i, columns, header, align := gridBorder(data, nil)
+	if i == 0 || header {
+		return 0
+	}
+
+	var rows [][]string
+	var cells []string
+	headerRows, end := 0, 0
+	for i < len(data) {
+		if size, line := gridRowLine(data[i:], columns); size > 0 {
+			if cells == nil {
+				cells = make([]string, len(line))
+			}
+			for col, text := range line {
+				if text != "" && cells[col] != "" {
+					cells[col] += " "
+				}
+				cells[col] += text
+			}
+			i += size
+			continue
+		}
+		if size, _, isHeader, headerAlign := gridBorder(data[i:], columns); size > 0 && cells != nil {
+			rows = append(rows, cells)
+			cells = nil
+			if isHeader && headerRows == 0 {
+				headerRows = len(rows)
+				align = headerAlign
+			}
+			i += size
+			end = i
+			continue
+		}
+		break
+	}
+	if len(rows) == 0 {
+		return 0
+	}
+
+	var headerWork, body bytes.Buffer
+	for r, row := range rows {
+		var rowWork bytes.Buffer
+		for col, text := range row {
+			cellAlign, skip := cellAlignment([]byte(text))
+			if skip == 0 {
+				cellAlign = align[col]
+			}
+			var cellWork bytes.Buffer
+			p.inline(&cellWork, []byte(text[skip:]))
+			if r < headerRows {
+				p.r.TableHeaderCell(&rowWork, cellWork.Bytes(), cellAlign)
+			} else {
+				p.r.TableCell(&rowWork, cellWork.Bytes(), cellAlign)
+			}
+		}
+		if r < headerRows {
+			p.r.TableRow(&headerWork, rowWork.Bytes())
+		} else {
+			p.r.TableRow(&body, rowWork.Bytes())
+		}
+	}
+	p.markSource(data, 0, end)
+	p.r.Table(out, headerWork.Bytes(), body.Bytes(), align)
+
+	return end
+}
+
 // returns blockquote prefix length
 func (p *parser) quotePrefix(data []byte) int {
 	i := 0
@@ -846,6 +1603,7 @@ func (p *parser) quotePrefix(data []byte) int {
 // parse a blockquote fragment
 func (p *parser) quote(out *bytes.Buffer, data []byte) int {
 	var raw bytes.Buffer
+	var lines []sourceLine
 	beg, end := 0, 0
 	for beg < len(data) {
 		end = beg
@@ -857,6 +1615,10 @@ func (p *parser) quote(out *bytes.Buffer, data []byte) int {
 		if pre := p.quotePrefix(data[beg:]); pre > 0 {
 			// skip the prefix
 			beg += pre
+		} else if p.isHRule(data[beg:]) {
+			// a horizontal rule cannot lazily continue the blockquote
+			end = beg
+			break
 		} else if p.isEmpty(data[beg:]) > 0 &&
 			(end >= len(data) ||
 				(p.quotePrefix(data[end:]) == 0 && p.isEmpty(data[end:]) == 0)) {
@@ -866,16 +1628,60 @@ func (p *parser) quote(out *bytes.Buffer, data []byte) int {
 		}
 
 		// this line is part of the blockquote
+		if p.trackLines {
+			lines = append(lines, p.copiedLine(raw.Len(), data[beg:]))
+		}
 		raw.Write(data[beg:end])
 		beg = end
 	}
+	maps := len(p.lineMaps)
+	if len(lines) > 0 {
+		p.lineMaps = append(p.lineMaps, lineMap{raw.Bytes(), lines})
+	}
 
 	var cooked bytes.Buffer
+	if p.flags&EXTENSION_ALERTS != 0 {
+		if kind, size := alertMarker(raw.Bytes()); size > 0 {
+			if size < raw.Len() {
+				p.block(&cooked, raw.Bytes()[size:])
+			}
+			p.lineMaps = p.lineMaps[:maps]
+			p.markSource(data, 0, end)
+			p.r.Alert(out, cooked.Bytes(), kind)
+			return end
+		}
+	}
 	p.block(&cooked, raw.Bytes())
+	p.lineMaps = p.lineMaps[:maps]
+	p.markSource(data, 0, end)
 	p.r.BlockQuote(out, cooked.Bytes())
 	return end
 }
 
+// Find an alert marker, such as [!NOTE], alone on the first line of a
+// blockquote. Returns its kind and the size of the line, or 0 if the line
+// is not a marker GitHub recognizes.
+func alertMarker(data []byte) (kind, size int) {
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		end = len(data)
+	}
+	line := bytes.TrimRight(data[:end], " ")
+	if len(line) < 4 || !bytes.HasPrefix(line, []byte("[!")) || line[len(line)-1] != ']' {
+		return 0, 0
+	}
+	name := line[2 : len(line)-1]
+	for kind, alert := range alertTypes {
+		if bytes.EqualFold(name, []byte(alert)) {
+			if end < len(data) {
+				end++
+			}
+			return kind, end
+		}
+	}
+	return 0, 0
+}
+
 // returns prefix length for block code
 func (p *parser) codePrefix(data []byte) int {
 	if data[0] == ' ' && data[1] == ' ' && data[2] == ' ' && data[3] == ' ' {
@@ -924,6 +1730,7 @@ func (p *parser) code(out *bytes.Buffer, data []byte) int {
 
 	work.WriteByte('\n')
 
+	p.markSource(data, 0, i)
 	p.r.BlockCode(out, work.Bytes(), "")
 
 	return i
@@ -961,6 +1768,16 @@ func (p *parser) oliPrefix(data []byte) int {
 		i++
 	}
 
+	// or the letters, if lettered markers are enabled
+	if start == i && p.flags&EXTENSION_LETTERED_LISTS != 0 {
+		for isletter(data[i]) {
+			i++
+		}
+		if letteredListType(data[start:i]) == 0 {
+			return 0
+		}
+	}
+
 	// we need >= 1 digits followed by a dot and a space
 	if start == i || data[i] != '.' || data[i+1] != ' ' {
 		return 0
@@ -968,30 +1785,167 @@ func (p *parser) oliPrefix(data []byte) int {
 	return i + 2
 }
 
+// returns the list type flags for an ordered list item,
+// which must already have been identified by oliPrefix
+func (p *parser) oliType(data []byte) int {
+	i := 0
+	for i < 3 && data[i] == ' ' {
+		i++
+	}
+	start := i
+	for data[i] != '.' {
+		i++
+	}
+	if data[start] >= '0' && data[start] <= '9' {
+		return LIST_TYPE_ORDERED
+	}
+	return LIST_TYPE_ORDERED | letteredListType(data[start:i])
+}
+
+// Classify a lettered list marker (without the trailing dot).
+// A single letter gives an alphabetic list, except for i and I, which
+// start roman numeral lists. Longer markers must be well-formed roman
+// numerals written entirely in one case.
+// Returns 0 if the marker is not recognized.
+func letteredListType(marker []byte) int {
+	if len(marker) == 0 {
+		return 0
+	}
+	lower := bytes.ToLower(marker)
+	upper := !bytes.Equal(lower, marker)
+	if upper && !bytes.Equal(bytes.ToUpper(marker), marker) {
+		// mixed case
+		return 0
+	}
+
+	if len(marker) == 1 && lower[0] != 'i' {
+		if upper {
+			return LIST_TYPE_UPPER_ALPHA
+		}
+		return LIST_TYPE_LOWER_ALPHA
+	}
+
+	if !isRomanNumeral(lower) {
+		return 0
+	}
+	if upper {
+		return LIST_TYPE_UPPER_ROMAN
+	}
+	return LIST_TYPE_LOWER_ROMAN
+}
+
+var romanDigits = []struct {
+	value  int
+	symbol string
+}{
+	{1000, "m"}, {900, "cm"}, {500, "d"}, {400, "cd"},
+	{100, "c"}, {90, "xc"}, {50, "l"}, {40, "xl"},
+	{10, "x"}, {9, "ix"}, {5, "v"}, {4, "iv"}, {1, "i"},
+}
+
+// Test if a lowercase string is a roman numeral in canonical form.
+func isRomanNumeral(s []byte) bool {
+	n, rest := 0, s
+	for _, digit := range romanDigits {
+		for bytes.HasPrefix(rest, []byte(digit.symbol)) {
+			n += digit.value
+			rest = rest[len(digit.symbol):]
+		}
+	}
+	if len(rest) > 0 || n >= 4000 {
+		return false
+	}
+
+	// reject non-canonical forms such as iiii or vv
+	var canonical bytes.Buffer
+	for _, digit := range romanDigits {
+		for n >= digit.value {
+			canonical.WriteString(digit.symbol)
+			n -= digit.value
+		}
+	}
+	return bytes.Equal(canonical.Bytes(), s)
+}
+
+// Find the checkbox starting a task list item, [ ] or [x], and the spaces
+// after it. Returns the size of both, or 0 if there is none, and whether
+// the box is checked.
+func taskListMarker(data []byte) (size int, done bool) {
+	if len(data) < 4 || data[0] != '[' || data[2] != ']' || data[3] != ' ' {
+		return 0, false
+	}
+	switch data[1] {
+	case ' ':
+	case 'x', 'X':
+		done = true
+	default:
+		return 0, false
+	}
+	size = 4
+	for size < len(data) && data[size] == ' ' {
+		size++
+	}
+	if size >= len(data) || data[size] == '\n' {
+		// a checkbox needs something to describe
+		return 0, false
+	}
+	return size, done
+}
+
 // parse ordered or unordered list block
 func (p *parser) list(out *bytes.Buffer, data []byte, flags int) int {
-	i := 0
+	// gather all the items first: if any item is parsed as blocks, the
+	// list is loose, and every item is parsed as blocks
+	type item struct {
+		src, raw []byte
+		lines    []sourceLine
+		sublist  int
+	}
+	var items []item
+	i, gathered := 0, flags
+	for i < len(data) {
+		raw, lines, sublist, skip := p.listItem(data[i:], &gathered)
+		if skip == 0 {
+			break
+		}
+		items = append(items, item{data[i : i+skip], raw, lines, sublist})
+		i += skip
+
+		if gathered&LIST_ITEM_END_OF_LIST != 0 {
+			break
+		}
+	}
+
 	flags |= LIST_ITEM_BEGINNING_OF_LIST
 	work := func() bool {
-		for i < len(data) {
-			skip := p.listItem(out, data[i:], &flags)
-			i += skip
-
-			if skip == 0 || flags&LIST_ITEM_END_OF_LIST != 0 {
-				break
+		p.listLevel++
+		for n, item := range items {
+			itemFlags := flags | gathered&LIST_ITEM_CONTAINS_BLOCK
+			if n > 0 {
+				itemFlags &= ^LIST_ITEM_BEGINNING_OF_LIST
+			}
+			if n == len(items)-1 {
+				itemFlags |= gathered & LIST_ITEM_END_OF_LIST
 			}
-			flags &= ^LIST_ITEM_BEGINNING_OF_LIST
+			p.renderListItem(out, item.src, item.raw, item.lines, item.sublist, itemFlags, n+1)
 		}
+		p.listLevel--
 		return true
 	}
 
+	p.markSource(data, 0, i)
 	p.r.List(out, work, flags)
 	return i
 }
 
 // Parse a single list item.
 // Assumes initial prefix is already removed if this is a sublist.
-func (p *parser) listItem(out *bytes.Buffer, data []byte, flags *int) int {
+// Gather the lines of a list item, without their indentation, into raw.
+// sublist is the offset in raw of a nested list, or 0. size is the length
+// of the item in data, or 0 if data does not start with an item.
+//
+// With trackLines, lines tells where the lines of raw came from.
+func (p *parser) listItem(data []byte, flags *int) (raw []byte, lines []sourceLine, sublist, size int) {
 	// keep track of the indentation of the first line
 	itemIndent := 0
 	for itemIndent < 3 && data[itemIndent] == ' ' {
@@ -1003,7 +1957,7 @@ func (p *parser) listItem(out *bytes.Buffer, data []byte, flags *int) int {
 		i = p.oliPrefix(data)
 	}
 	if i == 0 {
-		return 0
+		return nil, nil, 0, 0
 	}
 
 	// skip leading whitespace on first line
@@ -1018,15 +1972,17 @@ func (p *parser) listItem(out *bytes.Buffer, data []byte, flags *int) int {
 	}
 
 	// get working buffer
-	var raw bytes.Buffer
+	var work bytes.Buffer
 
 	// put the first line into the working buffer
-	raw.Write(data[line:i])
+	if p.trackLines {
+		lines = append(lines, p.copiedLine(work.Len(), data[line:]))
+	}
+	work.Write(data[line:i])
 	line = i
 
 	// process the following lines
 	containsBlankLine := false
-	sublist := 0
 
 gatherlines:
 	for line < len(data) {
@@ -1059,21 +2015,31 @@ gatherlines:
 		case (p.uliPrefix(chunk) > 0 && !p.isHRule(chunk)) ||
 			p.oliPrefix(chunk) > 0:
 
-			if containsBlankLine {
-				*flags |= LIST_ITEM_CONTAINS_BLOCK
-			}
-
 			// to be a nested list, it must be indented more
 			// if not, it is the next item in the same list
 			if indent <= itemIndent {
+				if containsBlankLine {
+					*flags |= LIST_ITEM_CONTAINS_BLOCK
+				}
 				break gatherlines
 			}
 
+			// a blank line between the items of the nested list makes
+			// only the nested list loose, which it finds for itself
+			if containsBlankLine && sublist == 0 {
+				*flags |= LIST_ITEM_CONTAINS_BLOCK
+			}
+
 			// is this the first item in the the nested list?
 			if sublist == 0 {
-				sublist = raw.Len()
+				sublist = work.Len()
 			}
 
+		// a horizontal rule that is not indented past the item ends the list
+		case p.isHRule(chunk) && indent <= itemIndent:
+			*flags |= LIST_ITEM_END_OF_LIST
+			break gatherlines
+
 		// is this a nested prefix header?
 		case p.isPrefixHeader(chunk):
 			// if the header is not indented, it is not nested in the list
@@ -1093,7 +2059,7 @@ gatherlines:
 
 		// a blank line means this should be parsed as a block
 		case containsBlankLine:
-			raw.WriteByte('\n')
+			work.WriteByte('\n')
 			*flags |= LIST_ITEM_CONTAINS_BLOCK
 		}
 
@@ -1101,20 +2067,45 @@ gatherlines:
 		// re-introduce the blank into the buffer
 		if containsBlankLine {
 			containsBlankLine = false
-			raw.WriteByte('\n')
+			work.WriteByte('\n')
 		}
 
 		// add the line into the working buffer without prefix
-		raw.Write(data[line+indent : i])
+		if p.trackLines {
+			lines = append(lines, p.copiedLine(work.Len(), data[line+indent:]))
+		}
+		work.Write(data[line+indent : i])
 
 		line = i
 	}
 
-	rawBytes := raw.Bytes()
+	return work.Bytes(), lines, sublist, line
+}
+
+// Render a list item gathered by listItem from src, the index-th of its
+// list.
+func (p *parser) renderListItem(out *bytes.Buffer, src, rawBytes []byte, lines []sourceLine, sublist, flags, index int) {
+	maps := len(p.lineMaps)
+	if len(lines) > 0 {
+		p.lineMaps = append(p.lineMaps, lineMap{rawBytes, lines})
+	}
+
+	if p.flags&EXTENSION_TASK_LISTS != 0 {
+		if size, done := taskListMarker(rawBytes); size > 0 {
+			flags |= LIST_ITEM_TASK
+			if done {
+				flags |= LIST_ITEM_TASK_DONE
+			}
+			rawBytes = rawBytes[size:]
+			if sublist > 0 {
+				sublist -= size
+			}
+		}
+	}
 
 	// render the contents of the list item
 	var cooked bytes.Buffer
-	if *flags&LIST_ITEM_CONTAINS_BLOCK != 0 {
+	if flags&LIST_ITEM_CONTAINS_BLOCK != 0 {
 		// intermediate render of block li
 		if sublist > 0 {
 			p.block(&cooked, rawBytes[:sublist])
@@ -1132,6 +2123,8 @@ gatherlines:
 		}
 	}
 
+	p.lineMaps = p.lineMaps[:maps]
+
 	// render the actual list item
 	cookedBytes := cooked.Bytes()
 	parsedEnd := len(cookedBytes)
@@ -1140,9 +2133,8 @@ gatherlines:
 	for parsedEnd > 0 && cookedBytes[parsedEnd-1] == '\n' {
 		parsedEnd--
 	}
-	p.r.ListItem(out, cookedBytes[:parsedEnd], *flags)
-
-	return line
+	p.markSource(src, 0, len(src))
+	p.r.ListItem(out, cookedBytes[:parsedEnd], flags, index, p.listLevel)
 }
 
 // render a single paragraph that has already been parsed out
@@ -1169,9 +2161,31 @@ func (p *parser) renderParagraph(out *bytes.Buffer, data []byte) {
 		p.inline(out, data[beg:end])
 		return true
 	}
+	p.markSource(data, beg, end)
 	p.r.Paragraph(out, work)
 }
 
+// report whether data starts a block that ends a paragraph in CommonMark: a
+// block quote, fenced code, or a list whose first item is not empty and,
+// if the list is ordered, is numbered 1
+func (p *parser) interruptsParagraph(data []byte) bool {
+	var info *string
+	if p.quotePrefix(data) > 0 {
+		return true
+	}
+	if skip, _ := p.isFencedCode(data, &info, ""); skip > 0 {
+		return true
+	}
+	n := p.uliPrefix(data)
+	if n == 0 {
+		n = p.oliPrefix(data)
+		if n == 0 || !bytes.HasPrefix(bytes.TrimLeft(data, " "), []byte("1.")) {
+			return false
+		}
+	}
+	return p.isEmpty(data[n:]) == 0
+}
+
 func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 	// prev: index of 1st char of previous line
 	// line: index of 1st char of current line
@@ -1196,12 +2210,15 @@ func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 			if level := p.isUnderlinedHeader(current); level > 0 {
 				// render the paragraph
 				p.renderParagraph(out, data[:prev])
+				start := prev
 
 				// ignore leading and trailing whitespace
 				eol := i - 1
 				for prev < eol && data[prev] == ' ' {
 					prev++
 				}
+				size, id := p.headerID(data[prev:eol])
+				eol = prev + size
 				for eol > prev && data[eol-1] == ' ' {
 					eol--
 				}
@@ -1214,18 +2231,19 @@ func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 						return true
 					}
 				}(out, p, data[prev:eol])
-				p.r.Header(out, work, level)
 
 				// find the end of the underline
 				for data[i] != '\n' {
 					i++
 				}
+				p.markSource(data, start, i)
+				p.r.Header(out, work, level, id)
 				return i
 			}
 		}
 
 		// if the next line starts a block of HTML, then the paragraph ends here
-		if p.flags&EXTENSION_LAX_HTML_BLOCKS != 0 {
+		if p.flags&(EXTENSION_LAX_HTML_BLOCKS|EXTENSION_COMMONMARK) != 0 {
 			if data[i] == '<' && p.html(out, current, false) > 0 {
 				// rewind to before the HTML block
 				p.renderParagraph(out, data[:i])
@@ -1239,6 +2257,20 @@ func (p *parser) paragraph(out *bytes.Buffer, data []byte) int {
 			return i
 		}
 
+		// so is it if a collapsible section or a directive starts
+		if i > 0 {
+			if skip, _ := p.isContainerStart(current); skip > 0 {
+				p.renderParagraph(out, data[:i])
+				return i
+			}
+		}
+
+		// in CommonMark, so is it if a list, a quote, or fenced code starts
+		if i > 0 && p.flags&EXTENSION_COMMONMARK != 0 && p.interruptsParagraph(current) {
+			p.renderParagraph(out, data[:i])
+			return i
+		}
+
 		// if there's a list after this, paragraph is over
 		if p.flags&EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK != 0 {
 			if p.uliPrefix(current) != 0 ||
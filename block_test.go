@@ -14,6 +14,7 @@
 package blackfriday
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -210,7 +211,7 @@ func TestUnderlineHeaders(t *testing.T) {
 		"<h1>Header with <em>inline</em></h1>\n",
 
 		"*   List\n    * Sublist\n    Not a header\n    ------\n",
-		"<ul>\n<li>List\n\n<ul>\n<li>Sublist\nNot a header\n------</li>\n</ul></li>\n</ul>\n",
+		"<ul>\n<li>List\n\n<ul>\n<li>Sublist\nNot a header</li>\n</ul>\n\n<hr /></li>\n</ul>\n",
 
 		"Paragraph\n\n\n\n\nHeader\n===\n",
 		"<p>Paragraph</p>\n\n<h1>Header</h1>\n",
@@ -227,6 +228,36 @@ func TestUnderlineHeaders(t *testing.T) {
 	doTestsBlock(t, tests, 0)
 }
 
+func TestHeaderIDs(t *testing.T) {
+	var tests = []string{
+		"# Header {#intro}\n",
+		"<h1 id=\"intro\">Header</h1>\n",
+
+		"## Header ## {#sec-1.2_a:b}  \n",
+		"<h2 id=\"sec-1.2_a:b\">Header</h2>\n",
+
+		"Header {#under}\n======\n",
+		"<h1 id=\"under\">Header</h1>\n",
+
+		// not ids
+		"# Header {#}\n",
+		"<h1>Header {#}</h1>\n",
+
+		"# Header {#two words}\n",
+		"<h1>Header {#two words}</h1>\n",
+
+		"# Header \\{#intro}\n",
+		"<h1>Header {#intro}</h1>\n",
+
+		"# Header {#intro} more\n",
+		"<h1>Header {#intro} more</h1>\n",
+
+		"Paragraph {#intro}\n",
+		"<p>Paragraph {#intro}</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_HEADER_IDS)
+}
+
 func TestHorizontalRule(t *testing.T) {
 	var tests = []string{
 		"-\n",
@@ -292,6 +323,93 @@ func TestHorizontalRule(t *testing.T) {
 	doTestsBlock(t, tests, 0)
 }
 
+func TestHorizontalRuleOrUnderline(t *testing.T) {
+	var tests = []string{
+		// no preceding paragraph text: horizontal rule
+		"---\nFoo\n",
+		"<hr />\n\n<p>Foo</p>\n",
+
+		"Foo\n\n---\n",
+		"<p>Foo</p>\n\n<hr />\n",
+
+		"# Foo\n---\n",
+		"<h1>Foo</h1>\n\n<hr />\n",
+
+		"    Foo\n---\n",
+		"<pre><code>Foo\n</code></pre>\n\n<hr />\n",
+
+		"---\n---\n",
+		"<hr />\n\n<hr />\n",
+
+		// directly following paragraph text: underline
+		"Foo\n---\n",
+		"<h2>Foo</h2>\n",
+
+		"Foo\n   ---\n",
+		"<h2>Foo</h2>\n",
+
+		"Foo\nBar\n---\n",
+		"<p>Foo</p>\n\n<h2>Bar</h2>\n",
+
+		"---\nFoo\n---\n",
+		"<hr />\n\n<h2>Foo</h2>\n",
+
+		// spaced dashes are never an underline
+		"Foo\n- - -\n",
+		"<p>Foo</p>\n\n<hr />\n",
+
+		// no lazy continuation of blockquotes and lists
+		"> Foo\n---\n",
+		"<blockquote>\n<p>Foo</p>\n</blockquote>\n\n<hr />\n",
+
+		"> Foo\n> ---\n",
+		"<blockquote>\n<h2>Foo</h2>\n</blockquote>\n",
+
+		"- Foo\n---\n",
+		"<ul>\n<li>Foo</li>\n</ul>\n\n<hr />\n",
+
+		"* Foo\n* * *\n",
+		"<ul>\n<li>Foo</li>\n</ul>\n\n<hr />\n",
+	}
+	doTestsBlock(t, tests, 0)
+}
+
+func TestBlockQuote(t *testing.T) {
+	var tests = []string{
+		"> Foo\n",
+		"<blockquote>\n<p>Foo</p>\n</blockquote>\n",
+
+		"> Foo\n\nBar\n",
+		"<blockquote>\n<p>Foo</p>\n</blockquote>\n\n<p>Bar</p>\n",
+
+		"Foo\n\n> Bar\n\nBaz\n",
+		"<p>Foo</p>\n\n<blockquote>\n<p>Bar</p>\n</blockquote>\n\n<p>Baz</p>\n",
+
+		"> Foo\n\n> Bar\n",
+		"<blockquote>\n<p>Foo</p>\n\n<p>Bar</p>\n</blockquote>\n",
+
+		"> Foo\n\n* * *\n\n> Bar\n",
+		"<blockquote>\n<p>Foo</p>\n</blockquote>\n\n<hr />\n\n<blockquote>\n<p>Bar</p>\n</blockquote>\n",
+
+		"> > Foo\n>\n> Bar\n",
+		"<blockquote>\n<blockquote>\n<p>Foo</p>\n</blockquote>\n\n<p>Bar</p>\n</blockquote>\n",
+
+		">\n",
+		"<blockquote>\n</blockquote>\n",
+	}
+	doTestsBlock(t, tests, 0)
+
+	// content is on lines of its own, however it ends
+	r := HtmlRenderer(0, "", "")
+	var out bytes.Buffer
+	r.BlockQuote(&out, []byte("Foo"))
+	r.BlockQuote(&out, []byte("Bar\n"))
+	expected := "<blockquote>\nFoo\n</blockquote>\n\n<blockquote>\nBar\n</blockquote>\n"
+	if actual := out.String(); actual != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+}
+
 func TestUnorderedList(t *testing.T) {
 	var tests = []string{
 		"* Hello\n",
@@ -495,10 +613,174 @@ func TestOrderedList(t *testing.T) {
 
 		"1. numbers\n1. are ignored\n",
 		"<ol>\n<li>numbers</li>\n<li>are ignored</li>\n</ol>\n",
+
+		"a. Not a list without the extension\n",
+		"<p>a. Not a list without the extension</p>\n",
 	}
 	doTestsBlock(t, tests, 0)
 }
 
+func TestLooseList(t *testing.T) {
+	var tests = []string{
+		// tight
+		"* one\n* two\n* three\n",
+		"<ul>\n<li>one</li>\n<li>two</li>\n<li>three</li>\n</ul>\n",
+
+		"1. one\n2. two\n\nafter\n",
+		"<ol>\n<li>one</li>\n<li>two</li>\n</ol>\n\n<p>after</p>\n",
+
+		// loose, with multiple paragraphs
+		"* one\n\n    more of one\n\n* two\n",
+		"<ul>\n<li><p>one</p>\n\n<p>more of one</p></li>\n\n<li><p>two</p></li>\n</ul>\n",
+
+		"1. one\n\n2. two\n\n    more of two\n",
+		"<ol>\n<li><p>one</p></li>\n\n<li><p>two</p>\n\n<p>more of two</p></li>\n</ol>\n",
+
+		// a single blank line makes every item loose
+		"* one\n* two\n\n* three\n",
+		"<ul>\n<li><p>one</p></li>\n\n<li><p>two</p></li>\n\n<li><p>three</p></li>\n</ul>\n",
+
+		"* one\n* two\n\n    more of two\n",
+		"<ul>\n<li><p>one</p></li>\n\n<li><p>two</p>\n\n<p>more of two</p></li>\n</ul>\n",
+
+		// nested lists are tight or loose on their own
+		"* a\n  * b\n\n  * c\n* d\n",
+		"<ul>\n<li>a\n\n<ul>\n<li><p>b</p></li>\n\n<li><p>c</p></li>\n</ul></li>\n<li>d</li>\n</ul>\n",
+
+		"1. a\n   * b\n\n   * c\n2. d\n",
+		"<ol>\n<li>a\n\n<ul>\n<li><p>b</p></li>\n\n<li><p>c</p></li>\n</ul></li>\n<li>d</li>\n</ol>\n",
+
+		"* a\n  * b\n  * c\n\n* d\n",
+		"<ul>\n<li><p>a</p>\n\n<ul>\n<li>b</li>\n<li>c</li>\n</ul></li>\n\n<li><p>d</p></li>\n</ul>\n",
+
+		"* a\n\n  * b\n  * c\n",
+		"<ul>\n<li><p>a</p>\n\n<ul>\n<li>b</li>\n<li>c</li>\n</ul></li>\n</ul>\n",
+
+		"* a\n  * b\n  * c\n* d\n",
+		"<ul>\n<li>a\n\n<ul>\n<li>b</li>\n<li>c</li>\n</ul></li>\n<li>d</li>\n</ul>\n",
+	}
+	doTestsBlock(t, tests, 0)
+}
+
+func TestAlert(t *testing.T) {
+	var tests = []string{
+		"> [!NOTE]\n> Useful *information*.\n>\n> More.\n",
+		"<div class=\"markdown-alert markdown-alert-note\">\n<p class=\"markdown-alert-title\">Note</p>\n" +
+			"<p>Useful <em>information</em>.</p>\n\n<p>More.</p>\n</div>\n",
+
+		"> [!tip]\n> * one\n",
+		"<div class=\"markdown-alert markdown-alert-tip\">\n<p class=\"markdown-alert-title\">Tip</p>\n" +
+			"<ul>\n<li>one</li>\n</ul>\n</div>\n",
+
+		"> [!IMPORTANT]\n> a\n\n---\n\n> [!WARNING]\n> b\n\n---\n\n> [!CAUTION]\n",
+		"<div class=\"markdown-alert markdown-alert-important\">\n<p class=\"markdown-alert-title\">Important</p>\n" +
+			"<p>a</p>\n</div>\n\n<hr />\n\n" +
+			"<div class=\"markdown-alert markdown-alert-warning\">\n<p class=\"markdown-alert-title\">Warning</p>\n" +
+			"<p>b</p>\n</div>\n\n<hr />\n\n" +
+			"<div class=\"markdown-alert markdown-alert-caution\">\n<p class=\"markdown-alert-title\">Caution</p>\n</div>\n",
+
+		// unknown types and markers not alone on their line stay blockquotes
+		"> [!DANGER]\n> a\n",
+		"<blockquote>\n<p>[!DANGER]\na</p>\n</blockquote>\n",
+
+		"> [!NOTE] a\n",
+		"<blockquote>\n<p>[!NOTE] a</p>\n</blockquote>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_ALERTS)
+
+	tests = []string{
+		"> [!NOTE]\n> a\n",
+		"<blockquote>\n<p>[!NOTE]\na</p>\n</blockquote>\n",
+	}
+	doTestsBlock(t, tests, 0)
+}
+
+func TestListTabIndent(t *testing.T) {
+	// a tab advances to the next multiple of four columns, wherever it is
+	var tests = []string{
+		"* a\n\t* b\n\t\t* c\n",
+		"<ul>\n<li>a\n\n<ul>\n<li>b\n\n<ul>\n<li>c</li>\n</ul></li>\n</ul></li>\n</ul>\n",
+
+		"* a\n  \t* b\n\t* c\n",
+		"<ul>\n<li>a\n\n<ul>\n<li>b</li>\n<li>c</li>\n</ul></li>\n</ul>\n",
+
+		"1.\ta\n\n\tb\n\t-\tc\n",
+		"<ol>\n<li><p>a</p>\n\n<p>b</p>\n\n<ul>\n<li>c</li>\n</ul></li>\n</ol>\n",
+
+		"> * a\n>\t* b\n",
+		"<blockquote>\n<ul>\n<li>a\n\n<ul>\n<li>b</li>\n</ul></li>\n</ul>\n</blockquote>\n",
+	}
+	doTestsBlock(t, tests, 0)
+}
+
+func TestTaskList(t *testing.T) {
+	var tests = []string{
+		"* [x] done\n* [ ] todo\n* plain\n",
+		"<ul>\n<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"disabled\" checked=\"checked\" /> done</li>\n" +
+			"<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"disabled\" /> todo</li>\n<li>plain</li>\n</ul>\n",
+
+		"1. [X] one\n\n2. [ ] two\n",
+		"<ol>\n<li class=\"task-list-item\"><p><input type=\"checkbox\" disabled=\"disabled\" checked=\"checked\" /> one</p></li>\n\n" +
+			"<li class=\"task-list-item\"><p><input type=\"checkbox\" disabled=\"disabled\" /> two</p></li>\n</ol>\n",
+
+		"* [ ] outer\n    * [x] inner\n",
+		"<ul>\n<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"disabled\" /> outer\n\n" +
+			"<ul>\n<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"disabled\" checked=\"checked\" /> inner</li>\n</ul></li>\n</ul>\n",
+
+		// a checkbox needs a space and something after it
+		"* [ ]\n* [x]x\n* [y] why\n",
+		"<ul>\n<li>[ ]</li>\n<li>[x]x</li>\n<li>[y] why</li>\n</ul>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_TASK_LISTS)
+
+	tests = []string{
+		"* [x] done\n",
+		"<ul>\n<li>[x] done</li>\n</ul>\n",
+	}
+	doTestsBlock(t, tests, 0)
+}
+
+func TestOrderedListLettered(t *testing.T) {
+	var tests = []string{
+		"1. Numbers\n2. Unchanged\n",
+		"<ol>\n<li>Numbers</li>\n<li>Unchanged</li>\n</ol>\n",
+
+		"a. Yin\nb. Yang\n",
+		"<ol type=\"a\">\n<li>Yin</li>\n<li>Yang</li>\n</ol>\n",
+
+		"A. Yin\nB. Yang\n",
+		"<ol type=\"A\">\n<li>Yin</li>\n<li>Yang</li>\n</ol>\n",
+
+		"i. Ting\nii. Bong\niii. Goo\niv. Gah\n",
+		"<ol type=\"i\">\n<li>Ting</li>\n<li>Bong</li>\n<li>Goo</li>\n<li>Gah</li>\n</ol>\n",
+
+		"I. Ting\nII. Bong\n",
+		"<ol type=\"I\">\n<li>Ting</li>\n<li>Bong</li>\n</ol>\n",
+
+		"x. Single letters are alphabetic\n",
+		"<ol type=\"a\">\n<li>Single letters are alphabetic</li>\n</ol>\n",
+
+		"xii. Longer markers are roman\n",
+		"<ol type=\"i\">\n<li>Longer markers are roman</li>\n</ol>\n",
+
+		"iiii. Not a roman numeral\n",
+		"<p>iiii. Not a roman numeral</p>\n",
+
+		"Ii. Mixed case\n",
+		"<p>Ii. Mixed case</p>\n",
+
+		"ab. Two letters\n",
+		"<p>ab. Two letters</p>\n",
+
+		"a.No space\n",
+		"<p>a.No space</p>\n",
+
+		"1. List\n    a. Nested\n    b. Letters\n",
+		"<ol>\n<li>List\n\n<ol type=\"a\">\n<li>Nested</li>\n<li>Letters</li>\n</ol></li>\n</ol>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_LETTERED_LISTS)
+}
+
 func TestPreformattedHtml(t *testing.T) {
 	var tests = []string{
 		"<div></div>\n",
@@ -552,6 +834,194 @@ func TestPreformattedHtml(t *testing.T) {
 	doTestsBlock(t, tests, 0)
 }
 
+func TestMaxNestingDepth(t *testing.T) {
+	var tests = []string{
+		">>>>>> deep *x*\n",
+		"<blockquote>\n<blockquote>\n<blockquote>\n<p>&gt;&gt;&gt; deep *x*</p>\n</blockquote>\n</blockquote>\n</blockquote>\n",
+
+		"* a\n    * b\n        * c\n            * d\n",
+		"<ul>\n<li>a\n\n<ul>\n<li>b\n\n<ul>\n<li>c\n\n<p>* d</p></li>\n</ul></li>\n</ul></li>\n</ul>\n",
+
+		"> **a [b](c)**\n",
+		"<blockquote>\n<p><strong>a [b](c)</strong></p>\n</blockquote>\n",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		r := HtmlRenderer(0, "", "").(*Html)
+		r.SetMaxNestingDepth(3)
+		actual := string(Markdown([]byte(tests[i]), r, 0))
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", tests[i], tests[i+1], actual)
+		}
+	}
+
+	// pathological input ends cleanly, with its innermost text kept
+	input := bytes.Repeat([]byte(">"), 100000)
+	input = append(input, " end\n"...)
+	for _, depth := range []int{0, 1000} {
+		r := HtmlRenderer(0, "", "").(*Html)
+		r.SetMaxNestingDepth(depth)
+		output := Markdown(input, r, 0)
+		if !bytes.Contains(output, []byte("&gt;&gt; end</p>")) {
+			t.Errorf("depth %d: nested text was lost", depth)
+		}
+	}
+}
+
+func TestGridTable(t *testing.T) {
+	var tests = []string{
+		"+-------+-------+\n| Fruit | Price |\n+:======+======:+\n| Ba    | $1    |\n| *nana*|       |\n" +
+			"+-------+-------+\n| Café  | $2    |\n+-------+-------+\n",
+		"<table>\n<thead>\n<tr>\n<th align=\"left\">Fruit</th>\n<th align=\"right\">Price</th>\n</tr>\n</thead>\n\n" +
+			"<tbody>\n<tr>\n<td align=\"left\">Ba <em>nana</em></td>\n<td align=\"right\">$1</td>\n</tr>\n\n" +
+			"<tr>\n<td align=\"left\">Café</td>\n<td align=\"right\">$2</td>\n</tr>\n</tbody>\n</table>\n",
+
+		// without a header, the top border sets the alignment
+		"+---+:---:+\n| a | b   |\n+---+-----+\nafter\n",
+		"<table>\n<tbody>\n<tr>\n<td>a</td>\n<td align=\"center\">b</td>\n</tr>\n" +
+			"</tbody>\n</table>\n\n<p>after</p>\n",
+
+		// the table ends at the last complete row
+		"+---+---+\n| a | b |\n+---+---+\n| c | d |\n",
+		"<table>\n<tbody>\n<tr>\n<td>a</td>\n<td>b</td>\n</tr>\n</tbody>\n</table>\n\n" +
+			"<p>| c | d |</p>\n",
+
+		// bars must line up with the border
+		"+---+---+\n| a  | b |\n+---+---+\n",
+		"<p>+---+---+\n| a  | b |\n+---+---+</p>\n",
+
+		"+---+\n+---+\n",
+		"<p>+---+\n+---+</p>\n",
+
+		"+-------+-----+\n| {-:} a| b   |\n+-------+-----+\n",
+		"<table>\n<tbody>\n<tr>\n<td align=\"right\">a</td>\n<td>b</td>\n</tr>\n" +
+			"</tbody>\n</table>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_GRID_TABLES)
+
+	// pipe tables are unchanged
+	tests = []string{
+		"a | b\n---|---\nc | d\n",
+		"<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n\n" +
+			"<tbody>\n<tr>\n<td>c</td>\n<td>d</td>\n</tr>\n</tbody>\n</table>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_GRID_TABLES|EXTENSION_TABLES)
+}
+
+func TestDetails(t *testing.T) {
+	var tests = []string{
+		"::: details *More* info\nHidden text.\n\n```go\n:::\n```\n:::\n",
+		"<details>\n<summary><em>More</em> info</summary>\n<p>Hidden text.</p>\n\n" +
+			"<pre><code class=\"go\">:::\n</code></pre>\n</details>\n",
+
+		"para\n::: details open\n::: details Inner\nx\n:::\n:::\nafter\n",
+		"<p>para</p>\n\n<details open=\"open\">\n<summary>Details</summary>\n" +
+			"<details>\n<summary>Inner</summary>\n<p>x</p>\n</details>\n</details>\n\n<p>after</p>\n",
+
+		":::: details Outer\n::: details Inner\n:::\n::::\n",
+		"<details>\n<summary>Outer</summary>\n<details>\n<summary>Inner</summary>\n</details>\n</details>\n",
+
+		"::: details Open questions\n:::\n",
+		"<details>\n<summary>Open questions</summary>\n</details>\n",
+
+		// without a closing fence, the lines are a paragraph
+		"::: details\nunclosed\n",
+		"<p>::: details\nunclosed</p>\n",
+
+		"::: note\nx\n:::\n",
+		"<p>::: note\nx\n:::</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_DETAILS|EXTENSION_FENCED_CODE)
+}
+
+func TestDirective(t *testing.T) {
+	var tests = []string{
+		"::: warning {#w .big title=\"Be careful\" data-x=1 onclick=x}\nSome *text*.\n:::\n",
+		"<div class=\"warning big\" id=\"w\" title=\"Be careful\" data-x=\"1\">\n<p>Some <em>text</em>.</p>\n</div>\n",
+
+		// nested directives, sections, and fenced code
+		"para\n::: note\n:::: tip\nx\n::::\n::: details Inner\n```\n:::\n```\n:::\n:::\nafter\n",
+		"<p>para</p>\n\n<div class=\"note\">\n<div class=\"tip\">\n<p>x</p>\n</div>\n\n" +
+			"<details>\n<summary>Inner</summary>\n<pre><code>:::\n</code></pre>\n</details>\n</div>\n\n<p>after</p>\n",
+
+		"::: empty\n:::\n",
+		"<div class=\"empty\">\n</div>\n",
+
+		// not directives
+		"::: note {bad attr}\nx\n:::\n",
+		"<p>::: note {bad attr}\nx\n:::</p>\n",
+
+		"::: note words\nx\n:::\n",
+		"<p>::: note words\nx\n:::</p>\n",
+
+		"::: note\nunclosed\n",
+		"<p>::: note\nunclosed</p>\n",
+
+		":: note\nx\n::\n",
+		"<p>:: note\nx\n::</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_DIRECTIVES|EXTENSION_DETAILS|EXTENSION_FENCED_CODE)
+}
+
+func TestMarkdownInHtml(t *testing.T) {
+	var tests = []string{
+		"<div class=\"note\" markdown=\"1\">\nSome *emphasis*.\n\n* a list\n</div>\n",
+		"<div class=\"note\">\n\n<p>Some <em>emphasis</em>.</p>\n\n<ul>\n<li>a list</li>\n</ul>\n\n</div>\n",
+
+		"<div markdown=1 id=x>*inline*</div>\nafter\n",
+		"<div id=x>\n\n<p><em>inline</em></p>\n\n</div>\n\n<p>after</p>\n",
+
+		// nested tags of the same name are matched up
+		"<div markdown='1'>\n<div>\nraw *text*\n</div>\n\n*parsed*\n</div>\n",
+		"<div>\n\n<div>\nraw *text*\n</div>\n\n<p><em>parsed</em></p>\n\n</div>\n",
+
+		"<section markdown=\"1\">\n<div markdown=\"1\">\n*inner*\n</div>\n</section>\n",
+		"<section>\n\n<div>\n\n<p><em>inner</em></p>\n\n</div>\n\n</section>\n",
+
+		// unmarked blocks stay raw
+		"<div markdown=\"0\">\n*raw*\n</div>\n",
+		"<div markdown=\"0\">\n*raw*\n</div>\n",
+
+		"<div>\n*raw*\n</div>\n",
+		"<div>\n*raw*\n</div>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_MARKDOWN_IN_HTML)
+
+	tests = []string{
+		"<div markdown=\"1\">\n*raw*\n</div>\n",
+		"<div markdown=\"1\">\n*raw*\n</div>\n",
+	}
+	doTestsBlock(t, tests, 0)
+}
+
+func TestBlockHtmlSpacing(t *testing.T) {
+	var tests = []string{
+		"Foo\n\n<div>\nBar\n</div>\n\nBaz\n",
+		"<p>Foo</p>\n\n<div>\nBar\n</div>\n\n<p>Baz</p>\n",
+
+		"Foo\n\n\n\n<div>\nBar\n</div>\n\n\n\nBaz\n",
+		"<p>Foo</p>\n\n<div>\nBar\n</div>\n\n<p>Baz</p>\n",
+
+		"<div>\nFoo\n</div>\n\n<div>\nBar\n</div>\n\n<table>\n<tr><td>Baz</td></tr>\n</table>\n",
+		"<div>\nFoo\n</div>\n\n<div>\nBar\n</div>\n\n<table>\n<tr><td>Baz</td></tr>\n</table>\n",
+
+		"<div>\nFoo\n</div>\n\n<hr>\n\n<div>\nBar\n</div>\n",
+		"<div>\nFoo\n</div>\n\n<hr>\n\n<div>\nBar\n</div>\n",
+	}
+	doTestsBlock(t, tests, 0)
+
+	// blocks are separated by one blank line, however they are passed in
+	r := HtmlRenderer(0, "", "")
+	var out bytes.Buffer
+	r.BlockHtml(&out, []byte("<div>Foo</div>"))
+	r.BlockHtml(&out, []byte("\n\n<div>Bar</div>\n\n"))
+	r.BlockHtml(&out, []byte("\n"))
+	r.BlockHtml(&out, []byte("<div>Baz</div>\n"))
+	expected := "<div>Foo</div>\n\n<div>Bar</div>\n\n<div>Baz</div>\n"
+	if actual := out.String(); actual != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, actual)
+	}
+}
+
 func TestPreformattedHtmlLax(t *testing.T) {
 	var tests = []string{
 		"Paragraph\n<div>\nHere? >&<\n</div>\n",
@@ -581,7 +1051,7 @@ func TestFencedCodeBlock(t *testing.T) {
 		"<pre><code class=\"go\">func foo() bool {\n    return true;\n}\n</code></pre>\n",
 
 		"``` c\n/* special & char < > \" escaping */\n```\n",
-		"<pre><code class=\"c\">/* special &amp; char &lt; &gt; &quot; escaping */\n</code></pre>\n",
+		"<pre><code class=\"c\">/* special &amp; char &lt; &gt; \" escaping */\n</code></pre>\n",
 
 		"``` c\nno *inline* processing ~~of text~~\n```\n",
 		"<pre><code class=\"c\">no *inline* processing ~~of text~~\n</code></pre>\n",
@@ -616,6 +1086,25 @@ func TestFencedCodeBlock(t *testing.T) {
 		"~~~ bash\ntildes\n~~~\n",
 		"<pre><code class=\"bash\">tildes\n</code></pre>\n",
 
+		"````\n```\ninner fence\n```\n````\n",
+		"<pre><code>```\ninner fence\n```\n</code></pre>\n",
+
+		"```` markdown\n``` go\nfunc foo() {}\n```\n\nmore\n````\n\nafter\n",
+		"<pre><code class=\"markdown\">``` go\nfunc foo() {}\n```\n\nmore\n</code></pre>\n\n<p>after</p>\n",
+
+		"`````\n````\n```\n````\n`````\n",
+		"<pre><code>````\n```\n````\n</code></pre>\n",
+
+		// each kind of fence can hold the other
+		"~~~ markdown\n```go\nx := 1\n```\n~~~\n",
+		"<pre><code class=\"markdown\">```go\nx := 1\n```\n</code></pre>\n",
+
+		"```\n~~~\ntildes\n~~~~\n```\n",
+		"<pre><code>~~~\ntildes\n~~~~\n</code></pre>\n",
+
+		"~~~~\n```\n~~~\n````\n~~~~\n",
+		"<pre><code>```\n~~~\n````\n</code></pre>\n",
+
 		"``` lisp\nno ending\n",
 		"<p>``` lisp\nno ending</p>\n",
 
@@ -642,10 +1131,54 @@ func TestFencedCodeBlock(t *testing.T) {
 
 		"    ``` oz\nleading spaces\n    ```\n",
 		"<pre><code>``` oz\n</code></pre>\n\n<p>leading spaces\n    ```</p>\n",
+
+		"``` js title=\"a.js\" {1,3}\nextra info\n```\n",
+		"<pre><code class=\"js\">extra info\n</code></pre>\n",
+
+		"``` {.python .numbered} title=x\nbraces then info\n```\n",
+		"<pre><code class=\"python numbered\">braces then info\n</code></pre>\n",
+
+		"``` {ocaml\nunclosed braces\n```\n",
+		"<p><code>{ocaml\nunclosed braces\n</code></p>\n",
 	}
 	doTestsBlock(t, tests, EXTENSION_FENCED_CODE)
 }
 
+type infoRecorder struct {
+	*Html
+	infos []string
+}
+
+func (r *infoRecorder) BlockCode(out *bytes.Buffer, text []byte, info string) {
+	r.infos = append(r.infos, info)
+	r.Html.BlockCode(out, text, info)
+}
+
+func TestFencedCodeBlockInfo(t *testing.T) {
+	var tests = []string{
+		"``` go\n",
+		"go",
+
+		"```   js title=\"a.js\" {1,3}   \n",
+		"js title=\"a.js\" {1,3}",
+
+		"~~~ {.python .numbered}\n",
+		"{.python .numbered}",
+
+		"```\n",
+		"",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		input := tests[i] + "code\n" + tests[i][:3] + "\n"
+		r := &infoRecorder{Html: HtmlRenderer(0, "", "").(*Html)}
+		Markdown([]byte(input), r, EXTENSION_FENCED_CODE)
+		if len(r.infos) != 1 || r.infos[0] != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				input, tests[i+1], r.infos)
+		}
+	}
+}
+
 func TestTable(t *testing.T) {
 	var tests = []string{
 		"a | b\n---|---\nc | d\n",
@@ -689,6 +1222,18 @@ func TestTable(t *testing.T) {
 
 		"a|b\\|c|d\n---|---|---\nf|g\\|h|i\n",
 		"<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b|c</th>\n<th>d</th>\n</tr>\n</thead>\n\n<tbody>\n<tr>\n<td>f</td>\n<td>g|h</td>\n<td>i</td>\n</tr>\n</tbody>\n</table>\n",
+
+		"`a|b` | c\n---|---\n`d | e` | ``f|`g``\n",
+		"<table>\n<thead>\n<tr>\n<th><code>a|b</code></th>\n<th>c</th>\n</tr>\n</thead>\n\n" +
+			"<tbody>\n<tr>\n<td><code>d | e</code></td>\n<td><code>f|`g</code></td>\n</tr>\n</tbody>\n</table>\n",
+
+		"a | b\n---|---\n`c \\| d` | \\\\| e\n",
+		"<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n\n" +
+			"<tbody>\n<tr>\n<td><code>c | d</code></td>\n<td>\\</td>\n</tr>\n</tbody>\n</table>\n",
+
+		"a | b\n---|---\n`c | d\n",
+		"<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n\n" +
+			"<tbody>\n<tr>\n<td>`c</td>\n<td>d</td>\n</tr>\n</tbody>\n</table>\n",
 	}
 	doTestsBlock(t, tests, EXTENSION_TABLES)
 }
@@ -906,7 +1451,7 @@ func TestFencedCodeBlock_EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK(t *testing.T) {
 		"<pre><code class=\"go\">func foo() bool {\n    return true;\n}\n</code></pre>\n",
 
 		"``` c\n/* special & char < > \" escaping */\n```\n",
-		"<pre><code class=\"c\">/* special &amp; char &lt; &gt; &quot; escaping */\n</code></pre>\n",
+		"<pre><code class=\"c\">/* special &amp; char &lt; &gt; \" escaping */\n</code></pre>\n",
 
 		"``` c\nno *inline* processing ~~of text~~\n```\n",
 		"<pre><code class=\"c\">no *inline* processing ~~of text~~\n</code></pre>\n",
@@ -970,3 +1515,114 @@ func TestFencedCodeBlock_EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK(t *testing.T) {
 	}
 	doTestsBlock(t, tests, EXTENSION_FENCED_CODE|EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK)
 }
+
+func TestFrontMatter(t *testing.T) {
+	var tests = []string{
+		"---\ntitle: x\n---\n# Hi\n",
+		"<h1>Hi</h1>\n",
+
+		"+++\ntitle = \"x\"\n+++\nHi\n",
+		"<p>Hi</p>\n",
+
+		"---  \r\ntitle: x\r\n---\t\r\nHi\r\n",
+		"<p>Hi</p>\n",
+
+		"---\n---\nEmpty\n",
+		"<p>Empty</p>\n",
+
+		"---\nno closing delimiter\n",
+		"<hr />\n\n<p>no closing delimiter</p>\n",
+
+		"---\nmismatched\n+++\n",
+		"<hr />\n\n<p>mismatched\n+++</p>\n",
+
+		"Not at the start\n\n---\ntitle: x\n---\n",
+		"<p>Not at the start</p>\n\n<hr />\n\n<h2>title: x</h2>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_FRONT_MATTER)
+
+	// without the extension, front matter is ordinary markdown
+	tests = []string{
+		"---\ntitle: x\n---\n# Hi\n",
+		"<hr />\n\n<h2>title: x</h2>\n\n<h1>Hi</h1>\n",
+	}
+	doTestsBlock(t, tests, 0)
+
+	var splits = []string{
+		"---\ntitle: x\nlist: [1, 2]\n---\nbody\n",
+		"title: x\nlist: [1, 2]\n",
+		"body\n",
+
+		"+++\ntitle = \"x\"\n+++",
+		"title = \"x\"\n",
+		"",
+
+		"--- \nno closing delimiter\n",
+		"",
+		"--- \nno closing delimiter\n",
+
+		"----\ntoo long\n----\n",
+		"",
+		"----\ntoo long\n----\n",
+	}
+	for i := 0; i+2 < len(splits); i += 3 {
+		matter, body := FrontMatter([]byte(splits[i]))
+		if string(matter) != splits[i+1] || string(body) != splits[i+2] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v] [%#v]\nActual  [%#v] [%#v]",
+				splits[i], splits[i+1], splits[i+2], string(matter), string(body))
+		}
+	}
+}
+
+func TestCommonMarkBlocks(t *testing.T) {
+	var tests = []string{
+		// 4.2 ATX headings need a space after the #s
+		"#5 bolt\n\n#hashtag\n",
+		"<p>#5 bolt</p>\n\n<p>#hashtag</p>\n",
+
+		// 4.5 fenced code blocks can interrupt a paragraph
+		"foo\n```\nbar\n```\nbaz\n",
+		"<p>foo</p>\n\n<pre><code>bar\n</code></pre>\n\n<p>baz</p>\n",
+
+		// and close with a fence of the same character at least as long
+		"````\naaa\n```\n``````\n",
+		"<pre><code>aaa\n```\n</code></pre>\n",
+
+		"~~~~\naaa\n~~~\n~~~~~\n",
+		"<pre><code>aaa\n~~~\n</code></pre>\n",
+
+		"~~~\n```\naaa\n````\n~~~\n",
+		"<pre><code>```\naaa\n````\n</code></pre>\n",
+
+		// only a tilde fence can have backticks in its info string
+		"``` aa ```\nfoo\n",
+		"<p><code>aa</code>\nfoo</p>\n",
+
+		"~~~ aa ``` ~~~\nfoo\n~~~\n",
+		"<pre><code class=\"aa\">foo\n</code></pre>\n",
+
+		// 4.6 HTML blocks can interrupt a paragraph
+		"Foo\n<div>\nbar\n</div>\n",
+		"<p>Foo</p>\n\n<div>\nbar\n</div>\n",
+
+		// 5.1 block quotes can interrupt a paragraph
+		"aaa\n> bbb\n",
+		"<p>aaa</p>\n\n<blockquote>\n<p>bbb</p>\n</blockquote>\n",
+
+		// 5.3 lists can interrupt a paragraph
+		"Foo\n- bar\n- baz\n",
+		"<p>Foo</p>\n\n<ul>\n<li>bar</li>\n<li>baz</li>\n</ul>\n",
+
+		// but an ordered list only if it starts with 1
+		"The number of windows in my house is\n14.  The number of doors is 6.\n",
+		"<p>The number of windows in my house is\n14.  The number of doors is 6.</p>\n",
+
+		"The number of windows in my house is\n1.  The number of doors is 6.\n",
+		"<p>The number of windows in my house is</p>\n\n<ol>\n<li>The number of doors is 6.</li>\n</ol>\n",
+
+		// and not with an empty item
+		"foo\n*\n\nfoo\n1.\n",
+		"<p>foo\n*</p>\n\n<p>foo\n1.</p>\n",
+	}
+	doTestsBlock(t, tests, EXTENSION_COMMONMARK)
+}
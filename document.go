@@ -0,0 +1,208 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Incremental rendering
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Document holds a rendered document for a live editor, so that after an
+// edit only the blocks it touches are rendered again.
+//
+// Each top-level block, such as a paragraph, a list, or a fenced code
+// block, is rendered on its own, so the renderer must not carry state from
+// one block to the next. The Html renderer suits, without
+// HTML_COMPLETE_PAGE, HTML_TOC, or SetSections, and without SetTaskLines or
+// SetSourcePos, since an edit moves the lines of the blocks after it.
+// DocumentHeader and DocumentFooter are not called. Footnotes are not
+// supported, and EXTENSION_FOOTNOTES is ignored.
+//
+// An edit still splits the whole document into blocks, which is much
+// cheaper than rendering it. A block whose source is unchanged keeps its
+// output, unless an edit changed a reference definition, which can change
+// any block.
+type Document struct {
+	input      []byte
+	renderer   Renderer
+	extensions int
+	refs       map[string]*reference
+	blocks     []BlockSpan
+}
+
+// BlockSpan is a top-level block of a Document.
+type BlockSpan struct {
+	// The source of the block is input[Start:End], with the blank lines
+	// and reference definitions after it. The first block starts at 0 and
+	// each block ends where the next one starts, the last at the end of
+	// the input.
+	Start, End int
+
+	// What the renderer wrote for the block, starting with the newline
+	// that separates it from the block before, if any.
+	Output []byte
+}
+
+// NewDocument renders input like Markdown, keeping the output of each
+// block.
+func NewDocument(input []byte, renderer Renderer, extensions int) *Document {
+	doc := &Document{renderer: renderer, extensions: extensions &^ EXTENSION_FOOTNOTES}
+	doc.Edit(0, 0, input)
+	return doc
+}
+
+// Input returns the current source of the document.
+func (doc *Document) Input() []byte {
+	return doc.input
+}
+
+// Blocks returns the blocks of the document in order. Blank input has no
+// blocks.
+func (doc *Document) Blocks() []BlockSpan {
+	return doc.blocks
+}
+
+// Output returns the rendered document.
+func (doc *Document) Output() []byte {
+	var out bytes.Buffer
+	for _, block := range doc.blocks {
+		out.Write(block.Output)
+	}
+	return out.Bytes()
+}
+
+// Edit replaces input[start:end] with text, and renders again the blocks
+// whose source changed. In Blocks, the blocks from first to
+// first+removed have been replaced by those from first to first+added.
+func (doc *Document) Edit(start, end int, text []byte) (first, removed, added int) {
+	input := make([]byte, 0, len(doc.input)-(end-start)+len(text))
+	input = append(input, doc.input[:start]...)
+	input = append(input, text...)
+	input = append(input, doc.input[end:]...)
+	delta := len(text) - (end - start)
+
+	p := newParser(doc.renderer, doc.extensions)
+	p.trackLines = true
+	body := input
+	if doc.extensions&EXTENSION_FRONT_MATTER != 0 {
+		_, body = FrontMatter(input)
+	}
+	data := firstPass(p, body)
+
+	// split the document into blocks, leaving out their text
+	var lines []sourceLine
+	p.skipInline = true
+	p.eachBlock(new(bytes.Buffer), data, 0, func(line sourceLine) bool {
+		lines = append(lines, line)
+		return true
+	})
+	p.skipInline = false
+
+	offset := len(input) - len(body)
+	blocks := make([]BlockSpan, len(lines))
+	for i := range blocks {
+		blocks[i].End = len(input)
+		if i > 0 {
+			blocks[i].Start = offset + lines[i].src
+			blocks[i-1].End = blocks[i].Start
+		}
+	}
+
+	// keep the output of the blocks before and after the edit, unless
+	// what they refer to changed
+	old := doc.blocks
+	removed, added = len(old), len(blocks)
+	if sameReferences(doc.refs, p.refs) {
+		for first < len(old) && first < len(blocks) && old[first].End <= start &&
+			old[first].Start == blocks[first].Start && old[first].End == blocks[first].End {
+			blocks[first].Output = old[first].Output
+			first++
+		}
+		removed, added = len(old)-first, len(blocks)-first
+		for removed > 0 && added > 0 {
+			o, n := old[first+removed-1], &blocks[first+added-1]
+			if o.Start < end || (first+removed == 1) != (first+added == 1) ||
+				o.Start+delta != n.Start || o.End+delta != n.End {
+				break
+			}
+			n.Output = o.Output
+			removed--
+			added--
+		}
+	}
+
+	// render the rest, after the output of the last block before them
+	// that wrote any
+	if added > 0 {
+		var out bytes.Buffer
+		for i := first - 1; i >= 0; i-- {
+			if len(blocks[i].Output) > 0 {
+				out.Write(blocks[i].Output)
+				break
+			}
+		}
+		stop := len(data)
+		if first+added < len(lines) {
+			stop = lines[first+added].pos
+		}
+		var marks []int
+		p.eachBlock(&out, data, lines[first].pos, func(line sourceLine) bool {
+			if line.pos == stop {
+				return false
+			}
+			marks = append(marks, out.Len())
+			return true
+		})
+		marks = append(marks, out.Len())
+		for i := 0; i < added && i+1 < len(marks); i++ {
+			blocks[first+i].Output = out.Bytes()[marks[i]:marks[i+1]]
+		}
+	}
+
+	doc.input, doc.refs, doc.blocks = input, p.refs, blocks
+	return first, removed, added
+}
+
+// Parse data, the first pass output, from pos, calling found with the line
+// where each top-level block starts. Parsing stops if found returns false.
+func (p *parser) eachBlock(out *bytes.Buffer, data []byte, pos int, found func(line sourceLine) bool) {
+	p.topBlock = func(out *bytes.Buffer, rest []byte) bool {
+		at := len(data) - len(rest)
+		i := sort.Search(len(p.sourceLines), func(i int) bool {
+			return p.sourceLines[i].pos >= at
+		})
+		if i == len(p.sourceLines) || p.sourceLines[i].pos != at || p.isEmpty(rest) > 0 {
+			return true
+		}
+		return found(p.sourceLines[i])
+	}
+	p.block(out, data[pos:])
+	p.topBlock = nil
+}
+
+// Check whether two sets of references link to the same places.
+func sameReferences(a, b map[string]*reference) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, ref := range a {
+		other, ok := b[key]
+		if !ok || !bytes.Equal(ref.link, other.link) || !bytes.Equal(ref.title, other.title) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for incremental rendering
+//
+
+package blackfriday
+
+import (
+	"testing"
+)
+
+const documentExtensions = EXTENSION_FENCED_CODE | EXTENSION_TABLES | EXTENSION_FRONT_MATTER
+
+func checkDocument(t *testing.T, doc *Document, extensions int) {
+	expected := string(Markdown(doc.Input(), HtmlRenderer(0, "", ""), extensions))
+	if actual := string(doc.Output()); actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", string(doc.Input()), expected, actual)
+	}
+	end := 0
+	for _, block := range doc.Blocks() {
+		if block.Start != end {
+			t.Errorf("\nInput   [%#v]\nBlocks  [%+v]", string(doc.Input()), doc.Blocks())
+			break
+		}
+		end = block.End
+	}
+}
+
+func TestDocumentEdit(t *testing.T) {
+	input := "---\ntitle: x\n---\n\n# Title\n\nSome *text*\nhere [ref].\n\n" +
+		"* one\n* two\n\n    code\n\n```\nfenced\n```\n\na | b\n---|---\nc | d\n\n" +
+		"[ref]: /url\n\n> quote\n\nlast\n"
+	var tests = []struct {
+		start, end int
+		text       string
+	}{
+		{0, 0, ""},
+		{28, 28, "more "},                    // inside a paragraph
+		{0, 0, "Intro\n\n"},                  // before everything
+		{7, 7, "\n\n"},                       // splitting the front matter
+		{7, 9, ""},                           // joining it again
+		{40, 40, "```\n"},                    // an unclosed fence
+		{40, 44, ""},                         // closing it again
+		{60, 60, "\n\nnew paragraph\n"},      // among the lists
+		{90, 95, ""},                         // deleting across blocks
+		{108, 108, "[ref]: /other\n"},        // a second reference definition
+		{0, 0, "===\n"},                      // a setext underline after nothing
+		{130, 130, "\n    indented"},         // near the end
+		{0, 160, ""},                         // everything
+		{0, 0, "a\n===\n\n\n\n* b\n\n  c\n"}, // from nothing
+	}
+	doc := NewDocument([]byte(input), HtmlRenderer(0, "", ""), documentExtensions)
+	for _, test := range tests {
+		start, end := test.start, test.end
+		if start > len(doc.Input()) {
+			start = len(doc.Input())
+		}
+		if end > len(doc.Input()) {
+			end = len(doc.Input())
+		}
+		doc.Edit(start, end, []byte(test.text))
+		checkDocument(t, doc, documentExtensions)
+	}
+}
+
+func TestDocumentEditEverywhere(t *testing.T) {
+	input := "# Title\n\nSome *text*\nhere [ref].\n\n* one\n\n* two\n\n```\nfenced\n```\n\n" +
+		"a | b\n---|---\nc | d\n\n[ref]: /url\n\n> quote\nlast\n"
+	edits := []string{"\n", "\n\n", "```\n", "* ", "    ", "---\n", "[ref]: /z\n", "<div>\n"}
+	doc := NewDocument([]byte(input), HtmlRenderer(0, "", ""), documentExtensions)
+	for i := 0; i <= len(input); i++ {
+		for _, edit := range edits {
+			doc.Edit(i, i, []byte(edit))
+			checkDocument(t, doc, documentExtensions)
+			doc.Edit(i, i+len(edit), nil)
+			checkDocument(t, doc, documentExtensions)
+		}
+	}
+}
+
+func TestDocumentEditRange(t *testing.T) {
+	doc := NewDocument([]byte("one\n\ntwo\n\nthree\n"), HtmlRenderer(0, "", ""), 0)
+	if first, removed, added := doc.Edit(6, 6, []byte("w")); first != 1 || removed != 1 || added != 1 {
+		t.Errorf("editing a paragraph: got %d, %d, %d", first, removed, added)
+	}
+	if first, removed, added := doc.Edit(8, 8, []byte("\n\nfour")); first != 1 || removed != 1 || added != 2 {
+		t.Errorf("splitting a paragraph: got %d, %d, %d", first, removed, added)
+	}
+	if first, removed, added := doc.Edit(0, 0, []byte("[x]: /y\n")); first != 0 || removed != 4 || added != 4 {
+		t.Errorf("adding a reference: got %d, %d, %d", first, removed, added)
+	}
+	checkDocument(t, doc, 0)
+}
@@ -17,38 +17,152 @@ package blackfriday
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"html"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // Html renderer configuration options.
 const (
-	HTML_SKIP_HTML                = 1 << iota // skip preformatted HTML blocks
-	HTML_SKIP_STYLE                           // skip embedded <style> elements
-	HTML_SKIP_IMAGES                          // skip embedded images
-	HTML_SKIP_LINKS                           // skip all links
-	HTML_SKIP_SCRIPT                          // skip embedded <script> elements
-	HTML_SAFELINK                             // only link to trusted protocols
-	HTML_NOFOLLOW_LINKS                       // only link with rel="nofollow"
-	HTML_TOC                                  // generate a table of contents
-	HTML_OMIT_CONTENTS                        // skip the main contents (for a standalone table of contents)
-	HTML_COMPLETE_PAGE                        // generate a complete HTML page
-	HTML_GITHUB_BLOCKCODE                     // use github fenced code rendering rules
-	HTML_USE_XHTML                            // generate XHTML output instead of HTML
-	HTML_USE_SMARTYPANTS                      // enable smart punctuation substitutions
-	HTML_SMARTYPANTS_FRACTIONS                // enable smart fractions (with HTML_USE_SMARTYPANTS)
-	HTML_SMARTYPANTS_LATEX_DASHES             // enable LaTeX-style dashes (with HTML_USE_SMARTYPANTS)
+	HTML_SKIP_HTML                   = 1 << iota // skip preformatted HTML blocks
+	HTML_SKIP_STYLE                              // skip embedded <style> elements
+	HTML_SKIP_IMAGES                             // skip embedded images
+	HTML_SKIP_LINKS                              // skip all links
+	HTML_SKIP_SCRIPT                             // skip embedded <script> elements
+	HTML_SAFELINK                                // only link to trusted protocols
+	HTML_NOFOLLOW_LINKS                          // only link with rel="nofollow"
+	HTML_TOC                                     // generate a table of contents
+	HTML_OMIT_CONTENTS                           // skip the main contents (for a standalone table of contents)
+	HTML_COMPLETE_PAGE                           // generate a complete HTML page
+	HTML_GITHUB_BLOCKCODE                        // use github fenced code rendering rules
+	HTML_USE_XHTML                               // generate XHTML output instead of HTML
+	HTML_USE_SMARTYPANTS                         // enable all smart punctuation substitutions
+	HTML_SMARTYPANTS_FRACTIONS                   // enable smart fractions, making a fraction of any number/number
+	HTML_SMARTYPANTS_LATEX_DASHES                // enable LaTeX-style dashes (with smart dashes)
+	HTML_SMARTYPANTS_STRICT_ELLIPSIS             // only make an ellipsis of exactly three unspaced periods (with smart ellipses)
+	HTML_SMARTYPANTS_ELLIPSIS_NNBSP              // put a narrow no-break space between a word and an ellipsis (with smart ellipses)
+	HTML_SMARTYPANTS_TIMES                       // turn 4x4 into 4×4
+	HTML_SMARTYPANTS_ARROWS                      // turn standalone -> and <- into arrows
+	HTML_SMARTYPANTS_QUOTES                      // enable smart quotes and apostrophes
+	HTML_SMARTYPANTS_DASHES                      // enable smart dashes
+	HTML_SMARTYPANTS_ELLIPSIS                    // enable smart ellipses
+	HTML_CODE_LINE_NUMBERS                       // number the lines of code blocks
+	HTML_SKIP_DANGEROUS_LINKS                    // don't link to javascript:, vbscript:, or non-image data: URLs
+	HTML_CODE_SQUEEZE_BLANK_LINES                // collapse runs of blank lines in code blocks to one
+	HTML_CODE_DATA_LANG                          // give code blocks a data-lang attribute with their language
+	HTML_PRESENTATIONAL_TAGS                     // use <i>, <b>, and <s> instead of <em>, <strong>, and <del>
+	HTML_OMIT_GENERATOR                          // leave the generator meta tag out of complete pages
+	HTML_TASK_PROGRESS                           // show how many items of each task list are done
+	HTML_SMARTYPANTS_SKIP_CODE_LIKE              // leave words that look like code, such as --flag or v1.0, alone (with smart punctuation)
+	HTML_TABLE_HEADER_SCOPE                      // give table header cells scope="col" for screen readers
 )
 
 // Html is a type that implements the Renderer interface for HTML output.
 //
 // Do not create this directly, instead use the HtmlRenderer function.
 type Html struct {
-	flags    int    // HTML_* options
-	closeTag string // how to end singleton tags: either " />\n" or ">\n"
-	title    string // document title
-	css      string // optional css file url (used with HTML_COMPLETE_PAGE)
+	flags    int      // HTML_* options
+	closeTag string   // how to end void elements, such as " />\n" or ">\n"
+	title    string   // document title
+	css      []string // optional css file urls (used with HTML_COMPLETE_PAGE)
+
+	codeTabWidth int  // tab stop width in code blocks, or 0 to keep tabs
+	diffLines    bool // mark the kinds of lines in diff code blocks
+	wrapWidth    int  // column to wrap paragraph text at, or 0 not to wrap
+	maxNesting   int  // parser nesting limit, or 0 for the default
+	inlineLimit  int  // parser span scanning budget, or 0 for no limit
+	compact      bool // leave out the blank lines between blocks
+	linkRel      string
+	doctype      int      // DOCTYPE_* value (used with HTML_COMPLETE_PAGE)
+	lang         string   // optional document language (used with HTML_COMPLETE_PAGE)
+	headExtra    string   // raw HTML for the end of the head (used with HTML_COMPLETE_PAGE)
+	hruleHTML    string   // raw HTML for horizontal rules, or "" for <hr>
+	tableClass   string   // class of the div around tables, or "" for none
+	listColumns  int      // CSS columns to split lists into, or 0 not to
+	columnsAll   bool     // split ordered and nested lists into columns too
+	safeSchemes  []string // schemes allowed by HTML_SAFELINK, or nil for the defaults
+	normalize    bool     // lowercase the scheme and host of link URLs
+	obfuscate    bool     // write email autolinks as character references
+	diagramLangs []string // languages of code blocks written as diagram divs
+
+	// what the page is, for its JSON-LD data, or nil for none
+	article *ArticleMetadata
+
+	// optional source of responsive image attributes, the attributes that
+	// hold the link and srcset of an image with a placeholder, or "" for
+	// data-src and data-srcset, and the base of relative image links
+	imageResolver  func(link, alt, title []byte) ImageAttrs
+	lazySrcAttr    string
+	lazySrcsetAttr string
+	imageBaseURL   string
+
+	// optional observer of every link, autolink, and image
+	linkCallback func(link, title, content []byte, kind int)
+
+	// optional writer of what replaces links that HTML_SAFELINK rejects
+	unsafeLinkHandler func(link, content []byte) []byte
+
+	// optional rewrite of the whole output
+	documentFilter func(output []byte) []byte
+
+	// renderers of directives by name, in place of the default div
+	directiveHandlers map[string]func(out *bytes.Buffer, attrs []DirectiveAttr, text []byte)
+
+	// parsers of inline syntax of the caller's own, by trigger byte
+	customInline map[byte]func(out *bytes.Buffer, data []byte, offset int) int
+
+	// URL templates for EXTENSION_REPO_REFERENCES links
+	issueURLTemplate  string
+	commitURLTemplate string
+
+	// ENTITIES_* flags
+	entities int
+
+	// what footnote return links show, or "" for the default, and the
+	// symbols that mark footnotes, or nil for numbers
+	footnoteReturn  string
+	footnoteSymbols []string
+	footnoteIDs     int // FOOTNOTE_IDS_* value
+
+	// references made so far to each footnote, and footnotes listed so far
+	footnoteRefs  map[string]int
+	footnoteItems int
+
+	// whether table header cells get ids, and the ids given so far
+	headerCellIDs  bool
+	tableHeaderIDs map[string]bool
+
+	// done and total task items of each enclosing list
+	taskCounts [][2]int
+
+	// the class of paragraphs that hold only an image, or "" for none, and
+	// the images rendered so far and what the last one wrote, to find them
+	imageParagraphClass string
+	imageCount          int
+	lastImage           []byte
+
+	// the data-sourcepos attribute of the next block, with SetSourcePos,
+	// and the input line it starts on, or 0 if not known, for task items
+	// with SetTaskLines
+	sourcePos     string
+	sourceLine    int
+	sourcePosAttr bool
+	taskLines     bool
+
+	// whether headers start sections, the buffer top-level blocks are
+	// written to, and the levels of the headers whose sections are open
+	sectioned   bool
+	documentOut *bytes.Buffer
+	sections    []int
+
+	// HTML around the table of contents, if set with SetTocWrapper, and
+	// whether its entries are plain text
+	tocOpen, tocClose string
+	tocWrapperSet     bool
+	tocPlainText      bool
 
 	// table of contents data
 	tocMarker    int
@@ -59,6 +173,69 @@ type Html struct {
 	smartypants *smartypantsRenderer
 }
 
+// ImageAttrs holds attributes for an image, as returned by the resolver set
+// with SetImageResolver. Zero values are left out.
+type ImageAttrs struct {
+	Src    string // replaces the image link
+	Srcset string // candidate images, as in "a.png 1x, a@2x.png 2x"
+	Sizes  string // image widths for layouts, as in "(max-width: 600px) 100vw, 50vw"
+	Width  int    // width in pixels
+	Height int    // height in pixels
+
+	// Loading is the loading attribute, such as "lazy".
+	Loading string
+
+	// Placeholder is a src to show until a script loads the image, such
+	// as a tiny data: URL. With one, the image link and srcset move to the
+	// attributes set with SetLazyImageAttrs.
+	Placeholder string
+
+	// Sources are alternatives for the browser to choose from, as source
+	// elements of a picture element around the image. With fewer than
+	// two, the image is a plain img.
+	Sources []ImageSource
+}
+
+// ImageSource is a source element of a picture, as returned in ImageAttrs.
+// Zero values are left out.
+type ImageSource struct {
+	Media  string // media query, as in "(min-width: 800px)"
+	Srcset string // candidate images, as in "wide.png" or "a.webp 1x, a@2x.webp 2x"
+	Sizes  string // image widths for layouts
+	Type   string // MIME type, as in "image/webp"
+}
+
+// ArticleMetadata describes the page written with HTML_COMPLETE_PAGE, in
+// its schema.org Article data, along with the title given to HtmlRenderer.
+// Empty fields are left out.
+type ArticleMetadata struct {
+	Author        string // name of the author
+	DatePublished string // ISO 8601 date, as in "2024-05-01"
+	DateModified  string // ISO 8601 date
+	Description   string // summary of the article
+	Image         string // URL of an image for the article
+}
+
+// Doctypes for HTML_COMPLETE_PAGE output
+const (
+	DOCTYPE_DEFAULT = iota // HTML5, or XHTML 1.0 Transitional with HTML_USE_XHTML
+	DOCTYPE_HTML5          // <!DOCTYPE html>
+	DOCTYPE_HTML4          // HTML 4.01 Transitional
+	DOCTYPE_XHTML          // XHTML 1.0 Transitional
+)
+
+// How entities are written, for SetEntities
+const (
+	ENTITIES_ESCAPE_UNKNOWN = 1 << iota // escape the & of entities HTML does not define, such as &bogus;
+	ENTITIES_NUMERIC                    // write entities as numeric character references, such as &#169; for &copy;
+)
+
+// Footnote id schemes, for SetFootnoteIDs
+const (
+	FOOTNOTE_IDS_DEFAULT = iota // fn:name and fnref:name, as in PHP Markdown Extra
+	FOOTNOTE_IDS_GITHUB         // user-content-fn-name and user-content-fnref-name, in GitHub's markup
+)
+
 const (
 	xhtmlClose = " />\n"
 	htmlClose  = ">\n"
@@ -71,6 +248,10 @@ const (
 // title is the title of the document, and css is a URL for the document's
 // stylesheet.
 // title and css are only used when HTML_COMPLETE_PAGE is selected.
+//
+// The renderer keeps the table of contents and other state of the document
+// it renders, so each document needs a new renderer, or the same one after
+// calling Reset. Do not use one renderer for two documents at once.
 func HtmlRenderer(flags int, title string, css string) Renderer {
 	// configure the rendering engine
 	closeTag := htmlClose
@@ -78,11 +259,16 @@ func HtmlRenderer(flags int, title string, css string) Renderer {
 		closeTag = xhtmlClose
 	}
 
+	var stylesheets []string
+	if css != "" {
+		stylesheets = []string{css}
+	}
+
 	return &Html{
 		flags:    flags,
 		closeTag: closeTag,
 		title:    title,
-		css:      css,
+		css:      stylesheets,
 
 		headerCount:  0,
 		currentLevel: 0,
@@ -92,6 +278,510 @@ func HtmlRenderer(flags int, title string, css string) Renderer {
 	}
 }
 
+// Reset clears what the renderer kept from the document it rendered last,
+// such as its table of contents, so that it can render another. Settings
+// are kept.
+func (options *Html) Reset() {
+	options.taskCounts = options.taskCounts[:0]
+	options.sections = options.sections[:0]
+	options.footnoteRefs = nil
+	options.footnoteItems = 0
+	options.tableHeaderIDs = nil
+	options.tocMarker = 0
+	options.headerCount = 0
+	options.currentLevel = 0
+	options.toc.Reset()
+}
+
+// SetFootnoteReturnLink links each footnote back to where it is referred
+// to, with a link showing html, such as "&#8617;" (↩). The default, "",
+// leaves the links out, except with FOOTNOTE_IDS_GITHUB, where they show
+// &#8617;.
+func (options *Html) SetFootnoteReturnLink(html string) {
+	options.footnoteReturn = html
+}
+
+// SetFootnoteSymbols marks footnotes with symbols, such as "*", "&dagger;",
+// and "&Dagger;", instead of numbers. They are used in turn, and then
+// doubled, tripled, and so on. The symbols are HTML. With none, footnotes
+// are numbered.
+func (options *Html) SetFootnoteSymbols(symbols []string) {
+	options.footnoteSymbols = symbols
+}
+
+// SetCodeTabWidth makes BlockCode expand tabs to spaces, aligning to tab
+// stops every n columns, before escaping the code. Setting it to 0 (the
+// default) leaves tabs in place.
+//
+// Markdown expands the tabs of its input (see EXTENSION_TAB_SIZE_EIGHT)
+// everywhere but in fenced code blocks outside of lists and block quotes,
+// which it leaves to this setting when it is on.
+func (options *Html) SetCodeTabWidth(n int) {
+	options.codeTabWidth = n
+}
+
+func (options *Html) keepsCodeTabs() bool {
+	return options.codeTabWidth > 0
+}
+
+// SetCodeDiffLines wraps the lines of diff code blocks, those whose
+// language is diff, in spans by kind, with the classes diff-addition,
+// diff-deletion, diff-hunk for @@ lines, and diff-header for the --- and
+// +++ lines, keeping their leading + or -.
+func (options *Html) SetCodeDiffLines(mark bool) {
+	options.diffLines = mark
+}
+
+// SetMaxNestingDepth sets how deeply Markdown nests blocks and spans, such
+// as blockquotes, lists, and emphasis, when rendering with this renderer.
+// Whatever is nested more deeply is rendered as plain text. With n <= 0,
+// the default depth of 16 is used. A low limit bounds the work done on
+// untrusted input.
+func (options *Html) SetMaxNestingDepth(n int) {
+	options.maxNesting = n
+}
+
+func (options *Html) maxNestingDepth() int {
+	return options.maxNesting
+}
+
+// SetInlineBudget limits how much text Markdown may scan for spans, such as
+// emphasis and links, when rendering with this renderer. Each time a span
+// may start, the rest of its paragraph counts against the budget, as that
+// is how far the parser may look for the end of the span. Once the budget
+// is spent, the remaining text of the document is rendered without spans.
+// This bounds the time taken by input crafted to make span parsing
+// quadratic, such as thousands of unclosed * markers. With n <= 0, the
+// default, there is no limit.
+func (options *Html) SetInlineBudget(n int) {
+	options.inlineLimit = n
+}
+
+func (options *Html) inlineBudget() int {
+	return options.inlineLimit
+}
+
+// SetCompact leaves out the newlines written to separate blocks, for
+// embedding the output in a template. The text of code blocks is kept as
+// it is.
+func (options *Html) SetCompact(compact bool) {
+	options.compact = compact
+}
+
+// SetVoidClose sets how hr, br, img, and input elements end, such as ">"
+// for HTML5 or " />" for XHTML, regardless of HTML_USE_XHTML. Setting it to
+// "" restores the default, which follows HTML_USE_XHTML.
+func (options *Html) SetVoidClose(close string) {
+	options.closeTag = htmlClose
+	if close != "" {
+		options.closeTag = close + "\n"
+	} else if options.flags&HTML_USE_XHTML != 0 {
+		options.closeTag = xhtmlClose
+	}
+}
+
+// SetDirectiveHandler sets a function that renders the directives named
+// name, recognized with EXTENSION_DIRECTIVES, in place of the default div.
+// It is given the attributes and the rendered content of each directive.
+// A nil handler restores the default.
+func (options *Html) SetDirectiveHandler(name string, handler func(out *bytes.Buffer, attrs []DirectiveAttr, text []byte)) {
+	if options.directiveHandlers == nil {
+		options.directiveHandlers = make(map[string]func(out *bytes.Buffer, attrs []DirectiveAttr, text []byte))
+	}
+	options.directiveHandlers[name] = handler
+}
+
+// SetInlineParser adds inline syntax of your own, such as {{name}}
+// templating, when rendering with this renderer. Wherever trigger appears in
+// the text of a paragraph, header, table cell, and so on, parser is called
+// with the text and the offset of the trigger in it. It writes HTML to out and
+// returns how many bytes it consumed from the offset, or returns 0 without
+// writing anything to leave the text alone. It comes before any built-in
+// parsing for the same byte, such as emphasis for '*', which gets its
+// chance only when parser returns 0. Code spans and code blocks are not
+// parsed. A nil parser removes the one set for trigger.
+func (options *Html) SetInlineParser(trigger byte, parser func(out *bytes.Buffer, data []byte, offset int) int) {
+	if parser == nil {
+		delete(options.customInline, trigger)
+		return
+	}
+	if options.customInline == nil {
+		options.customInline = make(map[byte]func(out *bytes.Buffer, data []byte, offset int) int)
+	}
+	options.customInline[trigger] = parser
+}
+
+func (options *Html) inlineParsers() map[byte]func(out *bytes.Buffer, data []byte, offset int) int {
+	return options.customInline
+}
+
+// SetIssueURLTemplate sets the URL used to link issue references such as
+// #123, which are recognized with EXTENSION_REPO_REFERENCES. Each %s in the
+// template is replaced by the issue number, as in
+// "https://github.com/russross/blackfriday/issues/%s". When no template is
+// set, issue references are rendered as plain text.
+func (options *Html) SetIssueURLTemplate(template string) {
+	options.issueURLTemplate = template
+}
+
+// SetCommitURLTemplate sets the URL used to link commit hashes, which are
+// recognized with EXTENSION_REPO_REFERENCES. Each %s in the template is
+// replaced by the hash, as in
+// "https://github.com/russross/blackfriday/commit/%s". When no template is
+// set, commit hashes are rendered as plain text.
+func (options *Html) SetCommitURLTemplate(template string) {
+	options.commitURLTemplate = template
+}
+
+// SetEntities selects how entities are written, as ENTITIES_* values
+// ORed together. With none, the default, they are written as they are in
+// the input.
+func (options *Html) SetEntities(flags int) {
+	options.entities = flags
+}
+
+// SetFootnoteIDs selects the ids that footnotes and references to them
+// get, as one of the FOOTNOTE_IDS_* values, so that links to them made
+// elsewhere keep working. With FOOTNOTE_IDS_GITHUB the markup matches
+// GitHub's too: the footnotes are a section headed by a hidden "Footnotes"
+// heading, and always link back to their references.
+func (options *Html) SetFootnoteIDs(scheme int) {
+	options.footnoteIDs = scheme
+}
+
+// SetDoctype selects the doctype written with HTML_COMPLETE_PAGE, as one of
+// the DOCTYPE_* values.
+func (options *Html) SetDoctype(doctype int) {
+	options.doctype = doctype
+}
+
+// SetLang sets the language of the page written with HTML_COMPLETE_PAGE,
+// such as "en" or "pt-BR", as the lang attribute of its html element.
+func (options *Html) SetLang(lang string) {
+	options.lang = lang
+}
+
+// SetStylesheets replaces the css URL given to HtmlRenderer with a list of
+// stylesheet URLs, linked in the given order by the page written with
+// HTML_COMPLETE_PAGE.
+func (options *Html) SetStylesheets(urls []string) {
+	options.css = urls
+}
+
+// SetHeadExtra sets HTML, such as more stylesheets, scripts, or meta tags,
+// to write verbatim at the end of the head of the page written with
+// HTML_COMPLETE_PAGE. It is not checked or escaped.
+func (options *Html) SetHeadExtra(html string) {
+	options.headExtra = html
+}
+
+// SetArticleMetadata describes pages written with HTML_COMPLETE_PAGE as
+// schema.org Articles in JSON-LD, saying what meta does about the page,
+// such as its author and date, which might come from its front matter.
+func (options *Html) SetArticleMetadata(meta ArticleMetadata) {
+	options.article = &meta
+}
+
+// SetTocWrapper sets the HTML written before and after the table of
+// contents made with HTML_TOC, in place of <nav> and </nav>, such as
+// <nav class="toc" aria-label="Table of contents"> and </nav>. Both are
+// written verbatim; empty strings leave the list bare.
+func (options *Html) SetTocWrapper(open, close string) {
+	options.tocOpen, options.tocClose = open, close
+	options.tocWrapperSet = true
+}
+
+// SetTocPlainText strips inline markup, such as <em> and <code>, from the
+// entries of the table of contents made with HTML_TOC, keeping their text.
+func (options *Html) SetTocPlainText(plain bool) {
+	options.tocPlainText = plain
+}
+
+// SetHRuleHTML sets the HTML that HRule writes, such as
+// <hr class="fancy">, in place of a plain hr element. It is written
+// verbatim.
+func (options *Html) SetHRuleHTML(html string) {
+	options.hruleHTML = html
+}
+
+// SetResponsiveTables wraps tables in a div with the class, such as
+// "table-responsive", that scrolls them sideways when they are too wide
+// for the page. The default, "", leaves tables unwrapped.
+func (options *Html) SetResponsiveTables(class string) {
+	options.tableClass = class
+}
+
+// SetSourcePos gives headers, paragraphs, lists, list items, code blocks,
+// block quotes, alerts, tables, and horizontal rules a data-sourcepos
+// attribute with where they are in the input, as in
+// data-sourcepos="3:1-4:5" for a block from line 3, column 1 to line 4,
+// column 5, so that an editor can match the output to its source.
+func (options *Html) SetSourcePos(pos bool) {
+	options.sourcePosAttr = pos
+}
+
+// SetSections wraps each header that is not inside another block, and the
+// blocks after it up to the next header of its level or a higher one, in
+// a <section>, so that sections nest as the headers do.
+func (options *Html) SetSections(sections bool) {
+	options.sectioned = sections
+}
+
+// SetTaskLines gives task list items the line of the input they start on,
+// counting from 1, in a data-line attribute, and leaves their checkboxes
+// enabled, so that a script can check a task off in the source. Items in
+// footnotes get no line.
+func (options *Html) SetTaskLines(lines bool) {
+	options.taskLines = lines
+}
+
+// SetTableHeaderIDs gives table header cells ids made from their text,
+// such as Unit-price, so that a link can point at a column. A number is
+// added to an id given before, as in Name-1, and cells with no letters or
+// digits get none. Call Reset before rendering another document, so that
+// its ids start over.
+func (options *Html) SetTableHeaderIDs(ids bool) {
+	options.headerCellIDs = ids
+}
+
+// SetListColumns splits unordered lists that are not inside another list
+// into n CSS columns, balanced by the browser, for long lists such as a
+// glossary. With all, every list is split, ordered and nested ones too.
+// With n <= 1, lists are left alone, which is the default.
+func (options *Html) SetListColumns(n int, all bool) {
+	options.listColumns, options.columnsAll = n, all
+}
+
+// SetImageResolver sets a function that Image calls with the link, alt
+// text, and title of each image, to add responsive image attributes such as
+// srcset and sizes, or sources for a picture element. Without a resolver,
+// images have only src, alt, and title attributes.
+func (options *Html) SetImageResolver(resolver func(link, alt, title []byte) ImageAttrs) {
+	options.imageResolver = resolver
+}
+
+// SetLazyImageAttrs sets the names of the attributes that hold the link and
+// srcset of an image when the resolver gives it a placeholder, to match
+// the script that loads it. An empty name keeps the default, data-src or
+// data-srcset.
+func (options *Html) SetLazyImageAttrs(src, srcset string) {
+	options.lazySrcAttr = src
+	options.lazySrcsetAttr = srcset
+}
+
+// SetImageBaseURL sets a URL, such as that of a CDN, that relative image
+// links are joined to with a slash: with "https://cdn.example.com", both
+// "/img/a.png" and "img/a.png" become "https://cdn.example.com/img/a.png".
+// Image links with a scheme, such as data URIs, or starting with "//" are
+// left alone, and links other than images are never changed. It applies to
+// the link from the image resolver, if any.
+func (options *Html) SetImageBaseURL(base string) {
+	options.imageBaseURL = base
+}
+
+// SetImageParagraphClass gives paragraphs that hold only an image, with no
+// other text or markup around it, such as a link, the class, such as
+// "image", so that they can be styled as figures. The default, "", leaves
+// them alone.
+func (options *Html) SetImageParagraphClass(class string) {
+	options.imageParagraphClass = class
+}
+
+// SetLinkCallback sets a function called with every link, autolink, and
+// image the renderer is given, before the options that drop or change
+// links apply. It only observes: the output is the same with or without
+// it. kind is LINK_TYPE_NOT_AUTOLINK for links, the autolink kind for
+// autolinks, where content is the link as written, and LINK_TYPE_IMAGE for
+// images, where content is the alt text. The slices are only valid
+// during the call.
+func (options *Html) SetLinkCallback(callback func(link, title, content []byte, kind int)) {
+	options.linkCallback = callback
+}
+
+// SetDocumentFilter sets a function that DocumentFooter calls once with
+// the whole output, after the table of contents is inserted and a complete
+// page is closed, for global changes such as rewriting asset paths. What
+// it returns replaces the output; it may change output in place and return
+// it.
+func (options *Html) SetDocumentFilter(filter func(output []byte) []byte) {
+	options.documentFilter = filter
+}
+
+// SetWrapWidth makes the renderer break the lines of paragraphs and of
+// the text of tight list items at spaces so they fit within width columns
+// where possible. Tags, links, and code spans are never broken, and code
+// blocks and tables are not wrapped. With width <= 0, the default, lines are kept as
+// they are in the input.
+func (options *Html) SetWrapWidth(width int) {
+	options.wrapWidth = width
+}
+
+// SetLinkRel sets the rel attribute of links, such as "noopener" or
+// "noopener noreferrer". It is merged with the nofollow value required by
+// HTML_NOFOLLOW_LINKS, without duplicates.
+func (options *Html) SetLinkRel(rel string) {
+	options.linkRel = rel
+}
+
+// SetNormalizeLinks lowercases the scheme and host of link URLs, which are
+// not case sensitive, so that HTTP://Example.COM/Path links to
+// http://example.com/Path. The user name, path, query, and fragment are
+// kept as they are.
+func (options *Html) SetNormalizeLinks(normalize bool) {
+	options.normalize = normalize
+}
+
+// SetObfuscateEmail writes email autolinks, and autolinks to mailto: URLs,
+// as character references, alternating decimal and hexadecimal ones, to
+// hide the addresses from harvesters that do not decode them. Browsers
+// show them as usual.
+func (options *Html) SetObfuscateEmail(obfuscate bool) {
+	options.obfuscate = obfuscate
+}
+
+// SetSafeSchemes replaces the schemes that HTML_SAFELINK allows links to,
+// such as "tel" or "steam". Relative links starting with "/" are always
+// allowed. With a nil list, the default, the allowed links are those
+// starting with http://, https://, ftp://, mailto://, or /.
+func (options *Html) SetSafeSchemes(schemes []string) {
+	options.safeSchemes = schemes
+}
+
+// SetDiagramLanguages sets the languages, such as "mermaid" or "plantuml",
+// of the code blocks to write as a div with the language as its class, for
+// a client-side library to draw:
+//
+//	<div class="mermaid">graph TD; A-->B;
+//	</div>
+//
+// Only < and & in the source are escaped, which the browser undoes before
+// the library reads it. The default, nil, writes every block as code.
+func (options *Html) SetDiagramLanguages(langs []string) {
+	options.diagramLangs = langs
+}
+
+// SetUnsafeLinkHandler sets a function that writes what replaces a link,
+// autolink, or image that HTML_SAFELINK rejects. It is called with the
+// link and the HTML the link shows: the text of a link, the escaped
+// address of an autolink, or the escaped alt text of an image, and
+// returns HTML, such as the content alone or a warning. Without a handler,
+// the default, the content of a link or autolink is written in <tt> tags,
+// and images are not checked.
+func (options *Html) SetUnsafeLinkHandler(handler func(link, content []byte) []byte) {
+	options.unsafeLinkHandler = handler
+}
+
+// report whether link is allowed under HTML_SAFELINK
+func (options *Html) isSafeLink(link []byte) bool {
+	if options.safeSchemes == nil {
+		return isSafeLink(link)
+	}
+	if len(link) > 1 && link[0] == '/' && isalnum(link[1]) {
+		return true
+	}
+	for _, scheme := range options.safeSchemes {
+		prefix := scheme + ":"
+		if len(link) > len(prefix) && strings.EqualFold(string(link[:len(prefix)]), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lowercase the scheme and host of a URL, which are not case sensitive,
+// leaving the user name, path, query, and fragment as they are. A mailto:
+// URL has the domain of its address lowercased.
+func normalizeURL(link []byte) []byte {
+	colon := bytes.IndexByte(link, ':')
+	if colon <= 0 || !isletter(link[0]) {
+		return link
+	}
+	for _, c := range link[1:colon] {
+		if !isalnum(c) && c != '+' && c != '-' && c != '.' {
+			return link
+		}
+	}
+	out := append([]byte(nil), link...)
+	lowerASCII(out[:colon])
+	rest := out[colon+1:]
+	switch {
+	case bytes.HasPrefix(rest, []byte("//")):
+		host := rest[2:]
+		if end := bytes.IndexAny(host, "/?#"); end >= 0 {
+			host = host[:end]
+		}
+		if at := bytes.LastIndexByte(host, '@'); at >= 0 {
+			host = host[at+1:]
+		}
+		lowerASCII(host)
+	case string(out[:colon]) == "mailto":
+		lowerDomain(rest)
+	}
+	return out
+}
+
+// Join a relative link to base with a slash. Empty links, links with a
+// scheme, and links starting with "//" or "#" are returned unchanged.
+func joinBaseURL(base string, link []byte) []byte {
+	if len(link) == 0 || link[0] == '#' || bytes.HasPrefix(link, []byte("//")) {
+		return link
+	}
+	if colon := bytes.IndexByte(link, ':'); colon >= 0 && bytes.IndexAny(link[:colon], "/?#") < 0 {
+		return link
+	}
+	out := []byte(strings.TrimRight(base, "/"))
+	out = append(out, '/')
+	return append(out, bytes.TrimLeft(link, "/")...)
+}
+
+// Lowercase, in place, the domain of an email address, which may be
+// followed by a query.
+func lowerDomain(addr []byte) {
+	if end := bytes.IndexByte(addr, '?'); end >= 0 {
+		addr = addr[:end]
+	}
+	if at := bytes.LastIndexByte(addr, '@'); at >= 0 {
+		lowerASCII(addr[at+1:])
+	}
+}
+
+func lowerASCII(text []byte) {
+	for i, c := range text {
+		text[i] = tolower(c)
+	}
+}
+
+// the rel attribute value for links, or "" for none
+func (options *Html) linkRelValue() string {
+	var values []string
+	if options.flags&HTML_NOFOLLOW_LINKS != 0 {
+		values = append(values, "nofollow")
+	}
+	for _, value := range strings.Fields(options.linkRel) {
+		duplicate := false
+		for _, v := range values {
+			duplicate = duplicate || v == value
+		}
+		if !duplicate {
+			values = append(values, value)
+		}
+	}
+	return strings.Join(values, " ")
+}
+
+// Write each character of src as a numeric character reference, alternating
+// decimal and hexadecimal, so that it is not plain text in the source of the
+// page.
+func entityEncode(out *bytes.Buffer, src []byte) {
+	for i, r := range []rune(string(src)) {
+		if i%2 == 0 {
+			fmt.Fprintf(out, "&#%d;", r)
+		} else {
+			fmt.Fprintf(out, "&#x%x;", r)
+		}
+	}
+}
+
 func attrEscape(out *bytes.Buffer, src []byte) {
 	org := 0
 	for i, ch := range src {
@@ -138,16 +828,63 @@ func attrEscape(out *bytes.Buffer, src []byte) {
 	}
 }
 
-func (options *Html) Header(out *bytes.Buffer, text func() bool, level int) {
+// Escape text for element content, such as code, where quotes need no
+// escaping.
+func elementEscape(out *bytes.Buffer, src []byte) {
+	org := 0
+	for i, ch := range src {
+		var entity string
+		switch ch {
+		case '&':
+			entity = "&amp;"
+		case '<':
+			entity = "&lt;"
+		case '>':
+			entity = "&gt;"
+		default:
+			continue
+		}
+		if i > org {
+			out.Write(src[org:i])
+		}
+		org = i + 1
+		out.WriteString(entity)
+	}
+	if org < len(src) {
+		out.Write(src[org:])
+	}
+}
+
+func (options *Html) Header(out *bytes.Buffer, text func() bool, level int, id string) {
 	marker := out.Len()
-	doubleSpace(out)
 
-	if options.flags&HTML_TOC != 0 {
+	// a header ends the sections of headers at its level or deeper, and
+	// starts its own
+	sectioned := options.sectioned && out == options.documentOut
+	open := len(options.sections)
+	if sectioned {
+		for open > 0 && options.sections[open-1] >= level {
+			out.WriteString("</section>\n")
+			open--
+		}
+	}
+	options.doubleSpace(out)
+	if sectioned {
+		out.WriteString("<section>\n")
+	}
+
+	if id != "" {
+		out.WriteString(fmt.Sprintf("<h%d id=\"", level))
+		attrEscape(out, []byte(id))
+		out.WriteString("\"")
+	} else if options.flags&HTML_TOC != 0 {
 		// headerCount is incremented in htmlTocHeader
-		out.WriteString(fmt.Sprintf("<h%d id=\"toc_%d\">", level, options.headerCount))
+		out.WriteString(fmt.Sprintf("<h%d id=\"toc_%d\"", level, options.headerCount))
 	} else {
-		out.WriteString(fmt.Sprintf("<h%d>", level))
+		out.WriteString(fmt.Sprintf("<h%d", level))
 	}
+	out.WriteString(options.takeSourcePos())
+	out.WriteByte('>')
 
 	tocMarker := out.Len()
 	if !text() {
@@ -157,10 +894,93 @@ func (options *Html) Header(out *bytes.Buffer, text func() bool, level int) {
 
 	// are we building a table of contents?
 	if options.flags&HTML_TOC != 0 {
-		options.TocHeader(out.Bytes()[tocMarker:], level)
+		options.tocHeader(out.Bytes()[tocMarker:], level, id)
 	}
 
 	out.WriteString(fmt.Sprintf("</h%d>\n", level))
+	if sectioned {
+		options.sections = append(options.sections[:open], level)
+	}
+}
+
+// End the sections still open at the end of the document.
+func (options *Html) closeSections(out *bytes.Buffer) {
+	for range options.sections {
+		out.WriteString("</section>\n")
+	}
+	options.sections = options.sections[:0]
+}
+
+func (options *Html) Alert(out *bytes.Buffer, text []byte, kind int) {
+	options.doubleSpace(out)
+	out.WriteString("<div class=\"markdown-alert markdown-alert-")
+	out.WriteString(strings.ToLower(alertTypes[kind]))
+	out.WriteString("\"")
+	out.WriteString(options.takeSourcePos())
+	out.WriteString(">\n<p class=\"markdown-alert-title\">")
+	out.WriteString(alertTitle(kind))
+	out.WriteString("</p>\n")
+	out.Write(text)
+	if len(text) > 0 && text[len(text)-1] != '\n' {
+		out.WriteByte('\n')
+	}
+	out.WriteString("</div>\n")
+}
+
+func (options *Html) Details(out *bytes.Buffer, summary, text []byte, flags int) {
+	options.doubleSpace(out)
+	out.WriteString("<details")
+	if flags&DETAILS_OPEN != 0 {
+		options.booleanAttr(out, "open")
+	}
+	out.WriteString(">\n<summary>")
+	if len(summary) == 0 {
+		out.WriteString("Details")
+	} else {
+		out.Write(summary)
+	}
+	out.WriteString("</summary>\n")
+	out.Write(text)
+	if len(text) > 0 && text[len(text)-1] != '\n' {
+		out.WriteByte('\n')
+	}
+	out.WriteString("</details>\n")
+}
+
+func (options *Html) Directive(out *bytes.Buffer, name string, attrs []DirectiveAttr, text []byte) {
+	options.doubleSpace(out)
+	if handler := options.directiveHandlers[name]; handler != nil {
+		handler(out, attrs, text)
+		return
+	}
+
+	// a div with the name and any classes as its class, leaving out event
+	// handler attributes
+	out.WriteString("<div class=\"")
+	attrEscape(out, []byte(name))
+	for _, attr := range attrs {
+		if attr.Key == "class" {
+			out.WriteByte(' ')
+			attrEscape(out, []byte(attr.Value))
+		}
+	}
+	out.WriteByte('"')
+	for _, attr := range attrs {
+		if attr.Key == "class" || strings.HasPrefix(strings.ToLower(attr.Key), "on") {
+			continue
+		}
+		out.WriteByte(' ')
+		out.WriteString(attr.Key)
+		out.WriteString("=\"")
+		attrEscape(out, []byte(attr.Value))
+		out.WriteByte('"')
+	}
+	out.WriteString(">\n")
+	out.Write(text)
+	if len(text) > 0 && text[len(text)-1] != '\n' {
+		out.WriteByte('\n')
+	}
+	out.WriteString("</div>\n")
 }
 
 func (options *Html) BlockHtml(out *bytes.Buffer, text []byte) {
@@ -168,12 +988,21 @@ func (options *Html) BlockHtml(out *bytes.Buffer, text []byte) {
 		return
 	}
 
-	doubleSpace(out)
+	marker := out.Len()
+	options.doubleSpace(out)
+
+	// the block is on lines of its own, with no blank lines around it
+	text = bytes.Trim(text, "\n")
+	start := out.Len()
 	if options.flags&HTML_SKIP_SCRIPT != 0 {
 		out.Write(stripTag(string(text), "script", "p"))
 	} else {
 		out.Write(text)
 	}
+	if out.Len() == start {
+		out.Truncate(marker)
+		return
+	}
 	out.WriteByte('\n')
 }
 
@@ -196,33 +1025,113 @@ func stripTag(text, tag, newTag string) []byte {
 }
 
 func (options *Html) HRule(out *bytes.Buffer) {
-	doubleSpace(out)
+	options.doubleSpace(out)
+	pos := options.takeSourcePos()
+	if options.hruleHTML != "" {
+		out.WriteString(options.hruleHTML)
+		if !strings.HasSuffix(options.hruleHTML, "\n") {
+			out.WriteByte('\n')
+		}
+		return
+	}
 	out.WriteString("<hr")
+	out.WriteString(pos)
 	out.WriteString(options.closeTag)
 }
 
-func (options *Html) BlockCode(out *bytes.Buffer, text []byte, lang string) {
+func (options *Html) BlockCode(out *bytes.Buffer, text []byte, info string) {
+	if langs := infoLanguages(info); len(langs) > 0 {
+		for _, lang := range options.diagramLangs {
+			if langs[0] == lang {
+				options.diagram(out, text, lang)
+				return
+			}
+		}
+	}
+
+	if options.codeTabWidth > 0 && bytes.IndexByte(text, '\t') >= 0 {
+		var expanded bytes.Buffer
+		for len(text) > 0 {
+			end := bytes.IndexByte(text, '\n') + 1
+			if end == 0 {
+				end = len(text)
+			}
+			expandTabs(&expanded, text[:end], options.codeTabWidth)
+			text = text[end:]
+		}
+		text = expanded.Bytes()
+	}
+	if options.flags&HTML_CODE_SQUEEZE_BLANK_LINES != 0 {
+		text = squeezeBlankLines(text)
+	}
+
 	if options.flags&HTML_GITHUB_BLOCKCODE != 0 {
-		options.BlockCodeGithub(out, text, lang)
+		options.BlockCodeGithub(out, text, info)
 	} else {
-		options.BlockCodeNormal(out, text, lang)
+		options.BlockCodeNormal(out, text, info)
 	}
 }
 
-func (options *Html) BlockCodeNormal(out *bytes.Buffer, text []byte, lang string) {
-	doubleSpace(out)
+// Write the source of a diagram for a client-side library to draw.
+func (options *Html) diagram(out *bytes.Buffer, text []byte, lang string) {
+	options.doubleSpace(out)
+	out.WriteString("<div class=\"")
+	attrEscape(out, []byte(lang))
+	out.WriteByte('"')
+	out.WriteString(options.takeSourcePos())
+	out.WriteByte('>')
+	org := 0
+	for i, ch := range text {
+		if ch == '<' || ch == '&' {
+			out.Write(text[org:i])
+			if ch == '<' {
+				out.WriteString("&lt;")
+			} else {
+				out.WriteString("&amp;")
+			}
+			org = i + 1
+		}
+	}
+	out.Write(text[org:])
+	out.WriteString("</div>\n")
+}
 
-	// parse out the language names/classes
-	count := 0
-	for _, elt := range strings.Fields(lang) {
-		if elt[0] == '.' {
-			elt = elt[1:]
+// Keep only the first of each run of blank lines.
+func squeezeBlankLines(text []byte) []byte {
+	var squeezed bytes.Buffer
+	blank := false
+	for len(text) > 0 {
+		end := bytes.IndexByte(text, '\n') + 1
+		if end == 0 {
+			end = len(text)
 		}
-		if len(elt) == 0 {
+		line := text[:end]
+		text = text[end:]
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			blank = false
+		} else if blank {
 			continue
+		} else {
+			blank = true
 		}
+		squeezed.Write(line)
+	}
+	return squeezed.Bytes()
+}
+
+func (options *Html) BlockCodeNormal(out *bytes.Buffer, text []byte, info string) {
+	options.doubleSpace(out)
+
+	// parse out the language names/classes
+	langs := infoLanguages(info)
+	out.WriteString("<pre")
+	out.WriteString(options.takeSourcePos())
+	out.WriteByte('>')
+	count := 0
+	for _, elt := range langs {
 		if count == 0 {
-			out.WriteString("<pre><code class=\"")
+			out.WriteString("<code class=\"")
 		} else {
 			out.WriteByte(' ')
 		}
@@ -231,12 +1140,16 @@ func (options *Html) BlockCodeNormal(out *bytes.Buffer, text []byte, lang string
 	}
 
 	if count == 0 {
-		out.WriteString("<pre><code>")
+		out.WriteString("<code>")
 	} else {
+		if options.flags&HTML_CODE_DATA_LANG != 0 {
+			out.WriteString("\" data-lang=\"")
+			attrEscape(out, []byte(langs[0]))
+		}
 		out.WriteString("\">")
 	}
 
-	attrEscape(out, text)
+	options.codeText(out, text, info)
 	out.WriteString("</code></pre>\n")
 }
 
@@ -256,75 +1169,181 @@ func (options *Html) BlockCodeNormal(out *bytes.Buffer, text []byte, lang string
 // Note that we only generate HTML for the first specifier.
 // E.g.
 //              ~~~~ {.python .numbered}        =>      <pre lang="python"><code>
-func (options *Html) BlockCodeGithub(out *bytes.Buffer, text []byte, lang string) {
-	doubleSpace(out)
+func (options *Html) BlockCodeGithub(out *bytes.Buffer, text []byte, info string) {
+	options.doubleSpace(out)
+
+	// parse out the language name
+	out.WriteString("<pre")
+	if langs := infoLanguages(info); len(langs) > 0 {
+		out.WriteString(" lang=\"")
+		attrEscape(out, []byte(langs[0]))
+		out.WriteByte('"')
+	}
+	out.WriteString(options.takeSourcePos())
+	out.WriteString("><code>")
+
+	options.codeText(out, text, info)
+	out.WriteString("</code></pre>\n")
+}
+
+// Write the escaped contents of a code block. With HTML_CODE_LINE_NUMBERS,
+// each line is prefixed with its number, counting from 1 or from the value
+// of a firstline attribute in the info string, as in go {firstline=42}.
+// With SetCodeDiffLines, the lines of a diff block are wrapped in spans by
+// kind, keeping their leading + or -.
+func (options *Html) codeText(out *bytes.Buffer, text []byte, info string) {
+	numbers := options.flags&HTML_CODE_LINE_NUMBERS != 0
+	diff := false
+	if options.diffLines {
+		langs := infoLanguages(info)
+		diff = len(langs) > 0 && langs[0] == "diff"
+	}
+	if !numbers && !diff {
+		elementEscape(out, text)
+		return
+	}
+
+	line := 1
+	if value, ok := infoAttribute(info, "firstline"); ok {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			line = n
+		}
+	}
 
-	// parse out the language name
-	count := 0
-	for _, elt := range strings.Fields(lang) {
-		if elt[0] == '.' {
-			elt = elt[1:]
+	for len(text) > 0 {
+		end := bytes.IndexByte(text, '\n') + 1
+		if end == 0 {
+			end = len(text)
 		}
-		if len(elt) == 0 {
-			continue
+		if numbers {
+			out.WriteString("<span class=\"line-number\">")
+			out.WriteString(strconv.Itoa(line))
+			out.WriteString("</span>")
 		}
-		out.WriteString("<pre lang=\"")
-		attrEscape(out, []byte(elt))
-		out.WriteString("\"><code>")
-		count++
-		break
+		if class := diffLineClass(text[:end]); diff && class != "" {
+			content := bytes.TrimSuffix(text[:end], []byte("\n"))
+			out.WriteString("<span class=\"")
+			out.WriteString(class)
+			out.WriteString("\">")
+			elementEscape(out, content)
+			out.WriteString("</span>")
+			out.Write(text[len(content):end])
+		} else {
+			elementEscape(out, text[:end])
+		}
+		text = text[end:]
+		line++
 	}
+}
 
-	if count == 0 {
-		out.WriteString("<pre><code>")
+// The class of a line of a unified diff, or "" for a context line. The
+// --- and +++ lines naming the files are headers, not deletions or
+// additions.
+func diffLineClass(line []byte) string {
+	switch {
+	case bytes.HasPrefix(line, []byte("@@")):
+		return "diff-hunk"
+	case bytes.HasPrefix(line, []byte("+++ ")), bytes.HasPrefix(line, []byte("--- ")):
+		return "diff-header"
+	case len(line) > 0 && line[0] == '+':
+		return "diff-addition"
+	case len(line) > 0 && line[0] == '-':
+		return "diff-deletion"
 	}
-
-	attrEscape(out, text)
-	out.WriteString("</code></pre>\n")
+	return ""
 }
 
 func (options *Html) BlockQuote(out *bytes.Buffer, text []byte) {
-	doubleSpace(out)
-	out.WriteString("<blockquote>\n")
+	options.doubleSpace(out)
+	out.WriteString("<blockquote")
+	out.WriteString(options.takeSourcePos())
+	out.WriteString(">\n")
 	out.Write(text)
+	if len(text) > 0 && text[len(text)-1] != '\n' {
+		out.WriteByte('\n')
+	}
 	out.WriteString("</blockquote>\n")
 }
 
 func (options *Html) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int) {
-	doubleSpace(out)
-	out.WriteString("<table>\n<thead>\n")
-	out.Write(header)
-	out.WriteString("</thead>\n\n<tbody>\n")
+	options.doubleSpace(out)
+	responsive := options.tableClass != ""
+	if responsive {
+		out.WriteString("<div class=\"")
+		attrEscape(out, []byte(options.tableClass))
+		out.WriteString("\" style=\"overflow-x:auto\">\n")
+	}
+	out.WriteString("<table")
+	out.WriteString(options.takeSourcePos())
+	out.WriteString(">\n")
+	if len(header) > 0 {
+		out.WriteString("<thead>\n")
+		out.Write(header)
+		out.WriteString("</thead>\n\n")
+	}
+	out.WriteString("<tbody>\n")
 	out.Write(body)
 	out.WriteString("</tbody>\n</table>\n")
+	if responsive {
+		out.WriteString("</div>\n")
+	}
 }
 
 func (options *Html) TableRow(out *bytes.Buffer, text []byte) {
-	doubleSpace(out)
+	options.doubleSpace(out)
 	out.WriteString("<tr>\n")
 	out.Write(text)
 	out.WriteString("\n</tr>\n")
 }
 
 func (options *Html) TableHeaderCell(out *bytes.Buffer, text []byte, align int) {
-	doubleSpace(out)
+	options.doubleSpace(out)
+	out.WriteString("<th")
+	if options.flags&HTML_TABLE_HEADER_SCOPE != 0 {
+		out.WriteString(" scope=\"col\"")
+	}
+	if options.headerCellIDs {
+		if id := options.tableHeaderID(text); id != "" {
+			out.WriteString(" id=\"")
+			out.WriteString(id)
+			out.WriteByte('"')
+		}
+	}
 	switch align {
 	case TABLE_ALIGNMENT_LEFT:
-		out.WriteString("<th align=\"left\">")
+		out.WriteString(" align=\"left\"")
 	case TABLE_ALIGNMENT_RIGHT:
-		out.WriteString("<th align=\"right\">")
+		out.WriteString(" align=\"right\"")
 	case TABLE_ALIGNMENT_CENTER:
-		out.WriteString("<th align=\"center\">")
-	default:
-		out.WriteString("<th>")
+		out.WriteString(" align=\"center\"")
 	}
+	out.WriteByte('>')
 
 	out.Write(text)
 	out.WriteString("</th>")
 }
 
+// Make an id for a table header cell from its text, with a number added
+// if a cell before it had the same text, or "" if it has no letters or
+// digits.
+func (options *Html) tableHeaderID(text []byte) string {
+	slug := string(slugify([]byte(html.UnescapeString(string(removeTags(text))))))
+	if slug == "" || slug == "-" {
+		return ""
+	}
+	if options.tableHeaderIDs == nil {
+		options.tableHeaderIDs = make(map[string]bool)
+	}
+	id := slug
+	for n := 1; options.tableHeaderIDs[id]; n++ {
+		id = slug + "-" + strconv.Itoa(n)
+	}
+	options.tableHeaderIDs[id] = true
+	return id
+}
+
 func (options *Html) TableCell(out *bytes.Buffer, text []byte, align int) {
-	doubleSpace(out)
+	options.doubleSpace(out)
 	switch align {
 	case TABLE_ALIGNMENT_LEFT:
 		out.WriteString("<td align=\"left\">")
@@ -341,33 +1360,176 @@ func (options *Html) TableCell(out *bytes.Buffer, text []byte, align int) {
 }
 
 func (options *Html) Footnotes(out *bytes.Buffer, text func() bool) {
-	out.WriteString("<div class=\"footnotes\">\n")
-	options.HRule(out)
-	options.List(out, text, LIST_TYPE_ORDERED)
-	out.WriteString("</div>\n")
+	options.closeSections(out)
+	options.footnoteItems = 0
+	github := options.footnoteIDs == FOOTNOTE_IDS_GITHUB
+	if github {
+		out.WriteString("<section")
+		options.booleanAttr(out, "data-footnotes")
+		out.WriteString(" class=\"footnotes\">")
+		out.WriteString("<h2 id=\"footnote-label\" class=\"sr-only\">Footnotes</h2>\n")
+	} else {
+		out.WriteString("<div class=\"footnotes\">\n")
+		options.HRule(out)
+	}
+	if len(options.footnoteSymbols) > 0 {
+		// the items show their symbols, so the list is not numbered
+		options.doubleSpace(out)
+		out.WriteString("<ul class=\"footnote-symbols\">")
+		text()
+		out.WriteString("</ul>\n")
+	} else {
+		options.List(out, text, LIST_TYPE_ORDERED)
+	}
+	if github {
+		out.WriteString("</section>\n")
+	} else {
+		out.WriteString("</div>\n")
+	}
 }
 
 func (options *Html) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	options.footnoteItems++
 	if flags&LIST_ITEM_CONTAINS_BLOCK != 0 || flags&LIST_ITEM_BEGINNING_OF_LIST != 0 {
-		doubleSpace(out)
+		options.doubleSpace(out)
 	}
-	out.WriteString(`<li id="fn:`)
-	out.Write(slugify(name))
+	slug := slugify(name)
+	out.WriteString(`<li id="`)
+	out.WriteString(options.footnoteID(slug))
 	out.WriteString(`">`)
+	if len(options.footnoteSymbols) > 0 {
+		out.WriteString(`<span class="footnote-mark">`)
+		out.WriteString(options.footnoteMark(options.footnoteItems))
+		out.WriteString("</span> ")
+	}
+
+	refs := options.footnoteRefs[string(slug)]
+	github := options.footnoteIDs == FOOTNOTE_IDS_GITHUB
+	if options.footnoteReturn == "" && !github || refs == 0 {
+		out.Write(text)
+		out.WriteString("</li>\n")
+		return
+	}
+
+	// the links go at the end of the last paragraph, or after the text
+	end := []byte("</p>\n")
+	if !bytes.HasSuffix(text, end) {
+		end = []byte("\n")
+	}
+	rest := []byte(nil)
+	if bytes.HasSuffix(text, end) {
+		text, rest = text[:len(text)-len(end)], end
+	}
 	out.Write(text)
+	returnLink := options.footnoteReturn
+	if returnLink == "" {
+		returnLink = "&#8617;"
+	}
+	for n := 1; n <= refs; n++ {
+		if github {
+			label := strconv.Itoa(options.footnoteItems)
+			if n > 1 {
+				label += "-" + strconv.Itoa(n)
+			}
+			out.WriteString(` <a href="#`)
+			out.WriteString(options.footnoteRefID(slug, n))
+			out.WriteString(`"`)
+			options.booleanAttr(out, "data-footnote-backref")
+			out.WriteString(` aria-label="Back to reference `)
+			out.WriteString(label)
+			out.WriteString(`" class="data-footnote-backref">`)
+		} else {
+			out.WriteString(` <a class="footnote-return" href="#`)
+			out.WriteString(options.footnoteRefID(slug, n))
+			out.WriteString(`">`)
+		}
+		out.WriteString(returnLink)
+		if n > 1 {
+			if github {
+				out.WriteString(`<sup class="footnote-ref">`)
+			} else {
+				out.WriteString("<sup>")
+			}
+			out.WriteString(strconv.Itoa(n))
+			out.WriteString("</sup>")
+		}
+		out.WriteString("</a>")
+	}
+	out.Write(rest)
 	out.WriteString("</li>\n")
 }
 
+// The mark of the footnote numbered id, in the style set with
+// SetFootnoteSymbols.
+func (options *Html) footnoteMark(id int) string {
+	symbols := options.footnoteSymbols
+	if len(symbols) == 0 || id < 1 {
+		// a link whose label starts with ^ gets id 0
+		return strconv.Itoa(id)
+	}
+	return strings.Repeat(symbols[(id-1)%len(symbols)], (id-1)/len(symbols)+1)
+}
+
+// The id of the footnote slug: fn:slug, or user-content-fn-slug with
+// FOOTNOTE_IDS_GITHUB.
+func (options *Html) footnoteID(slug []byte) string {
+	if options.footnoteIDs == FOOTNOTE_IDS_GITHUB {
+		return "user-content-fn-" + string(slug)
+	}
+	return "fn:" + string(slug)
+}
+
+// The id of the nth reference to the footnote slug: fnref:slug for the
+// first, then fnref2:slug and so on, or with FOOTNOTE_IDS_GITHUB
+// user-content-fnref-slug, then user-content-fnref-slug-2 and so on.
+func (options *Html) footnoteRefID(slug []byte, n int) string {
+	if options.footnoteIDs == FOOTNOTE_IDS_GITHUB {
+		if n == 1 {
+			return "user-content-fnref-" + string(slug)
+		}
+		return "user-content-fnref-" + string(slug) + "-" + strconv.Itoa(n)
+	}
+	if n == 1 {
+		return "fnref:" + string(slug)
+	}
+	return "fnref" + strconv.Itoa(n) + ":" + string(slug)
+}
+
 func (options *Html) List(out *bytes.Buffer, text func() bool, flags int) {
 	marker := out.Len()
-	doubleSpace(out)
+	options.doubleSpace(out)
+	start := out.Len()
 
 	if flags&LIST_TYPE_ORDERED != 0 {
-		out.WriteString("<ol>")
+		switch {
+		case flags&LIST_TYPE_LOWER_ALPHA != 0:
+			out.WriteString("<ol type=\"a\"")
+		case flags&LIST_TYPE_UPPER_ALPHA != 0:
+			out.WriteString("<ol type=\"A\"")
+		case flags&LIST_TYPE_LOWER_ROMAN != 0:
+			out.WriteString("<ol type=\"i\"")
+		case flags&LIST_TYPE_UPPER_ROMAN != 0:
+			out.WriteString("<ol type=\"I\"")
+		default:
+			out.WriteString("<ol")
+		}
 	} else {
-		out.WriteString("<ul>")
+		out.WriteString("<ul")
 	}
-	if !text() {
+	if options.listColumns > 1 && (options.columnsAll ||
+		flags&LIST_TYPE_ORDERED == 0 && len(options.taskCounts) == 0) {
+		out.WriteString(" style=\"column-count:")
+		out.WriteString(strconv.Itoa(options.listColumns))
+		out.WriteByte('"')
+	}
+	out.WriteString(options.takeSourcePos())
+	out.WriteByte('>')
+
+	options.taskCounts = append(options.taskCounts, [2]int{})
+	ok := text()
+	counts := options.taskCounts[len(options.taskCounts)-1]
+	options.taskCounts = options.taskCounts[:len(options.taskCounts)-1]
+	if !ok {
 		out.Truncate(marker)
 		return
 	}
@@ -376,31 +1538,193 @@ func (options *Html) List(out *bytes.Buffer, text func() bool, flags int) {
 	} else {
 		out.WriteString("</ul>\n")
 	}
+
+	// the items have been counted, so the badge goes in front of the list
+	if options.flags&HTML_TASK_PROGRESS != 0 && counts[1] > 0 {
+		list := append([]byte(nil), out.Bytes()[start:]...)
+		out.Truncate(start)
+		out.WriteString("<span class=\"task-progress\">")
+		out.WriteString(strconv.Itoa(counts[0]))
+		out.WriteByte('/')
+		out.WriteString(strconv.Itoa(counts[1]))
+		out.WriteString(" done</span>\n")
+		out.Write(list)
+	}
 }
 
-func (options *Html) ListItem(out *bytes.Buffer, text []byte, flags int) {
+func (options *Html) ListItem(out *bytes.Buffer, text []byte, flags, index, level int) {
 	if flags&LIST_ITEM_CONTAINS_BLOCK != 0 || flags&LIST_ITEM_BEGINNING_OF_LIST != 0 {
-		doubleSpace(out)
+		options.doubleSpace(out)
+	}
+	line := options.sourceLine
+	pos := options.takeSourcePos()
+	if flags&LIST_ITEM_TASK == 0 {
+		out.WriteString("<li")
+		out.WriteString(pos)
+		out.WriteByte('>')
+	} else {
+		// with the line, a script can find the task in the input to
+		// check it off
+		toggle := options.taskLines && line > 0
+		out.WriteString("<li class=\"task-list-item\"")
+		if toggle {
+			out.WriteString(" data-line=\"")
+			out.WriteString(strconv.Itoa(line))
+			out.WriteByte('"')
+		}
+		out.WriteString(pos)
+		out.WriteByte('>')
+		if bytes.HasPrefix(text, []byte("<p>")) {
+			out.WriteString("<p>")
+			text = text[len("<p>"):]
+		}
+		options.taskCheckbox(out, flags, toggle)
+		if n := len(options.taskCounts); n > 0 {
+			if flags&LIST_ITEM_TASK_DONE != 0 {
+				options.taskCounts[n-1][0]++
+			}
+			options.taskCounts[n-1][1]++
+		}
+	}
+	if options.wrapWidth > 0 && flags&LIST_ITEM_CONTAINS_BLOCK == 0 {
+		// only the text before a nested list is inline
+		end := bytes.Index(text, []byte("\n\n"))
+		if end < 0 {
+			end = len(text)
+		}
+		options.wrap(out, text[:end], len("<li>"))
+		text = text[end:]
 	}
-	out.WriteString("<li>")
 	out.Write(text)
 	out.WriteString("</li>\n")
 }
 
+func (options *Html) marksSource() bool {
+	return options.sourcePosAttr || options.taskLines
+}
+
+func (options *Html) blockSource(startLine, startCol, endLine, endCol int) {
+	options.sourcePos, options.sourceLine = "", startLine
+	if startLine > 0 && options.sourcePosAttr {
+		options.sourcePos = fmt.Sprintf(" data-sourcepos=\"%d:%d-%d:%d\"", startLine, startCol, endLine, endCol)
+	}
+}
+
+// Return the data-sourcepos attribute of the block being opened, if the
+// parser gave one, so that it is written only once.
+func (options *Html) takeSourcePos() string {
+	pos := options.sourcePos
+	options.sourcePos = ""
+	return pos
+}
+
+// Write a boolean attribute, which XHTML needs in the name="name" form.
+func (options *Html) booleanAttr(out *bytes.Buffer, name string) {
+	out.WriteByte(' ')
+	out.WriteString(name)
+	if options.flags&HTML_USE_XHTML != 0 {
+		out.WriteString("=\"")
+		out.WriteString(name)
+		out.WriteByte('"')
+	}
+}
+
+// Write the checkbox that starts a task list item, disabled unless a
+// script may toggle it.
+func (options *Html) taskCheckbox(out *bytes.Buffer, flags int, toggle bool) {
+	out.WriteString("<input type=\"checkbox\"")
+	if !toggle {
+		options.booleanAttr(out, "disabled")
+	}
+	if flags&LIST_ITEM_TASK_DONE != 0 {
+		options.booleanAttr(out, "checked")
+	}
+	out.WriteString(strings.TrimSuffix(options.closeTag, "\n"))
+	out.WriteByte(' ')
+}
+
 func (options *Html) Paragraph(out *bytes.Buffer, text func() bool) {
 	marker := out.Len()
-	doubleSpace(out)
-
-	out.WriteString("<p>")
+	options.doubleSpace(out)
+
+	open := out.Len()
+	pos := options.takeSourcePos()
+	out.WriteString("<p")
+	out.WriteString(pos)
+	out.WriteByte('>')
+	start := out.Len()
+	images := options.imageCount
 	if !text() {
 		out.Truncate(marker)
 		return
 	}
+	if options.imageParagraphClass != "" && options.imageCount == images+1 &&
+		bytes.Equal(bytes.TrimSpace(out.Bytes()[start:]), bytes.TrimSpace(options.lastImage)) {
+		// the text rendered to what its one image wrote, apart from white
+		// space, so there is no other text and no markup around the
+		// image, such as a link or emphasis
+		content := append([]byte(nil), out.Bytes()[start:]...)
+		out.Truncate(open)
+		out.WriteString("<p class=\"")
+		attrEscape(out, []byte(options.imageParagraphClass))
+		out.WriteByte('"')
+		out.WriteString(pos)
+		out.WriteByte('>')
+		start = out.Len()
+		out.Write(content)
+	}
+	if options.wrapWidth > 0 {
+		content := append([]byte(nil), out.Bytes()[start:]...)
+		out.Truncate(start)
+		options.wrap(out, content, len("<p>"))
+	}
 	out.WriteString("</p>\n")
 }
 
+// Write rendered inline text with its lines rewrapped, keeping the line
+// breaks that follow <br> tags.
+func (options *Html) wrap(out *bytes.Buffer, text []byte, column int) {
+	lineBreak := []byte("<br" + options.closeTag)
+	for {
+		end := bytes.Index(text, lineBreak)
+		if end < 0 {
+			end = len(text)
+		}
+		wrapWords(out, htmlWords(text[:end]), column, options.wrapWidth, "", runeWidth, anyWordStartsLine)
+		if end == len(text) {
+			return
+		}
+		out.Write(lineBreak)
+		text = text[end+len(lineBreak):]
+		column = 0
+	}
+}
+
 func (options *Html) AutoLink(out *bytes.Buffer, link []byte, kind int) {
-	if options.flags&HTML_SAFELINK != 0 && !isSafeLink(link) && kind != LINK_TYPE_EMAIL {
+	if options.linkCallback != nil {
+		options.linkCallback(link, nil, link, kind)
+	}
+	if options.normalize {
+		if kind == LINK_TYPE_EMAIL {
+			link = append([]byte(nil), link...)
+			lowerDomain(link)
+		} else {
+			link = normalizeURL(link)
+		}
+	}
+	if kind == LINK_TYPE_ISSUE || kind == LINK_TYPE_COMMIT {
+		options.repoLink(out, link, kind)
+		return
+	}
+
+	if options.flags&HTML_SAFELINK != 0 && !options.isSafeLink(link) && kind != LINK_TYPE_EMAIL {
+		if options.unsafeLinkHandler != nil {
+			var content bytes.Buffer
+			attrEscape(&content, link)
+			out.Write(options.unsafeLinkHandler(link, content.Bytes()))
+			return
+		}
+
 		// mark it but don't link it if it is not a safe link: no smartypants
 		out.WriteString("<tt>")
 		attrEscape(out, link)
@@ -408,11 +1732,25 @@ func (options *Html) AutoLink(out *bytes.Buffer, link []byte, kind int) {
 		return
 	}
 
+	if options.flags&HTML_SKIP_DANGEROUS_LINKS != 0 && isDangerousLink(link) {
+		attrEscape(out, link)
+		return
+	}
+
+	escape := attrEscape
+	if options.obfuscate && (kind == LINK_TYPE_EMAIL || bytes.HasPrefix(link, []byte("mailto:"))) {
+		escape = entityEncode
+	}
+
 	out.WriteString("<a href=\"")
 	if kind == LINK_TYPE_EMAIL {
-		out.WriteString("mailto:")
+		escape(out, []byte("mailto:"))
+	}
+	escape(out, link)
+	if rel := options.linkRelValue(); rel != "" {
+		out.WriteString("\" rel=\"")
+		attrEscape(out, []byte(rel))
 	}
-	attrEscape(out, link)
 	out.WriteString("\">")
 
 	// Pretty print: if we get an email address as
@@ -420,44 +1758,130 @@ func (options *Html) AutoLink(out *bytes.Buffer, link []byte, kind int) {
 	// want to print the `mailto:` prefix
 	switch {
 	case bytes.HasPrefix(link, []byte("mailto://")):
-		attrEscape(out, link[len("mailto://"):])
+		escape(out, link[len("mailto://"):])
 	case bytes.HasPrefix(link, []byte("mailto:")):
-		attrEscape(out, link[len("mailto:"):])
+		escape(out, link[len("mailto:"):])
 	default:
-		attrEscape(out, link)
+		escape(out, link)
+	}
+
+	out.WriteString("</a>")
+}
+
+// render an issue or commit reference using the configured URL template
+func (options *Html) repoLink(out *bytes.Buffer, ref []byte, kind int) {
+	template, text := options.commitURLTemplate, ref
+	if kind == LINK_TYPE_ISSUE {
+		template, text = options.issueURLTemplate, append([]byte{'#'}, ref...)
+	}
+	if template == "" {
+		attrEscape(out, text)
+		return
 	}
 
+	out.WriteString("<a href=\"")
+	attrEscape(out, []byte(strings.Replace(template, "%s", string(ref), -1)))
+	out.WriteString("\">")
+	attrEscape(out, text)
 	out.WriteString("</a>")
 }
 
 func (options *Html) CodeSpan(out *bytes.Buffer, text []byte) {
 	out.WriteString("<code>")
-	attrEscape(out, text)
+	elementEscape(out, text)
 	out.WriteString("</code>")
 }
 
 func (options *Html) DoubleEmphasis(out *bytes.Buffer, text []byte) {
-	out.WriteString("<strong>")
-	out.Write(text)
-	out.WriteString("</strong>")
+	options.inlineElement(out, "strong", "b", text)
 }
 
 func (options *Html) Emphasis(out *bytes.Buffer, text []byte) {
 	if len(text) == 0 {
 		return
 	}
-	out.WriteString("<em>")
-	out.Write(text)
-	out.WriteString("</em>")
+	options.inlineElement(out, "em", "i", text)
 }
 
 func (options *Html) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+	if options.linkCallback != nil {
+		options.linkCallback(link, title, alt, LINK_TYPE_IMAGE)
+	}
 	if options.flags&HTML_SKIP_IMAGES != 0 {
 		return
 	}
+	var attrs ImageAttrs
+	if options.imageResolver != nil {
+		attrs = options.imageResolver(link, alt, title)
+		if attrs.Src != "" {
+			link = []byte(attrs.Src)
+		}
+	}
+	if options.imageBaseURL != "" {
+		link = joinBaseURL(options.imageBaseURL, link)
+	}
+	if options.flags&HTML_SAFELINK != 0 && options.unsafeLinkHandler != nil && !options.isSafeLink(link) {
+		var content bytes.Buffer
+		attrEscape(&content, alt)
+		out.Write(options.unsafeLinkHandler(link, content.Bytes()))
+		return
+	}
+	if options.flags&HTML_SKIP_DANGEROUS_LINKS != 0 && isDangerousLink(link) {
+		return
+	}
+	mark := out.Len()
+
+	srcAttr, srcsetAttr := "src", "srcset"
+	if attrs.Placeholder != "" {
+		srcAttr, srcsetAttr = "data-src", "data-srcset"
+		if options.lazySrcAttr != "" {
+			srcAttr = options.lazySrcAttr
+		}
+		if options.lazySrcsetAttr != "" {
+			srcsetAttr = options.lazySrcsetAttr
+		}
+	}
+
+	picture := len(attrs.Sources) > 1
+	if picture {
+		out.WriteString("<picture>\n")
+		for _, source := range attrs.Sources {
+			options.imageSource(out, source, srcsetAttr)
+		}
+	}
 
-	out.WriteString("<img src=\"")
+	if attrs.Placeholder != "" {
+		out.WriteString("<img src=\"")
+		attrEscape(out, []byte(attrs.Placeholder))
+		out.WriteString("\" ")
+	} else {
+		out.WriteString("<img ")
+	}
+	out.WriteString(srcAttr)
+	out.WriteString("=\"")
 	attrEscape(out, link)
+	if attrs.Srcset != "" {
+		out.WriteString("\" ")
+		out.WriteString(srcsetAttr)
+		out.WriteString("=\"")
+		attrEscape(out, []byte(attrs.Srcset))
+	}
+	if attrs.Sizes != "" {
+		out.WriteString("\" sizes=\"")
+		attrEscape(out, []byte(attrs.Sizes))
+	}
+	if attrs.Width > 0 {
+		out.WriteString("\" width=\"")
+		out.WriteString(strconv.Itoa(attrs.Width))
+	}
+	if attrs.Height > 0 {
+		out.WriteString("\" height=\"")
+		out.WriteString(strconv.Itoa(attrs.Height))
+	}
+	if attrs.Loading != "" {
+		out.WriteString("\" loading=\"")
+		attrEscape(out, []byte(attrs.Loading))
+	}
 	out.WriteString("\" alt=\"")
 	if len(alt) > 0 {
 		attrEscape(out, alt)
@@ -469,15 +1893,51 @@ func (options *Html) Image(out *bytes.Buffer, link []byte, title []byte, alt []b
 
 	out.WriteByte('"')
 	out.WriteString(options.closeTag)
+	if picture {
+		out.WriteString("</picture>")
+	}
+	if options.imageParagraphClass != "" {
+		options.imageCount++
+		options.lastImage = append(options.lastImage[:0], out.Bytes()[mark:]...)
+	}
 	return
 }
 
+// write a source element of a picture, with its srcset in srcsetAttr
+func (options *Html) imageSource(out *bytes.Buffer, source ImageSource, srcsetAttr string) {
+	out.WriteString("<source")
+	for _, attr := range []struct{ name, value string }{
+		{"media", source.Media},
+		{srcsetAttr, source.Srcset},
+		{"sizes", source.Sizes},
+		{"type", source.Type},
+	} {
+		if attr.value != "" {
+			out.WriteString(" " + attr.name + "=\"")
+			attrEscape(out, []byte(attr.value))
+			out.WriteByte('"')
+		}
+	}
+	out.WriteString(options.closeTag)
+}
+
 func (options *Html) LineBreak(out *bytes.Buffer) {
 	out.WriteString("<br")
 	out.WriteString(options.closeTag)
 }
 
 func (options *Html) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	if options.linkCallback != nil {
+		options.linkCallback(link, title, content, LINK_TYPE_NOT_AUTOLINK)
+	}
+	if options.normalize {
+		link = normalizeURL(link)
+	}
+	if len(content) == 0 {
+		// nothing is left to click on, as when a linked image is skipped
+		return
+	}
+
 	if options.flags&HTML_SKIP_LINKS != 0 {
 		// write the link text out but don't link it, just mark it with typewriter font
 		out.WriteString("<tt>")
@@ -486,7 +1946,12 @@ func (options *Html) Link(out *bytes.Buffer, link []byte, title []byte, content
 		return
 	}
 
-	if options.flags&HTML_SAFELINK != 0 && !isSafeLink(link) {
+	if options.flags&HTML_SAFELINK != 0 && !options.isSafeLink(link) {
+		if options.unsafeLinkHandler != nil {
+			out.Write(options.unsafeLinkHandler(link, content))
+			return
+		}
+
 		// write the link text out but don't link it, just mark it with typewriter font
 		out.WriteString("<tt>")
 		attrEscape(out, content)
@@ -494,14 +1959,21 @@ func (options *Html) Link(out *bytes.Buffer, link []byte, title []byte, content
 		return
 	}
 
+	if options.flags&HTML_SKIP_DANGEROUS_LINKS != 0 && isDangerousLink(link) {
+		// write the link text out as it is, without the link
+		out.Write(content)
+		return
+	}
+
 	out.WriteString("<a href=\"")
 	attrEscape(out, link)
 	if len(title) > 0 {
 		out.WriteString("\" title=\"")
 		attrEscape(out, title)
 	}
-	if options.flags&HTML_NOFOLLOW_LINKS != 0 {
-		out.WriteString("\" rel=\"nofollow")
+	if rel := options.linkRelValue(); rel != "" {
+		out.WriteString("\" rel=\"")
+		attrEscape(out, []byte(rel))
 	}
 	out.WriteString("\">")
 	out.Write(content)
@@ -529,34 +2001,132 @@ func (options *Html) RawHtmlTag(out *bytes.Buffer, text []byte) {
 }
 
 func (options *Html) TripleEmphasis(out *bytes.Buffer, text []byte) {
-	out.WriteString("<strong><em>")
+	strong, em := "strong", "em"
+	if options.flags&HTML_PRESENTATIONAL_TAGS != 0 {
+		strong, em = "b", "i"
+	}
+	out.WriteString("<" + strong + "><" + em + ">")
 	out.Write(text)
-	out.WriteString("</em></strong>")
+	out.WriteString("</" + em + "></" + strong + ">")
 }
 
 func (options *Html) StrikeThrough(out *bytes.Buffer, text []byte) {
-	out.WriteString("<del>")
+	options.inlineElement(out, "del", "s", text)
+}
+
+// CriticMarkup edits are marked up as changes, with a substitution as a
+// deletion followed by an insertion. A comment is a span, not an aside,
+// since it sits within a paragraph.
+func (options *Html) CriticMarkup(out *bytes.Buffer, text, replacement []byte, kind int) {
+	switch kind {
+	case CRITIC_INSERTION:
+		out.WriteString("<ins>")
+		out.Write(text)
+		out.WriteString("</ins>")
+	case CRITIC_DELETION:
+		out.WriteString("<del>")
+		out.Write(text)
+		out.WriteString("</del>")
+	case CRITIC_SUBSTITUTION:
+		out.WriteString("<del>")
+		out.Write(text)
+		out.WriteString("</del><ins>")
+		out.Write(replacement)
+		out.WriteString("</ins>")
+	case CRITIC_HIGHLIGHT:
+		out.WriteString("<mark>")
+		out.Write(text)
+		out.WriteString("</mark>")
+	case CRITIC_COMMENT:
+		out.WriteString("<span class=\"critic-comment\">")
+		out.Write(text)
+		out.WriteString("</span>")
+	}
+}
+
+// Write text in an element named semantic, or presentational with
+// HTML_PRESENTATIONAL_TAGS.
+func (options *Html) inlineElement(out *bytes.Buffer, semantic, presentational string, text []byte) {
+	name := semantic
+	if options.flags&HTML_PRESENTATIONAL_TAGS != 0 {
+		name = presentational
+	}
+	out.WriteString("<" + name + ">")
 	out.Write(text)
-	out.WriteString("</del>")
+	out.WriteString("</" + name + ">")
+}
+
+func (options *Html) Time(out *bytes.Buffer, datetime []byte) {
+	out.WriteString("<time datetime=\"")
+	attrEscape(out, datetime)
+	out.WriteString("\">")
+	attrEscape(out, datetime)
+	out.WriteString("</time>")
 }
 
 func (options *Html) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
 	slug := slugify(ref)
-	out.WriteString(`<sup class="footnote-ref" id="fnref:`)
-	out.Write(slug)
-	out.WriteString(`"><a rel="footnote" href="#fn:`)
-	out.Write(slug)
-	out.WriteString(`">`)
-	out.WriteString(strconv.Itoa(id))
+	if options.footnoteRefs == nil {
+		options.footnoteRefs = make(map[string]int)
+	}
+	options.footnoteRefs[string(slug)]++
+	refID := options.footnoteRefID(slug, options.footnoteRefs[string(slug)])
+	if options.footnoteIDs == FOOTNOTE_IDS_GITHUB {
+		out.WriteString(`<sup><a href="#`)
+		out.WriteString(options.footnoteID(slug))
+		out.WriteString(`" id="`)
+		out.WriteString(refID)
+		out.WriteString(`"`)
+		options.booleanAttr(out, "data-footnote-ref")
+		out.WriteString(` aria-describedby="footnote-label">`)
+	} else {
+		out.WriteString(`<sup class="footnote-ref" id="`)
+		out.WriteString(refID)
+		out.WriteString(`"><a rel="footnote" href="#`)
+		out.WriteString(options.footnoteID(slug))
+		out.WriteString(`">`)
+	}
+	out.WriteString(options.footnoteMark(id))
 	out.WriteString(`</a></sup>`)
 }
 
 func (options *Html) Entity(out *bytes.Buffer, entity []byte) {
-	out.Write(entity)
+	if options.entities == 0 {
+		out.Write(entity)
+		return
+	}
+
+	text, ok := decodeEntity(entity)
+	switch {
+	case !ok && options.entities&ENTITIES_ESCAPE_UNKNOWN != 0:
+		out.WriteString("&amp;")
+		out.Write(entity[1:])
+	case ok && options.entities&ENTITIES_NUMERIC != 0:
+		for _, r := range text {
+			out.WriteString("&#")
+			out.WriteString(strconv.Itoa(int(r)))
+			out.WriteByte(';')
+		}
+	default:
+		out.Write(entity)
+	}
+}
+
+// Decode an entity such as &copy; or &#169;, reporting whether HTML
+// defines it.
+func decodeEntity(entity []byte) (string, bool) {
+	text := html.UnescapeString(string(entity))
+	// a name that only starts with one that needs no semicolon, such as
+	// &ampx; or &copyx;, decodes to more than the one or two characters of
+	// an entity
+	if text == string(entity) || utf8.RuneCountInString(text) > 2 {
+		return "", false
+	}
+	return text, true
 }
 
 func (options *Html) NormalText(out *bytes.Buffer, text []byte) {
-	if options.flags&HTML_USE_SMARTYPANTS != 0 {
+	if options.flags&smartypantsFlags != 0 {
 		options.Smartypants(out, text)
 	} else {
 		attrEscape(out, text)
@@ -571,8 +2141,18 @@ func (options *Html) Smartypants(out *bytes.Buffer, text []byte) {
 	attrEscape(&escaped, text)
 	text = escaped.Bytes()
 
+	skipCode := options.flags&HTML_SMARTYPANTS_SKIP_CODE_LIKE != 0
+	codeStart, codeEnd := -1, -1
 	mark := 0
 	for i := 0; i < len(text); i++ {
+		if skipCode && (i == 0 || isspace(text[i-1]) || bytes.HasSuffix(text[:i], []byte("&quot;"))) {
+			codeStart, codeEnd = codeLikeWord(text, i)
+		}
+		if i == codeStart {
+			// copied along with the text before the next substitution
+			i = codeEnd - 1
+			continue
+		}
 		if action := options.smartypants[text[i]]; action != nil {
 			if i > mark {
 				out.Write(text[mark:i])
@@ -593,46 +2173,126 @@ func (options *Html) Smartypants(out *bytes.Buffer, text []byte) {
 }
 
 func (options *Html) DocumentHeader(out *bytes.Buffer) {
+	options.documentOut = out
 	if options.flags&HTML_COMPLETE_PAGE == 0 {
 		return
 	}
 
 	ending := ""
 	if options.flags&HTML_USE_XHTML != 0 {
+		ending = " /"
+	}
+	doctype := options.doctype
+	if doctype == DOCTYPE_DEFAULT {
+		doctype = DOCTYPE_HTML5
+		if options.flags&HTML_USE_XHTML != 0 {
+			doctype = DOCTYPE_XHTML
+		}
+	}
+	switch doctype {
+	case DOCTYPE_HTML4:
+		out.WriteString("<!DOCTYPE HTML PUBLIC \"-//W3C//DTD HTML 4.01 Transitional//EN\" ")
+		out.WriteString("\"http://www.w3.org/TR/html4/loose.dtd\">\n")
+		out.WriteString("<html")
+	case DOCTYPE_XHTML:
 		out.WriteString("<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Transitional//EN\" ")
 		out.WriteString("\"http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd\">\n")
-		out.WriteString("<html xmlns=\"http://www.w3.org/1999/xhtml\">\n")
-		ending = " /"
-	} else {
+		out.WriteString("<html xmlns=\"http://www.w3.org/1999/xhtml\"")
+	default:
 		out.WriteString("<!DOCTYPE html>\n")
-		out.WriteString("<html>\n")
+		out.WriteString("<html")
+	}
+	if options.lang != "" {
+		out.WriteString(" lang=\"")
+		attrEscape(out, []byte(options.lang))
+		if doctype == DOCTYPE_XHTML {
+			out.WriteString("\" xml:lang=\"")
+			attrEscape(out, []byte(options.lang))
+		}
+		out.WriteString("\"")
 	}
+	out.WriteString(">\n")
 	out.WriteString("<head>\n")
 	out.WriteString("  <title>")
 	options.NormalText(out, []byte(options.title))
 	out.WriteString("</title>\n")
-	out.WriteString("  <meta name=\"GENERATOR\" content=\"Blackfriday Markdown Processor v")
-	out.WriteString(VERSION)
-	out.WriteString("\"")
-	out.WriteString(ending)
-	out.WriteString(">\n")
+	if options.flags&HTML_OMIT_GENERATOR == 0 {
+		out.WriteString("  <meta name=\"GENERATOR\" content=\"Blackfriday Markdown Processor v")
+		out.WriteString(VERSION)
+		out.WriteString("\"")
+		out.WriteString(ending)
+		out.WriteString(">\n")
+	}
 	out.WriteString("  <meta charset=\"utf-8\"")
 	out.WriteString(ending)
 	out.WriteString(">\n")
-	if options.css != "" {
+	for _, css := range options.css {
 		out.WriteString("  <link rel=\"stylesheet\" type=\"text/css\" href=\"")
-		attrEscape(out, []byte(options.css))
+		attrEscape(out, []byte(css))
 		out.WriteString("\"")
 		out.WriteString(ending)
 		out.WriteString(">\n")
 	}
+	if options.article != nil {
+		options.articleJSONLD(out)
+	}
+	writeLine(out, options.headExtra)
 	out.WriteString("</head>\n")
 	out.WriteString("<body>\n")
 
 	options.tocMarker = out.Len()
 }
 
+// write html, if any, as a line of its own
+func writeLine(out *bytes.Buffer, html string) {
+	if html == "" {
+		return
+	}
+	out.WriteString(html)
+	if !strings.HasSuffix(html, "\n") {
+		out.WriteByte('\n')
+	}
+}
+
+// write a script element with schema.org Article data for the page
+func (options *Html) articleJSONLD(out *bytes.Buffer) {
+	type person struct {
+		Type string `json:"@type"`
+		Name string `json:"name"`
+	}
+	data := struct {
+		Context       string  `json:"@context"`
+		Type          string  `json:"@type"`
+		Headline      string  `json:"headline,omitempty"`
+		Author        *person `json:"author,omitempty"`
+		DatePublished string  `json:"datePublished,omitempty"`
+		DateModified  string  `json:"dateModified,omitempty"`
+		Description   string  `json:"description,omitempty"`
+		Image         string  `json:"image,omitempty"`
+	}{
+		Context:       "https://schema.org",
+		Type:          "Article",
+		Headline:      options.title,
+		DatePublished: options.article.DatePublished,
+		DateModified:  options.article.DateModified,
+		Description:   options.article.Description,
+		Image:         options.article.Image,
+	}
+	if options.article.Author != "" {
+		data.Author = &person{"Person", options.article.Author}
+	}
+
+	// marshaling escapes <, >, and &, so nothing can end the script early
+	text, _ := json.Marshal(data)
+	out.WriteString("  <script type=\"application/ld+json\">")
+	out.Write(text)
+	out.WriteString("</script>\n")
+}
+
 func (options *Html) DocumentFooter(out *bytes.Buffer) {
+	options.closeSections(out)
+	options.documentOut = nil
+
 	// finalize and insert the table of contents
 	if options.flags&HTML_TOC != 0 {
 		options.TocFinalize()
@@ -652,9 +2312,13 @@ func (options *Html) DocumentFooter(out *bytes.Buffer) {
 		}
 
 		// insert the table of contents
-		out.WriteString("<nav>\n")
+		open, close := "<nav>", "</nav>"
+		if options.tocWrapperSet {
+			open, close = options.tocOpen, options.tocClose
+		}
+		writeLine(out, open)
 		out.Write(options.toc.Bytes())
-		out.WriteString("</nav>\n")
+		writeLine(out, close)
 
 		// corner case spacing issue
 		if options.flags&HTML_COMPLETE_PAGE == 0 && options.flags&HTML_OMIT_CONTENTS == 0 {
@@ -672,9 +2336,20 @@ func (options *Html) DocumentFooter(out *bytes.Buffer) {
 		out.WriteString("</html>\n")
 	}
 
+	if options.documentFilter != nil {
+		filtered := options.documentFilter(out.Bytes())
+		out.Reset()
+		out.Write(filtered)
+	}
 }
 
 func (options *Html) TocHeader(text []byte, level int) {
+	options.tocHeader(text, level, "")
+}
+
+// Add a header to the table of contents, linking to id, or to its
+// toc_ number if id is "".
+func (options *Html) tocHeader(text []byte, level int, id string) {
 	for level > options.currentLevel {
 		switch {
 		case bytes.HasSuffix(options.toc.Bytes(), []byte("</li>\n")):
@@ -700,16 +2375,46 @@ func (options *Html) TocHeader(text []byte, level int) {
 		options.currentLevel--
 	}
 
-	options.toc.WriteString("<li><a href=\"#toc_")
-	options.toc.WriteString(strconv.Itoa(options.headerCount))
+	if id != "" {
+		options.toc.WriteString("<li><a href=\"#")
+		attrEscape(options.toc, []byte(id))
+	} else {
+		options.toc.WriteString("<li><a href=\"#toc_")
+		options.toc.WriteString(strconv.Itoa(options.headerCount))
+	}
 	options.toc.WriteString("\">")
 	options.headerCount++
 
+	if options.tocPlainText {
+		text = removeTags(text)
+	}
 	options.toc.Write(text)
 
 	options.toc.WriteString("</a></li>\n")
 }
 
+// Remove the tags from rendered HTML, keeping the text between them
+// with its entities. Attribute values are escaped, so a tag ends at the
+// first '>'.
+func removeTags(html []byte) []byte {
+	if bytes.IndexByte(html, '<') < 0 {
+		return html
+	}
+	out := make([]byte, 0, len(html))
+	inTag := false
+	for _, c := range html {
+		switch {
+		case c == '<':
+			inTag = true
+		case c == '>' && inTag:
+			inTag = false
+		case !inTag:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
 func (options *Html) TocFinalize() {
 	for options.currentLevel > 1 {
 		options.toc.WriteString("</ul></li>\n")
@@ -783,6 +2488,14 @@ func skipSpace(tag []byte, i int) int {
 	return i
 }
 
+// Write the newline that separates a block from the one before it, unless
+// SetCompact leaves it out.
+func (options *Html) doubleSpace(out *bytes.Buffer) {
+	if !options.compact {
+		doubleSpace(out)
+	}
+}
+
 func doubleSpace(out *bytes.Buffer) {
 	if out.Len() > 0 {
 		out.WriteByte('\n')
@@ -0,0 +1,1199 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for HTML rendering options
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCodeTabWidth(t *testing.T) {
+	var tests = []struct {
+		width    int
+		input    string
+		expected string
+	}{
+		// without it, the input's tabs are expanded to 4 columns
+		{0, "```\n\tfoo\n```\n", "<pre><code>    foo\n</code></pre>\n"},
+		{4, "```\n\tfoo\n```\n", "<pre><code>    foo\n</code></pre>\n"},
+		{4, "```\n  \tfoo\n```\n", "<pre><code>    foo\n</code></pre>\n"},
+		{4, "```\n\t  \tfoo\n```\n", "<pre><code>        foo\n</code></pre>\n"},
+		{4, "~~~\nab\tc\nabcd\te\n~~~\n", "<pre><code>ab  c\nabcd    e\n</code></pre>\n"},
+		{8, "```\na\tb\n\tc\n```\n", "<pre><code>a       b\n        c\n</code></pre>\n"},
+		{2, "```\né\t<\n```\n", "<pre><code>é &lt;\n</code></pre>\n"},
+
+		// tabs after the code block are expanded as usual
+		{8, "```\n\tc\n```\n\n\tcode\n", "<pre><code>        c\n</code></pre>\n\n<pre><code>code\n</code></pre>\n"},
+		{8, "``` go\n\tc\n```\n\na\tb\n", "<pre><code class=\"go\">        c\n</code></pre>\n\n<p>a   b</p>\n"},
+	}
+	for _, test := range tests {
+		r := HtmlRenderer(0, "", "").(*Html)
+		r.SetCodeTabWidth(test.width)
+		if actual := string(Markdown([]byte(test.input), r, EXTENSION_FENCED_CODE)); actual != test.expected {
+			t.Errorf("\nWidth   %d\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				test.width, test.input, test.expected, actual)
+		}
+	}
+}
+
+func TestCodeLineNumbers(t *testing.T) {
+	var tests = []string{
+		"```\nfoo\nbar\n```\n",
+		"<pre><code><span class=\"line-number\">1</span>foo\n" +
+			"<span class=\"line-number\">2</span>bar\n</code></pre>\n",
+
+		"``` go {firstline=42}\nfoo\nbar\n```\n",
+		"<pre><code class=\"go\"><span class=\"line-number\">42</span>foo\n" +
+			"<span class=\"line-number\">43</span>bar\n</code></pre>\n",
+
+		"``` {.go firstline=\"7\"}\n<foo>\n```\n",
+		"<pre><code class=\"go\"><span class=\"line-number\">7</span>&lt;foo&gt;\n</code></pre>\n",
+
+		"``` go {firstline=x}\nfoo\n```\n",
+		"<pre><code class=\"go\"><span class=\"line-number\">1</span>foo\n</code></pre>\n",
+
+		"    indented\n    code\n",
+		"<pre><code><span class=\"line-number\">1</span>indented\n" +
+			"<span class=\"line-number\">2</span>code\n</code></pre>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_FENCED_CODE, HTML_CODE_LINE_NUMBERS)
+
+	// the directive is ignored without line numbering
+	tests = []string{
+		"``` {.go firstline=42}\nfoo\n```\n",
+		"<pre><code class=\"go\">foo\n</code></pre>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_FENCED_CODE, 0)
+}
+
+func TestDiffCodeBlock(t *testing.T) {
+	renderer := func(flags int) func() Renderer {
+		return func() Renderer {
+			r := HtmlRenderer(flags, "", "").(*Html)
+			r.SetCodeDiffLines(true)
+			return r
+		}
+	}
+
+	var tests = []string{
+		"```diff\n--- a/x.go\n+++ b/x.go\n@@ -1,3 +1,3 @@\n a := 1\n-b := a < 2\n+b := a > 2\n c := 3\n```\n",
+		"<pre><code class=\"diff\"><span class=\"diff-header\">--- a/x.go</span>\n" +
+			"<span class=\"diff-header\">+++ b/x.go</span>\n" +
+			"<span class=\"diff-hunk\">@@ -1,3 +1,3 @@</span>\n" +
+			" a := 1\n" +
+			"<span class=\"diff-deletion\">-b := a &lt; 2</span>\n" +
+			"<span class=\"diff-addition\">+b := a &gt; 2</span>\n" +
+			" c := 3\n</code></pre>\n",
+
+		"```go\n+x\n```\n",
+		"<pre><code class=\"go\">+x\n</code></pre>\n",
+	}
+	doTestsInlineRenderer(t, tests, EXTENSION_FENCED_CODE, renderer(0))
+
+	tests = []string{
+		"```diff\n-x\n+y\n```\n",
+		"<pre><code class=\"diff\"><span class=\"line-number\">1</span><span class=\"diff-deletion\">-x</span>\n" +
+			"<span class=\"line-number\">2</span><span class=\"diff-addition\">+y</span>\n</code></pre>\n",
+	}
+	doTestsInlineRenderer(t, tests, EXTENSION_FENCED_CODE, renderer(HTML_CODE_LINE_NUMBERS))
+
+	// by default, diffs are plain code
+	tests = []string{
+		"```diff\n-x\n+y\n```\n",
+		"<pre><code class=\"diff\">-x\n+y\n</code></pre>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_FENCED_CODE, 0)
+}
+
+func TestCodeSqueezeBlankLines(t *testing.T) {
+	var tests = []string{
+		"    one\n\n\n\n    two\n    \n\n    three\n\n    four\n",
+		"<pre><code>one\n\ntwo\n\nthree\n\nfour\n</code></pre>\n",
+
+		"```\na\n\n\n\nb\n```\n",
+		"<pre><code>a\n\nb\n</code></pre>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_FENCED_CODE, HTML_CODE_SQUEEZE_BLANK_LINES)
+
+	// off by default
+	tests = []string{
+		"```\na\n\n\n\nb\n```\n",
+		"<pre><code>a\n\n\n\nb\n</code></pre>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_FENCED_CODE, 0)
+}
+
+func TestCodeDataLang(t *testing.T) {
+	var tests = []string{
+		"``` go\nfoo\n```\n",
+		"<pre><code class=\"go\" data-lang=\"go\">foo\n</code></pre>\n",
+
+		"``` {.go .numbered}\nfoo\n```\n",
+		"<pre><code class=\"go numbered\" data-lang=\"go\">foo\n</code></pre>\n",
+
+		"```\nfoo\n```\n",
+		"<pre><code>foo\n</code></pre>\n",
+
+		"    foo\n",
+		"<pre><code>foo\n</code></pre>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_FENCED_CODE, HTML_CODE_DATA_LANG)
+}
+
+func TestHRuleHTML(t *testing.T) {
+	var tests = []struct {
+		flags    int
+		html     string
+		expected string
+	}{
+		{0, "", "<p>a</p>\n\n<hr>\n\n<p>b</p>\n"},
+		{HTML_USE_XHTML, "", "<p>a</p>\n\n<hr />\n\n<p>b</p>\n"},
+		{0, "<hr class=\"fancy\">", "<p>a</p>\n\n<hr class=\"fancy\">\n\n<p>b</p>\n"},
+		{HTML_USE_XHTML, "<div class=\"divider\"></div>\n",
+			"<p>a</p>\n\n<div class=\"divider\"></div>\n\n<p>b</p>\n"},
+	}
+	for _, test := range tests {
+		r := HtmlRenderer(test.flags, "", "").(*Html)
+		r.SetHRuleHTML(test.html)
+		actual := string(Markdown([]byte("a\n\n* * *\n\nb\n"), r, 0))
+		if actual != test.expected {
+			t.Errorf("\nHTML    [%#v]\nExpected[%#v]\nActual  [%#v]", test.html, test.expected, actual)
+		}
+	}
+}
+
+func TestBooleanAttributes(t *testing.T) {
+	input := "* [x] done\n* [ ] todo\n\n::: details open Summary\n:::\n"
+	var tests = []struct {
+		flags    int
+		expected string
+	}{
+		{0, "<ul>\n<li class=\"task-list-item\"><input type=\"checkbox\" disabled checked> done</li>\n" +
+			"<li class=\"task-list-item\"><input type=\"checkbox\" disabled> todo</li>\n</ul>\n\n" +
+			"<details open>\n<summary>Summary</summary>\n</details>\n"},
+		{HTML_USE_XHTML, "<ul>\n<li class=\"task-list-item\">" +
+			"<input type=\"checkbox\" disabled=\"disabled\" checked=\"checked\" /> done</li>\n" +
+			"<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"disabled\" /> todo</li>\n</ul>\n\n" +
+			"<details open=\"open\">\n<summary>Summary</summary>\n</details>\n"},
+	}
+	for _, test := range tests {
+		r := HtmlRenderer(test.flags, "", "")
+		actual := string(Markdown([]byte(input), r, EXTENSION_TASK_LISTS|EXTENSION_DETAILS))
+		if actual != test.expected {
+			t.Errorf("\nFlags   [%#x]\nExpected[%#v]\nActual  [%#v]", test.flags, test.expected, actual)
+		}
+	}
+}
+
+func TestHeaderIDToc(t *testing.T) {
+	input := "# One {#first}\n\n## Two\n"
+	output := string(Markdown([]byte(input), HtmlRenderer(HTML_TOC, "", ""), EXTENSION_HEADER_IDS))
+	for _, expected := range []string{
+		"<a href=\"#first\">One</a>",
+		"<a href=\"#toc_1\">Two</a>",
+		"<h1 id=\"first\">One</h1>",
+		"<h2 id=\"toc_1\">Two</h2>",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, output)
+		}
+	}
+}
+
+func TestTocWrapper(t *testing.T) {
+	input := "# One\n"
+	toc := "<ul>\n<li><a href=\"#toc_0\">One</a></li>\n</ul>\n"
+	var tests = []struct {
+		open, close string
+		expected    string
+	}{
+		{"<nav class=\"toc\" aria-label=\"Table of contents\">", "</nav>",
+			"<nav class=\"toc\" aria-label=\"Table of contents\">\n" + toc + "</nav>\n\n"},
+		{"<div id=\"toc\">\n<h2>Contents</h2>\n", "</div>\n",
+			"<div id=\"toc\">\n<h2>Contents</h2>\n" + toc + "</div>\n\n"},
+		{"", "", toc + "\n"},
+	}
+	for _, test := range tests {
+		r := HtmlRenderer(HTML_TOC, "", "").(*Html)
+		r.SetTocWrapper(test.open, test.close)
+		expected := test.expected + "<h1 id=\"toc_0\">One</h1>\n"
+		if actual := string(Markdown([]byte(input), r, 0)); actual != expected {
+			t.Errorf("\nWrapper [%#v %#v]\nExpected[%#v]\nActual  [%#v]", test.open, test.close, expected, actual)
+		}
+	}
+	if actual := string(Markdown([]byte(input), HtmlRenderer(HTML_TOC, "", ""), 0)); !strings.HasPrefix(actual, "<nav>\n<ul>") {
+		t.Errorf("Expected the default wrapper, got [%#v]", actual)
+	}
+}
+
+func TestTocPlainText(t *testing.T) {
+	input := "# Using *emphasis* and `<code>`\n"
+	header := "<h1 id=\"toc_0\">Using <em>emphasis</em> and <code>&lt;code&gt;</code></h1>\n"
+	var tests = []struct {
+		plain    bool
+		expected string
+	}{
+		{false, "<nav>\n<ul>\n<li><a href=\"#toc_0\">Using <em>emphasis</em> and <code>&lt;code&gt;</code></a></li>\n</ul>\n</nav>\n\n" + header},
+		{true, "<nav>\n<ul>\n<li><a href=\"#toc_0\">Using emphasis and &lt;code&gt;</a></li>\n</ul>\n</nav>\n\n" + header},
+	}
+	for _, test := range tests {
+		r := HtmlRenderer(HTML_TOC, "", "").(*Html)
+		r.SetTocPlainText(test.plain)
+		if actual := string(Markdown([]byte(input), r, 0)); actual != test.expected {
+			t.Errorf("\nPlain   [%v]\nExpected[%#v]\nActual  [%#v]", test.plain, test.expected, actual)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	r := HtmlRenderer(HTML_TOC, "", "").(*Html)
+	for _, input := range []string{"# One\n\n## Two\n", "# Three\n\n- [x] done\n"} {
+		expected := string(Markdown([]byte(input), HtmlRenderer(HTML_TOC, "", ""), EXTENSION_TASK_LISTS))
+		r.Reset()
+		if actual := string(Markdown([]byte(input), r, EXTENSION_TASK_LISTS)); actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+		}
+	}
+}
+
+func TestDocumentFilter(t *testing.T) {
+	r := HtmlRenderer(HTML_TOC, "", "").(*Html)
+	calls := 0
+	r.SetDocumentFilter(func(output []byte) []byte {
+		calls++
+		return bytes.Replace(output, []byte("\"/assets/"), []byte("\"https://cdn.example.com/"), -1)
+	})
+	input := "# One\n\n![a](/assets/a.png)\n\n## Two\n"
+	expected := "<nav>\n<ul>\n<li><a href=\"#toc_0\">One</a>\n<ul>\n<li><a href=\"#toc_1\">Two</a></li>\n</ul></li>\n</ul>\n</nav>\n\n" +
+		"<h1 id=\"toc_0\">One</h1>\n\n<p><img src=\"https://cdn.example.com/a.png\" alt=\"a\">\n</p>\n\n<h2 id=\"toc_1\">Two</h2>\n"
+	if actual := string(Markdown([]byte(input), r, 0)); actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+	if calls != 1 {
+		t.Errorf("Expected the filter to run once, ran %d times", calls)
+	}
+}
+
+func TestDirectiveHandler(t *testing.T) {
+	r := HtmlRenderer(0, "", "").(*Html)
+	r.SetDirectiveHandler("figure", func(out *bytes.Buffer, attrs []DirectiveAttr, text []byte) {
+		out.WriteString("<figure")
+		for _, attr := range attrs {
+			fmt.Fprintf(out, " data-%s=%q", attr.Key, attr.Value)
+		}
+		out.WriteString(">\n")
+		out.Write(text)
+		out.WriteString("</figure>\n")
+	})
+	input := "::: figure {src=a.png}\nCaption\n:::\n\n::: other\nx\n:::\n"
+	expected := "<figure data-src=\"a.png\">\n<p>Caption</p>\n</figure>\n\n<div class=\"other\">\n<p>x</p>\n</div>\n"
+	if actual := string(Markdown([]byte(input), r, EXTENSION_DIRECTIVES)); actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+}
+
+func TestTableWithoutHeader(t *testing.T) {
+	r := HtmlRenderer(0, "", "")
+	var row, body, out bytes.Buffer
+	r.TableCell(&row, []byte("a"), 0)
+	r.TableRow(&body, row.Bytes())
+	r.Table(&out, nil, body.Bytes(), []int{0})
+	expected := "<table>\n<tbody>\n<tr>\n<td>a</td>\n</tr>\n</tbody>\n</table>\n"
+	if out.String() != expected {
+		t.Errorf("\nExpected[%#v]\nActual  [%#v]", expected, out.String())
+	}
+}
+
+func TestTableHeaderScope(t *testing.T) {
+	input := "a | b\n:--|---\nc | d\n"
+	expected := "<table>\n<thead>\n<tr>\n<th scope=\"col\" align=\"left\">a</th>\n<th scope=\"col\">b</th>\n</tr>\n</thead>\n\n" +
+		"<tbody>\n<tr>\n<td align=\"left\">c</td>\n<td>d</td>\n</tr>\n</tbody>\n</table>\n"
+	if actual := string(Markdown([]byte(input), HtmlRenderer(HTML_TABLE_HEADER_SCOPE, "", ""), EXTENSION_TABLES)); actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+}
+
+func TestFootnoteReturnLinks(t *testing.T) {
+	input := "a[^x] b[^y] c[^x]\n\n[^x]: note x\n[^y]: note y\n\n    more\n"
+	expected := "<p>a<sup class=\"footnote-ref\" id=\"fnref:x\"><a rel=\"footnote\" href=\"#fn:x\">1</a></sup>" +
+		" b<sup class=\"footnote-ref\" id=\"fnref:y\"><a rel=\"footnote\" href=\"#fn:y\">2</a></sup>" +
+		" c<sup class=\"footnote-ref\" id=\"fnref2:x\"><a rel=\"footnote\" href=\"#fn:x\">1</a></sup></p>\n" +
+		"<div class=\"footnotes\">\n\n<hr>\n\n<ol>\n" +
+		"<li id=\"fn:x\">note x <a class=\"footnote-return\" href=\"#fnref:x\">^</a>" +
+		" <a class=\"footnote-return\" href=\"#fnref2:x\">^<sup>2</sup></a>\n</li>\n\n" +
+		"<li id=\"fn:y\"><p>note y</p>\n\n<p>more <a class=\"footnote-return\" href=\"#fnref:y\">^</a></p>\n</li>\n" +
+		"</ol>\n</div>\n"
+	r := HtmlRenderer(0, "", "").(*Html)
+	r.SetFootnoteReturnLink("^")
+	if actual := string(Markdown([]byte(input), r, EXTENSION_FOOTNOTES)); actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+}
+
+func TestFootnoteGitHubIDs(t *testing.T) {
+	input := "a[^x] b[^2] c[^x]\n\n[^x]: note x\n[^2]: note 2\n"
+	expected := "<p>a<sup><a href=\"#user-content-fn-x\" id=\"user-content-fnref-x\" data-footnote-ref " +
+		"aria-describedby=\"footnote-label\">1</a></sup>" +
+		" b<sup><a href=\"#user-content-fn-2\" id=\"user-content-fnref-2\" data-footnote-ref " +
+		"aria-describedby=\"footnote-label\">2</a></sup>" +
+		" c<sup><a href=\"#user-content-fn-x\" id=\"user-content-fnref-x-2\" data-footnote-ref " +
+		"aria-describedby=\"footnote-label\">1</a></sup></p>\n" +
+		"<section data-footnotes class=\"footnotes\"><h2 id=\"footnote-label\" class=\"sr-only\">Footnotes</h2>\n\n" +
+		"<ol>\n<li id=\"user-content-fn-x\">note x" +
+		" <a href=\"#user-content-fnref-x\" data-footnote-backref aria-label=\"Back to reference 1\" " +
+		"class=\"data-footnote-backref\">&#8617;</a>" +
+		" <a href=\"#user-content-fnref-x-2\" data-footnote-backref aria-label=\"Back to reference 1-2\" " +
+		"class=\"data-footnote-backref\">&#8617;<sup class=\"footnote-ref\">2</sup></a>\n</li>\n" +
+		"<li id=\"user-content-fn-2\">note 2" +
+		" <a href=\"#user-content-fnref-2\" data-footnote-backref aria-label=\"Back to reference 2\" " +
+		"class=\"data-footnote-backref\">&#8617;</a>\n</li>\n</ol>\n</section>\n"
+	r := HtmlRenderer(0, "", "").(*Html)
+	r.SetFootnoteIDs(FOOTNOTE_IDS_GITHUB)
+	if actual := string(Markdown([]byte(input), r, EXTENSION_FOOTNOTES)); actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+
+	// the default scheme is unchanged
+	r = HtmlRenderer(0, "", "").(*Html)
+	r.SetFootnoteIDs(FOOTNOTE_IDS_DEFAULT)
+	output := string(Markdown([]byte(input), r, EXTENSION_FOOTNOTES))
+	if !strings.Contains(output, "id=\"fnref2:x\"><a rel=\"footnote\" href=\"#fn:x\">") ||
+		!strings.Contains(output, "<li id=\"fn:2\">") {
+		t.Errorf("unexpected default ids:\n%s", output)
+	}
+}
+
+func TestFootnoteSymbols(t *testing.T) {
+	input := "a[^1] b[^2] c[^3]\n\n[^1]: one\n[^2]: two\n[^3]: three\n"
+	r := HtmlRenderer(0, "", "").(*Html)
+	r.SetFootnoteSymbols([]string{"*", "&dagger;"})
+	output := string(Markdown([]byte(input), r, EXTENSION_FOOTNOTES))
+	for _, expected := range []string{
+		"<a rel=\"footnote\" href=\"#fn:1\">*</a>",
+		"<a rel=\"footnote\" href=\"#fn:2\">&dagger;</a>",
+		"<a rel=\"footnote\" href=\"#fn:3\">**</a>",
+		"<ul class=\"footnote-symbols\">\n<li id=\"fn:1\"><span class=\"footnote-mark\">*</span> one\n</li>",
+		"<li id=\"fn:3\"><span class=\"footnote-mark\">**</span> three\n</li>\n</ul>",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, output)
+		}
+	}
+
+	// a link whose label starts with ^ is not a footnote, and keeps its number
+	input = "x[^1](x)\n"
+	for _, symbols := range [][]string{{"*"}, {"*", "&dagger;"}} {
+		r := HtmlRenderer(0, "", "").(*Html)
+		r.SetFootnoteSymbols(symbols)
+		expected := string(Markdown([]byte(input), HtmlRenderer(0, "", ""), EXTENSION_FOOTNOTES))
+		if output := string(Markdown([]byte(input), r, EXTENSION_FOOTNOTES)); output != expected {
+			t.Errorf("\nSymbols [%v]\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				symbols, input, expected, output)
+		}
+	}
+}
+
+func TestEntities(t *testing.T) {
+	input := "&copy; &#169; &#xA9; &amp; &bogus; &ampx; &#abc;\n"
+	var tests = []struct {
+		flags    int
+		expected string
+	}{
+		{0, "<p>&copy; &#169; &#xA9; &amp; &bogus; &ampx; &#abc;</p>\n"},
+		{ENTITIES_ESCAPE_UNKNOWN, "<p>&copy; &#169; &#xA9; &amp; &amp;bogus; &amp;ampx; &amp;#abc;</p>\n"},
+		{ENTITIES_NUMERIC, "<p>&#169; &#169; &#169; &#38; &bogus; &ampx; &#abc;</p>\n"},
+		{ENTITIES_ESCAPE_UNKNOWN | ENTITIES_NUMERIC,
+			"<p>&#169; &#169; &#169; &#38; &amp;bogus; &amp;ampx; &amp;#abc;</p>\n"},
+	}
+	for _, test := range tests {
+		r := HtmlRenderer(0, "", "").(*Html)
+		r.SetEntities(test.flags)
+		if actual := string(Markdown([]byte(input), r, 0)); actual != test.expected {
+			t.Errorf("\nFlags   [%#x]\nExpected[%#v]\nActual  [%#v]", test.flags, test.expected, actual)
+		}
+	}
+}
+
+func TestNormalizeLinks(t *testing.T) {
+	var tests = []string{
+		"[a](HTTP://Example.COM/Path?Q=A#Frag)\n",
+		"<p><a href=\"http://example.com/Path?Q=A#Frag\">a</a></p>\n",
+
+		"[a](HTTPS://User:PW@WWW.Example.com:8080/A/B)\n",
+		"<p><a href=\"https://User:PW@www.example.com:8080/A/B\">a</a></p>\n",
+
+		"[a](/Relative/Path) [b](Mailto:Jo@Example.COM?Subject=Hi)\n",
+		"<p><a href=\"/Relative/Path\">a</a> <a href=\"mailto:Jo@example.com?Subject=Hi\">b</a></p>\n",
+
+		"see <HTTP://Example.COM/Path>\n",
+		"<p>see <a href=\"http://example.com/Path\">http://example.com/Path</a></p>\n",
+
+		"mail <Jo@Example.COM>\n",
+		"<p>mail <a href=\"mailto:Jo@example.com\">Jo@example.com</a></p>\n",
+	}
+	doTestsInlineRenderer(t, tests, 0, func() Renderer {
+		r := HtmlRenderer(0, "", "").(*Html)
+		r.SetNormalizeLinks(true)
+		return r
+	})
+}
+
+func TestObfuscateEmail(t *testing.T) {
+	// jo@x.io, and mailto: then jo@x.io, each alternating decimal and hex
+	address := "&#106;&#x6f;&#64;&#x78;&#46;&#x69;&#111;"
+	mailto := "&#109;&#x61;&#105;&#x6c;&#116;&#x6f;&#58;"
+	var tests = []string{
+		"mail <jo@x.io>\n",
+		"<p>mail <a href=\"" + mailto + address + "\">" + address + "</a></p>\n",
+
+		"<mailto:jo@x.io> <http://x.io/>\n",
+		"<p><a href=\"" + mailto + "&#x6a;&#111;&#x40;&#120;&#x2e;&#105;&#x6f;\">" + address + "</a> " +
+			"<a href=\"http://x.io/\">http://x.io/</a></p>\n",
+	}
+	renderer := func() Renderer {
+		r := HtmlRenderer(0, "", "").(*Html)
+		r.SetObfuscateEmail(true)
+		return r
+	}
+	doTestsInlineRenderer(t, tests, 0, renderer)
+
+	input := "Write to <jo@example.com> or <mailto:al@example.com>, or see [mail](mailto:x@example.com).\n"
+	output := string(Markdown([]byte(input), renderer(), EXTENSION_AUTOLINK))
+	if strings.Contains(output, "mailto:jo") || strings.Contains(output, "mailto:al") ||
+		strings.Count(output, "@") != 1 {
+		t.Errorf("email autolinks are not hidden:\n%s", output)
+	}
+}
+
+func TestResponsiveTables(t *testing.T) {
+	input := "a | b\n--- | ---\nc | d\n"
+	table := "<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n\n" +
+		"<tbody>\n<tr>\n<td>c</td>\n<td>d</td>\n</tr>\n</tbody>\n</table>\n"
+	var tests = []struct {
+		class    string
+		expected string
+	}{
+		{"", table},
+		{"table-responsive", "<div class=\"table-responsive\" style=\"overflow-x:auto\">\n" + table + "</div>\n"},
+		{"scroll", "<div class=\"scroll\" style=\"overflow-x:auto\">\n" + table + "</div>\n"},
+	}
+	for _, test := range tests {
+		r := HtmlRenderer(0, "", "").(*Html)
+		r.SetResponsiveTables(test.class)
+		if actual := string(Markdown([]byte(input), r, EXTENSION_TABLES)); actual != test.expected {
+			t.Errorf("\nClass   [%s]\nExpected[%#v]\nActual  [%#v]", test.class, test.expected, actual)
+		}
+	}
+}
+
+func TestDiagramLanguages(t *testing.T) {
+	var tests = []string{
+		"```mermaid\ngraph TD;\n  A-->B;\n  B-->C[\"<b>&</b>\"];\n```\n",
+		"<div class=\"mermaid\">graph TD;\n  A-->B;\n  B-->C[\"&lt;b>&amp;&lt;/b>\"];\n</div>\n",
+
+		"``` {.plantuml}\n@startuml\nAlice -> Bob\n@enduml\n```\n",
+		"<div class=\"plantuml\">@startuml\nAlice -> Bob\n@enduml\n</div>\n",
+
+		// other languages are still code
+		"```go\nif a > b {\n}\n```\n",
+		"<pre><code class=\"go\">if a &gt; b {\n}\n</code></pre>\n",
+
+		"```\nmermaid\n```\n",
+		"<pre><code>mermaid\n</code></pre>\n",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		r := HtmlRenderer(0, "", "").(*Html)
+		r.SetDiagramLanguages([]string{"mermaid", "plantuml"})
+		actual := string(Markdown([]byte(tests[i]), r, EXTENSION_FENCED_CODE))
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", tests[i], tests[i+1], actual)
+		}
+	}
+
+	// without languages, a mermaid block is code
+	input := "```mermaid\nA-->B\n```\n"
+	expected := "<pre><code class=\"mermaid\">A--&gt;B\n</code></pre>\n"
+	if actual := string(Markdown([]byte(input), HtmlRenderer(0, "", ""), EXTENSION_FENCED_CODE)); actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+}
+
+func TestTableHeaderIDs(t *testing.T) {
+	input := "Name | *Unit price* | Name | &amp;\n--- | --- | --- | ---\nName | 2 | 3 | 4\n\n" +
+		"| Name |\n| --- |\n| x |\n"
+	expected := "<table>\n<thead>\n<tr>\n<th id=\"Name\">Name</th>\n<th id=\"Unit-price\"><em>Unit price</em></th>\n" +
+		"<th id=\"Name-1\">Name</th>\n<th>&amp;</th>\n</tr>\n</thead>\n\n" +
+		"<tbody>\n<tr>\n<td>Name</td>\n<td>2</td>\n<td>3</td>\n<td>4</td>\n</tr>\n</tbody>\n</table>\n\n" +
+		"<table>\n<thead>\n<tr>\n<th id=\"Name-2\">Name</th>\n</tr>\n</thead>\n\n" +
+		"<tbody>\n<tr>\n<td>x</td>\n</tr>\n</tbody>\n</table>\n"
+	r := HtmlRenderer(0, "", "").(*Html)
+	r.SetTableHeaderIDs(true)
+	for i := 0; i < 2; i++ {
+		r.Reset()
+		if actual := string(Markdown([]byte(input), r, EXTENSION_TABLES)); actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+		}
+	}
+}
+
+func TestInlineParser(t *testing.T) {
+	mustache := func(out *bytes.Buffer, data []byte, offset int) int {
+		if !bytes.HasPrefix(data[offset:], []byte("{{")) {
+			return 0
+		}
+		end := bytes.Index(data[offset:], []byte("}}"))
+		if end < 0 {
+			return 0
+		}
+		out.WriteString("<var>")
+		attrEscape(out, bytes.TrimSpace(data[offset+2:offset+end]))
+		out.WriteString("</var>")
+		return end + 2
+	}
+	var tests = []string{
+		"Hello {{ name }}, *{{a<b}}*\n",
+		"<p>Hello <var>name</var>, <em><var>a&lt;b</var></em></p>\n",
+
+		"# {{title}}\n\n`{{code}}` and {single} {{unclosed\n",
+		"<h1><var>title</var></h1>\n\n<p><code>{{code}}</code> and {single} {{unclosed</p>\n",
+
+		// the built-in parser gets what the custom one leaves
+		"{{x}} {++added++}\n",
+		"<p><var>x</var> <ins>added</ins></p>\n",
+	}
+	doTestsInlineRenderer(t, tests, EXTENSION_CRITIC_MARKUP, func() Renderer {
+		r := HtmlRenderer(HTML_USE_XHTML, "", "").(*Html)
+		r.SetInlineParser('{', mustache)
+		return r
+	})
+
+	// a custom parser can take over a built-in trigger
+	tests = []string{
+		"**bold** *kept*\n",
+		"<p><b>bold</b> <em>kept</em></p>\n",
+	}
+	doTestsInlineRenderer(t, tests, 0, func() Renderer {
+		r := HtmlRenderer(HTML_USE_XHTML, "", "").(*Html)
+		r.SetInlineParser('*', func(out *bytes.Buffer, data []byte, offset int) int {
+			if !bytes.HasPrefix(data[offset:], []byte("**")) {
+				return 0
+			}
+			end := bytes.Index(data[offset+2:], []byte("**"))
+			if end < 0 {
+				return 0
+			}
+			out.WriteString("<b>")
+			out.Write(data[offset+2 : offset+2+end])
+			out.WriteString("</b>")
+			return end + 4
+		})
+		return r
+	})
+
+	// and removing it restores the default
+	tests = []string{
+		"{{x}}\n",
+		"<p>{{x}}</p>\n",
+	}
+	doTestsInlineRenderer(t, tests, 0, func() Renderer {
+		r := HtmlRenderer(HTML_USE_XHTML, "", "").(*Html)
+		r.SetInlineParser('{', mustache)
+		r.SetInlineParser('{', nil)
+		return r
+	})
+}
+
+func TestListColumns(t *testing.T) {
+	input := "* a\n* b\n    * c\n\ntext\n\n1. d\n"
+	var tests = []struct {
+		columns  int
+		all      bool
+		expected string
+	}{
+		{0, false, "<ul>\n<li>a</li>\n<li>b\n\n<ul>\n<li>c</li>\n</ul></li>\n</ul>\n\n<p>text</p>\n\n<ol>\n<li>d</li>\n</ol>\n"},
+		{1, false, "<ul>\n<li>a</li>\n<li>b\n\n<ul>\n<li>c</li>\n</ul></li>\n</ul>\n\n<p>text</p>\n\n<ol>\n<li>d</li>\n</ol>\n"},
+		{3, false, "<ul style=\"column-count:3\">\n<li>a</li>\n<li>b\n\n<ul>\n<li>c</li>\n</ul></li>\n</ul>\n\n" +
+			"<p>text</p>\n\n<ol>\n<li>d</li>\n</ol>\n"},
+		{2, true, "<ul style=\"column-count:2\">\n<li>a</li>\n<li>b\n\n" +
+			"<ul style=\"column-count:2\">\n<li>c</li>\n</ul></li>\n</ul>\n\n<p>text</p>\n\n<ol style=\"column-count:2\">\n<li>d</li>\n</ol>\n"},
+	}
+	for _, test := range tests {
+		r := HtmlRenderer(0, "", "").(*Html)
+		r.SetListColumns(test.columns, test.all)
+		if actual := string(Markdown([]byte(input), r, 0)); actual != test.expected {
+			t.Errorf("\nColumns [%d] [%v]\nExpected[%#v]\nActual  [%#v]", test.columns, test.all, test.expected, actual)
+		}
+	}
+}
+
+func TestSections(t *testing.T) {
+	var tests = []string{
+		"Intro\n\n# One\n\ntext\n\n## Sub\n\nmore\n\n### Deep\n\n## Sub two\n\n# Two\n",
+		"<p>Intro</p>\n\n<section>\n<h1>One</h1>\n\n<p>text</p>\n\n" +
+			"<section>\n<h2>Sub</h2>\n\n<p>more</p>\n\n<section>\n<h3>Deep</h3>\n</section>\n</section>\n\n" +
+			"<section>\n<h2>Sub two</h2>\n</section>\n</section>\n\n<section>\n<h1>Two</h1>\n</section>\n",
+
+		// a deeper header first, and headers that are not at the top level
+		"### Deep\n\n# Top\n\n> ## Quoted\n\n* item\n\n    ## In a list\n",
+		"<section>\n<h3>Deep</h3>\n</section>\n\n<section>\n<h1>Top</h1>\n\n" +
+			"<blockquote>\n<h2>Quoted</h2>\n</blockquote>\n\n<ul>\n<li><p>item</p>\n\n<h2>In a list</h2></li>\n</ul>\n</section>\n",
+
+		// sections close before the footnotes
+		"# Title\n\nnote[^1]\n\n[^1]: text\n",
+		"<section>\n<h1>Title</h1>\n\n<p>note<sup class=\"footnote-ref\" id=\"fnref:1\">" +
+			"<a rel=\"footnote\" href=\"#fn:1\">1</a></sup></p>\n</section>\n<div class=\"footnotes\">\n\n<hr>\n\n" +
+			"<ol>\n<li id=\"fn:1\">text\n</li>\n</ol>\n</div>\n",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		r := HtmlRenderer(0, "", "").(*Html)
+		r.SetSections(true)
+		actual := string(Markdown([]byte(tests[i]), r, EXTENSION_FOOTNOTES))
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", tests[i], tests[i+1], actual)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	input := "# Title\n\nSome text.\n\n* one\n* two\n\n```\nline one\n\nline two\n```\n\n> quoted\n\n---\n"
+	normal := "<h1>Title</h1>\n\n<p>Some text.</p>\n\n<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n\n" +
+		"<pre><code>line one\n\nline two\n</code></pre>\n\n<blockquote>\n<p>quoted</p>\n</blockquote>\n\n<hr>\n"
+	compact := "<h1>Title</h1>\n<p>Some text.</p>\n<ul><li>one</li>\n<li>two</li>\n</ul>\n" +
+		"<pre><code>line one\n\nline two\n</code></pre>\n<blockquote>\n<p>quoted</p>\n</blockquote>\n<hr>\n"
+	for _, test := range []struct {
+		compact  bool
+		expected string
+	}{{false, normal}, {true, compact}} {
+		r := HtmlRenderer(0, "", "").(*Html)
+		r.SetCompact(test.compact)
+		actual := string(Markdown([]byte(input), r, EXTENSION_FENCED_CODE))
+		if actual != test.expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, test.expected, actual)
+		}
+	}
+}
+
+func TestVoidClose(t *testing.T) {
+	input := "a  \nb ![c](d)\n\n---\n"
+	var tests = []struct {
+		flags    int
+		close    string
+		expected string
+	}{
+		{0, "", "<p>a<br>\nb <img src=\"d\" alt=\"c\">\n</p>\n\n<hr>\n"},
+		{HTML_USE_XHTML, "", "<p>a<br />\nb <img src=\"d\" alt=\"c\" />\n</p>\n\n<hr />\n"},
+		{HTML_USE_XHTML, ">", "<p>a<br>\nb <img src=\"d\" alt=\"c\">\n</p>\n\n<hr>\n"},
+		{0, "/>", "<p>a<br/>\nb <img src=\"d\" alt=\"c\"/>\n</p>\n\n<hr/>\n"},
+	}
+	for _, test := range tests {
+		r := HtmlRenderer(test.flags, "", "").(*Html)
+		r.SetVoidClose(test.close)
+		actual := string(Markdown([]byte(input), r, 0))
+		if actual != test.expected {
+			t.Errorf("\nClose   [%q]\nExpected[%#v]\nActual  [%#v]", test.close, test.expected, actual)
+		}
+	}
+}
+
+func TestTaskProgress(t *testing.T) {
+	var tests = []string{
+		"* [x] one\n* [ ] two\n* [x] three\n",
+		"<span class=\"task-progress\">2/3 done</span>\n<ul>\n" +
+			"<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"disabled\" checked=\"checked\" /> one</li>\n" +
+			"<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"disabled\" /> two</li>\n" +
+			"<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"disabled\" checked=\"checked\" /> three</li>\n</ul>\n",
+
+		// each list counts only its own items
+		"para\n\n* [ ] outer\n    * [x] inner\n",
+		"<p>para</p>\n\n<span class=\"task-progress\">0/1 done</span>\n<ul>\n" +
+			"<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"disabled\" /> outer\n\n" +
+			"<span class=\"task-progress\">1/1 done</span>\n<ul>\n" +
+			"<li class=\"task-list-item\"><input type=\"checkbox\" disabled=\"disabled\" checked=\"checked\" /> inner</li>\n</ul></li>\n</ul>\n",
+
+		// lists without task items get no badge
+		"* one\n* two\n",
+		"<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		r := HtmlRenderer(HTML_USE_XHTML|HTML_TASK_PROGRESS, "", "")
+		actual := string(Markdown([]byte(tests[i]), r, EXTENSION_TASK_LISTS))
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", tests[i], tests[i+1], actual)
+		}
+	}
+}
+
+func TestTaskLines(t *testing.T) {
+	var tests = []string{
+		"* [x] one\n* plain\n* [ ] two\n",
+		"<ul>\n<li class=\"task-list-item\" data-line=\"1\"><input type=\"checkbox\" checked=\"checked\" /> one</li>\n" +
+			"<li>plain</li>\n" +
+			"<li class=\"task-list-item\" data-line=\"3\"><input type=\"checkbox\" /> two</li>\n</ul>\n",
+
+		// nested and quoted items, after front matter and a reference
+		"---\ntitle: x\n---\n[r]: /url\n\n* [ ] outer\n  more\n    * [x] inner\n\n> * [ ] quoted\n",
+		"<ul>\n<li class=\"task-list-item\" data-line=\"6\"><input type=\"checkbox\" /> outer\nmore\n\n<ul>\n" +
+			"<li class=\"task-list-item\" data-line=\"8\"><input type=\"checkbox\" checked=\"checked\" /> inner</li>\n</ul></li>\n</ul>\n\n" +
+			"<blockquote>\n<ul>\n<li class=\"task-list-item\" data-line=\"10\"><input type=\"checkbox\" /> quoted</li>\n</ul>\n</blockquote>\n",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		r := HtmlRenderer(HTML_USE_XHTML, "", "").(*Html)
+		r.SetTaskLines(true)
+		actual := string(Markdown([]byte(tests[i]), r, EXTENSION_TASK_LISTS|EXTENSION_FRONT_MATTER))
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", tests[i], tests[i+1], actual)
+		}
+	}
+}
+
+func TestSourcePos(t *testing.T) {
+	input := "# Title\n\nSome *text*\nhere.\n\n* one\n* two\n  more\n\n```go\ncode\n```\n\n" +
+		"> quote\n> on\n\n---\n\na | b\n--- | ---\nc | d\n\nSetext\n======\n\n    indented\n"
+	expected := "<h1 data-sourcepos=\"1:1-1:7\">Title</h1>\n\n" +
+		"<p data-sourcepos=\"3:1-4:5\">Some <em>text</em>\nhere.</p>\n\n" +
+		"<ul data-sourcepos=\"6:1-8:6\">\n<li data-sourcepos=\"6:1-6:5\">one</li>\n" +
+		"<li data-sourcepos=\"7:1-8:6\">two\nmore</li>\n</ul>\n\n" +
+		"<pre data-sourcepos=\"10:1-12:3\"><code class=\"go\">code\n</code></pre>\n\n" +
+		"<blockquote data-sourcepos=\"14:1-15:4\">\n<p data-sourcepos=\"14:3-15:4\">quote\non</p>\n</blockquote>\n\n" +
+		"<hr data-sourcepos=\"17:1-17:3\" />\n\n" +
+		"<table data-sourcepos=\"19:1-21:5\">\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n\n" +
+		"<tbody>\n<tr>\n<td>c</td>\n<td>d</td>\n</tr>\n</tbody>\n</table>\n\n" +
+		"<h1 data-sourcepos=\"23:1-24:6\">Setext</h1>\n\n" +
+		"<pre data-sourcepos=\"26:5-26:12\"><code>indented\n</code></pre>\n"
+	r := HtmlRenderer(HTML_USE_XHTML, "", "").(*Html)
+	r.SetSourcePos(true)
+	actual := string(Markdown([]byte(input), r, EXTENSION_FENCED_CODE|EXTENSION_TABLES))
+	if actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+}
+
+func TestLinkCallback(t *testing.T) {
+	input := "[safe](http://a.com/ \"T\") [bad](javascript:x) <http://b.com/> ![pic](c.png)\n\n" +
+		"<me@example.com> [*em*](/d)\n"
+	expected := []string{
+		`link "http://a.com/" "T" "safe" 0`,
+		`link "javascript:x" "" "bad" 0`,
+		`link "http://b.com/" "" "http://b.com/" 1`,
+		`link "c.png" "" "pic" 5`,
+		`link "me@example.com" "" "me@example.com" 2`,
+		`link "/d" "" "<em>em</em>" 0`,
+	}
+
+	for _, flags := range []int{0, HTML_SAFELINK | HTML_SKIP_DANGEROUS_LINKS | HTML_SKIP_IMAGES} {
+		var actual []string
+		r := HtmlRenderer(flags, "", "").(*Html)
+		r.SetLinkCallback(func(link, title, content []byte, kind int) {
+			actual = append(actual, fmt.Sprintf("link %q %q %q %d", link, title, content, kind))
+		})
+		output := string(Markdown([]byte(input), r, EXTENSION_AUTOLINK))
+		if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("\nFlags   [%#x]\nExpected[%#v]\nActual  [%#v]", flags, expected, actual)
+		}
+
+		// the output is the same without the callback
+		plain := string(Markdown([]byte(input), HtmlRenderer(flags, "", ""), EXTENSION_AUTOLINK))
+		if output != plain {
+			t.Errorf("\nFlags   [%#x]\nExpected[%#v]\nActual  [%#v]", flags, plain, output)
+		}
+	}
+}
+
+func TestImageResolver(t *testing.T) {
+	renderer := func() Renderer {
+		r := HtmlRenderer(HTML_USE_XHTML, "", "").(*Html)
+		r.SetImageResolver(func(link, alt, title []byte) ImageAttrs {
+			switch string(link) {
+			case "/a.png":
+				return ImageAttrs{
+					Srcset: "/a.png 1x, /a@2x.png 2x",
+					Sizes:  "(max-width: 600px) 100vw, 50vw",
+					Width:  640,
+					Height: 480,
+				}
+			case "/b.png":
+				return ImageAttrs{Src: "/cdn/b.png?w=" + string(title)}
+			}
+			return ImageAttrs{}
+		})
+		return r
+	}
+
+	var tests = []string{
+		"![a](/a.png)\n",
+		"<p><img src=\"/a.png\" srcset=\"/a.png 1x, /a@2x.png 2x\" sizes=\"(max-width: 600px) 100vw, 50vw\" " +
+			"width=\"640\" height=\"480\" alt=\"a\" />\n</p>\n",
+
+		"![b](/b.png \"1&2\")\n",
+		"<p><img src=\"/cdn/b.png?w=1&amp;2\" alt=\"b\" title=\"1&amp;2\" />\n</p>\n",
+
+		"![c](/c.png)\n",
+		"<p><img src=\"/c.png\" alt=\"c\" />\n</p>\n",
+	}
+	doTestsInlineRenderer(t, tests, 0, renderer)
+}
+
+func TestImageParagraphs(t *testing.T) {
+	var tests = []string{
+		"![a](/a.png)\n",
+		"<p class=\"image\"><img src=\"/a.png\" alt=\"a\" />\n</p>\n",
+
+		"![a](/a.png \"Title\")\n",
+		"<p class=\"image\"><img src=\"/a.png\" alt=\"a\" title=\"Title\" />\n</p>\n",
+
+		"![a](/a.png) and text\n",
+		"<p><img src=\"/a.png\" alt=\"a\" />\n and text</p>\n",
+
+		"![a](/a.png) ![b](/b.png)\n",
+		"<p><img src=\"/a.png\" alt=\"a\" />\n <img src=\"/b.png\" alt=\"b\" />\n</p>\n",
+
+		"[![a](/a.png)](/big.png)\n",
+		"<p><a href=\"/big.png\"><img src=\"/a.png\" alt=\"a\" />\n</a></p>\n",
+
+		"*![a](/a.png)*\n",
+		"<p><em><img src=\"/a.png\" alt=\"a\" />\n</em></p>\n",
+	}
+	doTestsInlineRenderer(t, tests, 0, func() Renderer {
+		r := HtmlRenderer(HTML_USE_XHTML, "", "").(*Html)
+		r.SetImageParagraphClass("image")
+		return r
+	})
+
+	tests = []string{
+		"![a](/a.png)\n",
+		"<p><img src=\"/a.png\" alt=\"a\" />\n</p>\n",
+	}
+	doTestsInlineParam(t, tests, 0, 0)
+}
+
+func TestImagePicture(t *testing.T) {
+	renderer := func() Renderer {
+		r := HtmlRenderer(HTML_USE_XHTML, "", "").(*Html)
+		r.SetImageResolver(func(link, alt, title []byte) ImageAttrs {
+			switch string(link) {
+			case "/hero.png":
+				return ImageAttrs{Sources: []ImageSource{
+					{Media: "(min-width: 800px)", Srcset: "/hero-wide.webp", Type: "image/webp"},
+					{Media: "(min-width: 800px)", Srcset: "/hero-wide.png 1x, /hero-wide@2x.png 2x"},
+					{Srcset: "/hero-narrow.png", Sizes: "100vw"},
+				}}
+			case "/one.png":
+				return ImageAttrs{Sources: []ImageSource{{Srcset: "/one.webp", Type: "image/webp"}}}
+			}
+			return ImageAttrs{}
+		})
+		return r
+	}
+
+	var tests = []string{
+		"![Hero & co](/hero.png)\n",
+		"<p><picture>\n" +
+			"<source media=\"(min-width: 800px)\" srcset=\"/hero-wide.webp\" type=\"image/webp\" />\n" +
+			"<source media=\"(min-width: 800px)\" srcset=\"/hero-wide.png 1x, /hero-wide@2x.png 2x\" />\n" +
+			"<source srcset=\"/hero-narrow.png\" sizes=\"100vw\" />\n" +
+			"<img src=\"/hero.png\" alt=\"Hero &amp; co\" />\n</picture></p>\n",
+
+		"![One](/one.png)\n",
+		"<p><img src=\"/one.png\" alt=\"One\" />\n</p>\n",
+	}
+	doTestsInlineRenderer(t, tests, 0, renderer)
+}
+
+func TestImageBaseURL(t *testing.T) {
+	renderer := func() Renderer {
+		r := HtmlRenderer(HTML_USE_XHTML, "", "").(*Html)
+		r.SetImageBaseURL("https://cdn.example.com/")
+		return r
+	}
+
+	var tests = []string{
+		"![a](/img/a.png) [a](/img/a.png)\n",
+		"<p><img src=\"https://cdn.example.com/img/a.png\" alt=\"a\" />\n <a href=\"/img/a.png\">a</a></p>\n",
+
+		"![b](img/b.png?v=2) [b](img/b.png)\n",
+		"<p><img src=\"https://cdn.example.com/img/b.png?v=2\" alt=\"b\" />\n <a href=\"img/b.png\">b</a></p>\n",
+
+		"![c](http://example.com/c.png)\n",
+		"<p><img src=\"http://example.com/c.png\" alt=\"c\" />\n</p>\n",
+
+		"![d](//example.com/d.png)\n",
+		"<p><img src=\"//example.com/d.png\" alt=\"d\" />\n</p>\n",
+
+		"![e](data:image/png;base64,iVBO)\n",
+		"<p><img src=\"data:image/png;base64,iVBO\" alt=\"e\" />\n</p>\n",
+	}
+	doTestsInlineRenderer(t, tests, 0, renderer)
+}
+
+func TestImagePlaceholder(t *testing.T) {
+	resolver := func(link, alt, title []byte) ImageAttrs {
+		if string(link) == "/plain.png" {
+			return ImageAttrs{Loading: "lazy"}
+		}
+		return ImageAttrs{
+			Srcset:      "/a.png 1x, /a@2x.png 2x",
+			Loading:     "lazy",
+			Placeholder: "data:image/png;base64,iVBO",
+		}
+	}
+	var tests = []struct {
+		src, srcset string
+		expected    string
+	}{
+		{"", "", "<p><img src=\"data:image/png;base64,iVBO\" data-src=\"/a.png\" " +
+			"data-srcset=\"/a.png 1x, /a@2x.png 2x\" loading=\"lazy\" alt=\"a\" />\n</p>\n\n" +
+			"<p><img src=\"/plain.png\" loading=\"lazy\" alt=\"b\" />\n</p>\n"},
+		{"data-lazy", "", "<p><img src=\"data:image/png;base64,iVBO\" data-lazy=\"/a.png\" " +
+			"data-srcset=\"/a.png 1x, /a@2x.png 2x\" loading=\"lazy\" alt=\"a\" />\n</p>\n\n" +
+			"<p><img src=\"/plain.png\" loading=\"lazy\" alt=\"b\" />\n</p>\n"},
+		{"data-original", "data-original-set", "<p><img src=\"data:image/png;base64,iVBO\" data-original=\"/a.png\" " +
+			"data-original-set=\"/a.png 1x, /a@2x.png 2x\" loading=\"lazy\" alt=\"a\" />\n</p>\n\n" +
+			"<p><img src=\"/plain.png\" loading=\"lazy\" alt=\"b\" />\n</p>\n"},
+	}
+	input := "![a](/a.png)\n\n![b](/plain.png)\n"
+	for _, test := range tests {
+		renderer := HtmlRenderer(HTML_USE_XHTML, "", "").(*Html)
+		renderer.SetImageResolver(resolver)
+		renderer.SetLazyImageAttrs(test.src, test.srcset)
+		if actual := string(Markdown([]byte(input), renderer, 0)); actual != test.expected {
+			t.Errorf("\nAttrs   [%q %q]\nExpected[%#v]\nActual  [%#v]", test.src, test.srcset, test.expected, actual)
+		}
+	}
+}
+
+func TestWrapWidth(t *testing.T) {
+	var tests = []string{
+		"one two three four five six\n",
+		"<p>one two three\nfour five six</p>\n",
+
+		"one\ntwo\n",
+		"<p>one two</p>\n",
+
+		"a [link with spaces](/url \"and a title\") b\n",
+		"<p>a\n<a href=\"/url\" title=\"and a title\">link with spaces</a>\nb</p>\n",
+
+		"one two three  \nfour five six seven\n",
+		"<p>one two three<br />\nfour five six\nseven</p>\n",
+
+		"* one two three four\n* five\n    * six seven eight nine\n",
+		"<ul>\n<li>one two\nthree four</li>\n<li>five\n\n<ul>\n<li>six seven\neight nine</li>\n</ul></li>\n</ul>\n",
+
+		"    code is never wrapped at all\n",
+		"<pre><code>code is never wrapped at all\n</code></pre>\n",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		r := HtmlRenderer(HTML_USE_XHTML, "", "").(*Html)
+		r.SetWrapWidth(16)
+		actual := string(Markdown([]byte(tests[i]), r, 0))
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				tests[i], tests[i+1], actual)
+		}
+	}
+
+	// a code span is never split, even when it is wider than a line
+	input := "aaa `b  c d e f g` h\n"
+	expected := "<p>aaa\n<code>b  c d e f g</code>\nh</p>\n"
+	r := HtmlRenderer(HTML_USE_XHTML, "", "").(*Html)
+	r.SetWrapWidth(8)
+	if actual := string(Markdown([]byte(input), r, 0)); actual != expected {
+		t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, expected, actual)
+	}
+}
+
+func TestDoctypeAndLang(t *testing.T) {
+	var tests = []struct {
+		flags    int
+		doctype  int
+		lang     string
+		expected string
+	}{
+		{0, DOCTYPE_DEFAULT, "", "<!DOCTYPE html>\n<html>\n"},
+		{HTML_USE_XHTML, DOCTYPE_DEFAULT, "",
+			"<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Transitional//EN\" " +
+				"\"http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd\">\n" +
+				"<html xmlns=\"http://www.w3.org/1999/xhtml\">\n"},
+		{HTML_USE_XHTML, DOCTYPE_HTML5, "en", "<!DOCTYPE html>\n<html lang=\"en\">\n"},
+		{0, DOCTYPE_HTML4, "en-US",
+			"<!DOCTYPE HTML PUBLIC \"-//W3C//DTD HTML 4.01 Transitional//EN\" " +
+				"\"http://www.w3.org/TR/html4/loose.dtd\">\n<html lang=\"en-US\">\n"},
+		{0, DOCTYPE_XHTML, "fr",
+			"<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Transitional//EN\" " +
+				"\"http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd\">\n" +
+				"<html xmlns=\"http://www.w3.org/1999/xhtml\" lang=\"fr\" xml:lang=\"fr\">\n"},
+		{0, DOCTYPE_HTML5, "\"x\"", "<!DOCTYPE html>\n<html lang=\"&quot;x&quot;\">\n"},
+	}
+	for _, test := range tests {
+		r := HtmlRenderer(test.flags|HTML_COMPLETE_PAGE, "", "").(*Html)
+		r.SetDoctype(test.doctype)
+		r.SetLang(test.lang)
+		var out bytes.Buffer
+		r.DocumentHeader(&out)
+		if actual := out.String(); !strings.HasPrefix(actual, test.expected+"<head>\n") {
+			t.Errorf("\nDoctype %d\nLang    %q\nExpected[%#v]\nActual  [%#v]",
+				test.doctype, test.lang, test.expected, actual)
+		}
+	}
+}
+
+func TestHeadExtra(t *testing.T) {
+	var tests = []struct {
+		css      string
+		extra    string
+		expected string
+	}{
+		{"", "", "  <meta charset=\"utf-8\">\n</head>\n"},
+		{"", "<script src=\"a.js\"></script>",
+			"  <meta charset=\"utf-8\">\n<script src=\"a.js\"></script>\n</head>\n"},
+		{"style.css", "  <meta name=\"viewport\" content=\"width=device-width\">\n  <link rel=\"icon\" href=\"/favicon.ico\">\n",
+			"  <link rel=\"stylesheet\" type=\"text/css\" href=\"style.css\">\n" +
+				"  <meta name=\"viewport\" content=\"width=device-width\">\n" +
+				"  <link rel=\"icon\" href=\"/favicon.ico\">\n</head>\n"},
+	}
+	for _, test := range tests {
+		r := HtmlRenderer(HTML_COMPLETE_PAGE, "", test.css).(*Html)
+		r.SetHeadExtra(test.extra)
+		var out bytes.Buffer
+		r.DocumentHeader(&out)
+		if actual := out.String(); !strings.Contains(actual, test.expected) {
+			t.Errorf("\nExtra   [%#v]\nExpected[%#v]\nActual  [%#v]", test.extra, test.expected, actual)
+		}
+	}
+}
+
+func TestArticleJSONLD(t *testing.T) {
+	var tests = []struct {
+		meta     *ArticleMetadata
+		expected string
+	}{
+		{nil, ""},
+		{&ArticleMetadata{},
+			`  <script type="application/ld+json">{"@context":"https://schema.org","@type":"Article",` +
+				`"headline":"Tips \u0026 \u003c/script\u003e tricks"}</script>` + "\n"},
+		{&ArticleMetadata{Author: "Ann \"A\" Lee", DatePublished: "2024-05-01",
+			DateModified: "2024-06-02", Description: "How to", Image: "https://example.com/a.png"},
+			`  <script type="application/ld+json">{"@context":"https://schema.org","@type":"Article",` +
+				`"headline":"Tips \u0026 \u003c/script\u003e tricks","author":{"@type":"Person","name":"Ann \"A\" Lee"},` +
+				`"datePublished":"2024-05-01","dateModified":"2024-06-02","description":"How to",` +
+				`"image":"https://example.com/a.png"}</script>` + "\n"},
+	}
+	for _, test := range tests {
+		r := HtmlRenderer(HTML_COMPLETE_PAGE, "Tips & </script> tricks", "").(*Html)
+		if test.meta != nil {
+			r.SetArticleMetadata(*test.meta)
+		}
+		var out bytes.Buffer
+		r.DocumentHeader(&out)
+		script := ""
+		if start := strings.Index(out.String(), "  <script"); start >= 0 {
+			script = out.String()[start:strings.Index(out.String(), "</head>")]
+		}
+		if script != test.expected {
+			t.Errorf("\nMeta    [%+v]\nExpected[%#v]\nActual  [%#v]", test.meta, test.expected, script)
+		}
+	}
+}
+
+func TestOmitGenerator(t *testing.T) {
+	var tests = []struct {
+		flags    int
+		expected string
+	}{
+		{0, "  <title>T</title>\n  <meta name=\"GENERATOR\" content=\"Blackfriday Markdown Processor v" +
+			VERSION + "\">\n  <meta charset=\"utf-8\">\n"},
+		{HTML_OMIT_GENERATOR, "  <title>T</title>\n  <meta charset=\"utf-8\">\n"},
+		{HTML_OMIT_GENERATOR | HTML_USE_XHTML, "  <title>T</title>\n  <meta charset=\"utf-8\" />\n"},
+	}
+	for _, test := range tests {
+		r := HtmlRenderer(test.flags|HTML_COMPLETE_PAGE, "T", "")
+		var out bytes.Buffer
+		r.DocumentHeader(&out)
+		if actual := out.String(); !strings.Contains(actual, "<head>\n"+test.expected+"</head>\n") {
+			t.Errorf("\nFlags   %d\nExpected[%#v]\nActual  [%#v]", test.flags, test.expected, actual)
+		}
+	}
+}
+
+func TestStylesheets(t *testing.T) {
+	var tests = []struct {
+		css      string
+		urls     []string
+		expected string
+	}{
+		{"", nil, "  <meta charset=\"utf-8\">\n</head>\n"},
+		{"style.css", nil,
+			"  <link rel=\"stylesheet\" type=\"text/css\" href=\"style.css\">\n</head>\n"},
+		{"style.css", []string{"/b.css", "a.css?x=1&y=2"},
+			"  <link rel=\"stylesheet\" type=\"text/css\" href=\"/b.css\">\n" +
+				"  <link rel=\"stylesheet\" type=\"text/css\" href=\"a.css?x=1&amp;y=2\">\n</head>\n"},
+	}
+	for _, test := range tests {
+		r := HtmlRenderer(HTML_COMPLETE_PAGE, "", test.css).(*Html)
+		if test.urls != nil {
+			r.SetStylesheets(test.urls)
+		}
+		var out bytes.Buffer
+		r.DocumentHeader(&out)
+		if actual := out.String(); !strings.HasSuffix(actual, test.expected+"<body>\n") {
+			t.Errorf("\nStylesheets %q\nExpected[%#v]\nActual  [%#v]", test.urls, test.expected, actual)
+		}
+	}
+}
+
+func TestElementEscape(t *testing.T) {
+	var tests = []string{
+		"", "",
+		"plain", "plain",
+		"a \"quoted\" 'word'", "a \"quoted\" 'word'",
+		"<a href=\"x\">&amp;</a>", "&lt;a href=\"x\"&gt;&amp;amp;&lt;/a&gt;",
+		"&&<<>>", "&amp;&amp;&lt;&lt;&gt;&gt;",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		var out bytes.Buffer
+		elementEscape(&out, []byte(tests[i]))
+		if actual := out.String(); actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", tests[i], tests[i+1], actual)
+		}
+	}
+
+	// code keeps its quotes; attributes still escape them
+	tests = []string{
+		"``` {.a\"b}\nx = \"y\"\n```\n",
+		"<pre><code class=\"a&quot;b\">x = \"y\"\n</code></pre>\n",
+
+		"`x = \"<y>\"` and [\"z\"](/z)\n",
+		"<p><code>x = \"&lt;y&gt;\"</code> and <a href=\"/z\">&quot;z&quot;</a></p>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_FENCED_CODE, 0)
+}
+
+var escapeBenchmarkText = bytes.Repeat([]byte("if s[i] == \"<\" && strings.HasPrefix(s, \"<a\") {\n\treturn x > 0\n}\n"), 1000)
+
+func BenchmarkElementEscape(b *testing.B) {
+	var out bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		out.Reset()
+		elementEscape(&out, escapeBenchmarkText)
+	}
+}
+
+func BenchmarkAttrEscape(b *testing.B) {
+	var out bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		out.Reset()
+		attrEscape(&out, escapeBenchmarkText)
+	}
+}
@@ -15,7 +15,13 @@ package blackfriday
 
 import (
 	"bytes"
+	"html"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Functions to parse text within a block
@@ -24,11 +30,19 @@ import (
 // offset is the number of valid chars before the current cursor
 
 func (p *parser) inline(out *bytes.Buffer, data []byte) {
-	// this is called recursively: enforce a maximum depth
+	if p.skipInline {
+		return
+	}
+
+	// this is called recursively: enforce a maximum depth, keeping what is
+	// nested too deeply as plain text
 	if p.nesting >= p.maxNesting {
+		p.r.NormalText(out, data)
 		return
 	}
 	p.nesting++
+	emphasisRuns := p.emphasisRuns
+	p.emphasisRuns = nil
 
 	i, end := 0, 0
 	for i < len(data) {
@@ -37,13 +51,26 @@ func (p *parser) inline(out *bytes.Buffer, data []byte) {
 			end++
 		}
 
-		p.r.NormalText(out, data[i:end])
+		if stop := p.normalText(out, data, i, end); stop > end {
+			// a date ran on past the next trigger
+			i, end = stop, stop
+			continue
+		}
 
 		if end >= len(data) {
 			break
 		}
 		i = end
 
+		// once the budget is spent, the rest is plain text
+		if p.inlineBudget != 0 {
+			if p.inlineBudget -= len(data) - i; p.inlineBudget <= 0 {
+				p.inlineBudget = -1
+				p.normalText(out, data, i, len(data))
+				break
+			}
+		}
+
 		// call the trigger
 		handler := p.inlineCallback[data[end]]
 		if consumed := handler(p, out, data, i); consumed == 0 {
@@ -56,11 +83,181 @@ func (p *parser) inline(out *bytes.Buffer, data []byte) {
 		}
 	}
 
+	p.emphasisRuns = emphasisRuns
 	p.nesting--
 }
 
+// Render data[beg:end] as normal text. With EXTENSION_REPO_REFERENCES,
+// issue and commit references within it are rendered as autolinks, and with
+// EXTENSION_DATES, dates and times are rendered with Time; the rest of data
+// supplies context for detecting word boundaries. Returns where the text
+// rendered ends, which is past end if a date ran on past it.
+func (p *parser) normalText(out *bytes.Buffer, data []byte, beg, end int) int {
+	refs := p.flags&EXTENSION_REPO_REFERENCES != 0 && !p.insideLink
+	dates := p.flags&EXTENSION_DATES != 0
+	if !refs && !dates {
+		p.r.NormalText(out, data[beg:end])
+		return end
+	}
+
+	mark := beg
+	for i := beg; i < end; i++ {
+		// references and dates must start a word
+		if i > 0 && (isWordChar(data[i-1]) || data[i-1] == '&') {
+			continue
+		}
+		if dates {
+			if size := isoDate(data, i); size > 0 {
+				p.r.NormalText(out, data[mark:i])
+				p.r.Time(out, data[i:i+size])
+				i += size - 1
+				mark = i + 1
+				continue
+			}
+		}
+		if !refs {
+			continue
+		}
+		kind, size := repoReference(data[i:end])
+		if size == 0 || (i+size < len(data) && isWordChar(data[i+size])) {
+			continue
+		}
+
+		p.r.NormalText(out, data[mark:i])
+		if kind == LINK_TYPE_ISSUE {
+			p.r.AutoLink(out, data[i+1:i+size], kind)
+		} else {
+			p.r.AutoLink(out, data[i:i+size], kind)
+		}
+		i += size - 1
+		mark = i + 1
+	}
+	if mark > end {
+		return mark
+	}
+	p.r.NormalText(out, data[mark:end])
+	return end
+}
+
+// Check whether data starts with an issue reference (#123) or a commit hash.
+// Commit hashes are 7 to 40 lowercase hex digits including at least one
+// digit and one letter, so plain numbers and hex-only words do not match.
+// Returns the kind of link and its length, or a zero length if neither.
+func repoReference(data []byte) (kind, size int) {
+	if len(data) > 1 && data[0] == '#' {
+		size = 1
+		for size < len(data) && isdigit(data[size]) {
+			size++
+		}
+		if size == 1 {
+			return 0, 0
+		}
+		return LINK_TYPE_ISSUE, size
+	}
+
+	digits, letters := 0, 0
+	for ; size < len(data) && size <= 40; size++ {
+		if isdigit(data[size]) {
+			digits++
+		} else if data[size] >= 'a' && data[size] <= 'f' {
+			letters++
+		} else {
+			break
+		}
+	}
+	if size < 7 || size > 40 || digits == 0 || letters == 0 {
+		return 0, 0
+	}
+	return LINK_TYPE_COMMIT, size
+}
+
+// Characters that join a date to the numbers around it, as in a version
+// number or a path.
+const dateJoiners = "-.:/+"
+
+// Check whether data[i:] starts with an ISO 8601 date, such as 2024-01-15,
+// or a date and time, such as 2024-01-15T10:30:00Z, that stands on its own
+// rather than being part of a longer run of numbers, like a version number.
+// Returns its length, or zero.
+func isoDate(data []byte, i int) int {
+	if i > 0 && strings.IndexByte(dateJoiners, data[i-1]) >= 0 {
+		return 0
+	}
+	date := data[i:]
+	if len(date) < 10 || date[4] != '-' || date[7] != '-' {
+		return 0
+	}
+	year, month, day := digitsAt(date, 0, 4), digitsAt(date, 5, 2), digitsAt(date, 8, 2)
+	if year < 0 || month < 1 || month > 12 || day < 1 ||
+		day > time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Day() {
+		return 0
+	}
+	size := 10
+	if size < len(date) && date[size] == 'T' {
+		if clock := isoTime(date[size+1:]); clock > 0 {
+			size += 1 + clock
+		}
+	}
+
+	// a date followed by more of a word or number is something else
+	if size < len(date) && (isWordChar(date[size]) ||
+		strings.IndexByte(dateJoiners, date[size]) >= 0 && size+1 < len(date) && isalnum(date[size+1])) {
+		return 0
+	}
+	return size
+}
+
+// Check whether data starts with the time of an ISO 8601 date and time:
+// hours and minutes, optionally seconds and a fraction of a second, and
+// optionally Z or an offset from UTC. Returns its length, or zero.
+func isoTime(data []byte) int {
+	if hour, minute := digitsAt(data, 0, 2), digitsAt(data, 3, 2); hour < 0 || hour > 23 ||
+		minute < 0 || minute > 59 || data[2] != ':' {
+		return 0
+	}
+	size := 5
+	if second := digitsAt(data, 6, 2); second >= 0 && second <= 59 && data[size] == ':' {
+		size = 8
+		if size+1 < len(data) && data[size] == '.' && isdigit(data[size+1]) {
+			size++
+			for size < len(data) && isdigit(data[size]) {
+				size++
+			}
+		}
+	}
+	if size < len(data) && data[size] == 'Z' {
+		size++
+	} else if hours, minutes := digitsAt(data, size+1, 2), digitsAt(data, size+4, 2); hours >= 0 && hours <= 23 &&
+		minutes >= 0 && minutes <= 59 && (data[size] == '+' || data[size] == '-') && data[size+3] == ':' {
+		size += 6
+	}
+	return size
+}
+
+// Return the number written with the n digits at data[at:], or -1 if there
+// are not n digits there.
+func digitsAt(data []byte, at, n int) int {
+	if at+n > len(data) {
+		return -1
+	}
+	number := 0
+	for _, c := range data[at : at+n] {
+		if !isdigit(c) {
+			return -1
+		}
+		number = number*10 + int(c-'0')
+	}
+	return number
+}
+
 // single and double emphasis parsing
 func emphasis(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	if p.flags&EXTENSION_COMMONMARK != 0 && data[offset] != '~' {
+		return flankingEmphasis(p, out, data, offset)
+	}
+	if offset > 0 && !wordBoundary(data[offset-1]) && p.isIntraWordLiteral(data[offset]) {
+		return 0
+	}
 	data = data[offset:]
 	c := data[0]
 	ret := 0
@@ -113,6 +310,38 @@ func codeSpan(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		nb++
 	}
 
+	if p.flags&EXTENSION_COMMONMARK != 0 {
+		// the closing backticks must be as many as the opening ones,
+		// or else the opening ones are text
+		end := nb
+		for {
+			at := bytes.Index(data[end:], data[:nb])
+			if at < 0 {
+				p.r.NormalText(out, data[:nb])
+				return nb
+			}
+			end += at + nb
+			if end == len(data) || data[end] != '`' {
+				break
+			}
+			for end < len(data) && data[end] == '`' {
+				end++
+			}
+		}
+
+		// newlines become spaces, and one space is stripped from each
+		// side if both have one, unless the code is only spaces
+		code := bytes.Replace(data[nb:end-nb], []byte("\n"), []byte(" "), -1)
+		if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' &&
+			len(bytes.TrimLeft(code, " ")) > 0 {
+			code = code[1 : len(code)-1]
+		}
+		if len(code) > 0 {
+			p.r.CodeSpan(out, code)
+		}
+		return end
+	}
+
 	// find the next delimiter
 	i, end := 0, 0
 	for end = nb; end < len(data) && i < nb; end++ {
@@ -258,7 +487,8 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 
 		linkB := i
 
-		// look for link end: ' " )
+		// look for link end: ' " ) or a title in parentheses
+		titleB, titleE, parenTitleEnd := 0, 0, 0
 	findlinkend:
 		for i < len(data) {
 			switch {
@@ -268,6 +498,12 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			case data[i] == ')' || data[i] == '\'' || data[i] == '"':
 				break findlinkend
 
+			case data[i] == '(' && i > linkB && isspace(data[i-1]):
+				if titleB, titleE, parenTitleEnd = linkParenTitle(data, i); parenTitleEnd > 0 {
+					break findlinkend
+				}
+				i++
+
 			default:
 				i++
 			}
@@ -279,8 +515,9 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		linkE := i
 
 		// look for title end if present
-		titleB, titleE := 0, 0
-		if data[i] == '\'' || data[i] == '"' {
+		if parenTitleEnd > 0 {
+			i = parenTitleEnd
+		} else if data[i] == '\'' || data[i] == '"' {
 			i++
 			titleB = i
 
@@ -376,9 +613,8 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			id = data[linkB:linkE]
 		}
 
-		// find the reference with matching id (ids are case-insensitive)
-		key := string(bytes.ToLower(id))
-		lr, ok := p.refs[key]
+		// find the reference with matching id
+		lr, ok := p.refs[referenceKey(id)]
 		if !ok {
 			return 0
 
@@ -415,7 +651,6 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			}
 		}
 
-		key := string(bytes.ToLower(id))
 		if t == linkInlineFootnote {
 			// create a new reference
 			noteId = len(p.notes) + 1
@@ -445,12 +680,13 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			title = ref.title
 		} else {
 			// find the reference with matching id
-			lr, ok := p.refs[key]
+			lr, ok := p.refs[referenceKey(id)]
 			if !ok {
 				return 0
 			}
 
-			if t == linkDeferredFootnote {
+			// a note referred to again keeps its number
+			if t == linkDeferredFootnote && !p.hasNote(lr) {
 				lr.noteId = len(p.notes) + 1
 				p.notes = append(p.notes, lr)
 			}
@@ -466,11 +702,11 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		i = txtE + 1
 	}
 
-	// build content: img alt is escaped, link content is parsed
+	// build content: img alt is flattened to plain text, link content is parsed
 	var content bytes.Buffer
 	if txtE > 1 {
 		if t == linkImg {
-			content.Write(data[1:txtE])
+			content.WriteString(p.plainInline(data[1:txtE]))
 		} else {
 			// links cannot contain other links, so turn off link parsing temporarily
 			insideLink := p.insideLink
@@ -488,12 +724,19 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 			uLink = uLinkBuf.Bytes()
 		}
 
-		// links need something to click on and somewhere to go
-		if len(uLink) == 0 || (t == linkNormal && content.Len() == 0) {
+		// links need something to click on and somewhere to go; the
+		// renderer may still drop all of the content, such as an image
+		if len(uLink) == 0 || (t == linkNormal && txtE <= 1) {
 			return 0
 		}
 	}
 
+	if (t == linkNormal || t == linkImg) && len(title) > 0 {
+		var uTitle bytes.Buffer
+		unescapePunctuation(&uTitle, title)
+		title = uTitle.Bytes()
+	}
+
 	// call the relevant rendering function
 	switch t {
 	case linkNormal:
@@ -527,6 +770,22 @@ func link(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	return i
 }
 
+// Parse data as spans and return its text without markup, as for the alt
+// text of an image, which cannot hold any.
+func (p *parser) plainInline(data []byte) string {
+	renderer, builder := p.r, &nodeBuilder{}
+	p.r = builder
+	var work bytes.Buffer
+	p.inline(&work, data)
+	p.r = renderer
+
+	content := &Node{}
+	for _, child := range builder.children(work.Bytes()) {
+		content.appendChild(child)
+	}
+	return plainText(content)
+}
+
 // '<' when tags or autolinks are allowed
 func leftAngle(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	data = data[offset:]
@@ -549,13 +808,24 @@ func leftAngle(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 }
 
 // '\\' backslash escape
-var escapeChars = []byte("\\`*_{}[]()#+-.!:|&<>")
-
 func escape(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	data = data[offset:]
 
 	if len(data) > 1 {
-		if bytes.IndexByte(escapeChars, data[1]) < 0 {
+		// in CommonMark, a backslash ending a line is a hard line break
+		if data[1] == '\n' && p.flags&EXTENSION_COMMONMARK != 0 {
+			p.r.LineBreak(out)
+			return 2
+		}
+
+		// an escaped space does not break
+		if data[1] == ' ' && p.flags&EXTENSION_SPACE_ESCAPES != 0 {
+			p.r.Entity(out, []byte("&nbsp;"))
+			return 2
+		}
+
+		// any ASCII punctuation can be escaped
+		if !ispunct(data[1]) {
 			return 0
 		}
 
@@ -586,6 +856,19 @@ func unescapeText(ob *bytes.Buffer, src []byte) {
 	}
 }
 
+// Remove the backslashes that escape punctuation, as in link titles.
+func unescapePunctuation(ob *bytes.Buffer, src []byte) {
+	org := 0
+	for i := 0; i+1 < len(src); i++ {
+		if src[i] == '\\' && ispunct(src[i+1]) {
+			ob.Write(src[org:i])
+			org = i + 1
+			i++
+		}
+	}
+	ob.Write(src[org:])
+}
+
 // '&' escaped when it doesn't belong to an entity
 // valid entities are assumed to be anything matching &#?[A-Za-z0-9]+;
 func entity(p *parser, out *bytes.Buffer, data []byte, offset int) int {
@@ -612,6 +895,58 @@ func entity(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	return end
 }
 
+// The markers that open and close each kind of CriticMarkup edit, indexed
+// by kind.
+var criticMarkers = [][2]string{
+	CRITIC_INSERTION:    {"{++", "++}"},
+	CRITIC_DELETION:     {"{--", "--}"},
+	CRITIC_SUBSTITUTION: {"{~~", "~~}"},
+	CRITIC_HIGHLIGHT:    {"{==", "==}"},
+	CRITIC_COMMENT:      {"{>>", "<<}"},
+}
+
+// Check whether data starts with the opening marker of a CriticMarkup edit.
+func isCriticStart(data []byte) bool {
+	for _, markers := range criticMarkers {
+		if bytes.HasPrefix(data, []byte(markers[0])) {
+			return true
+		}
+	}
+	return false
+}
+
+// '{': a CriticMarkup edit, which ends within the paragraph it starts in.
+// In a substitution, ~> separates the text from its replacement.
+func criticMarkup(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	data = data[offset:]
+	for kind, markers := range criticMarkers {
+		if !bytes.HasPrefix(data, []byte(markers[0])) {
+			continue
+		}
+		end := bytes.Index(data[3:], []byte(markers[1]))
+		if end < 0 {
+			return 0
+		}
+		content := data[3 : 3+end]
+
+		var text, replacement bytes.Buffer
+		if kind == CRITIC_SUBSTITUTION {
+			arrow := bytes.Index(content, []byte("~>"))
+			if arrow < 0 {
+				return 0
+			}
+			p.inline(&text, content[:arrow])
+			p.inline(&replacement, content[arrow+2:])
+			p.r.CriticMarkup(out, text.Bytes(), replacement.Bytes(), kind)
+		} else {
+			p.inline(&text, content)
+			p.r.CriticMarkup(out, text.Bytes(), nil, kind)
+		}
+		return 3 + end + 3
+	}
+	return 0
+}
+
 func autoLink(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	// quick check to rule out most false hits on ':'
 	if p.insideLink || len(data) < offset+3 || data[offset+1] != '/' || data[offset+2] != '/' {
@@ -644,15 +979,36 @@ func autoLink(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		linkEnd--
 	}
 
-	// See if the link finishes with a punctuation sign that can be closed.
+	// As in GFM, a trailing ')' is part of the link only if it closes a '('
+	// in the link, so that the link stops before the paren closing the
+	// prose around it.
+	//
+	// Examples:
+	//
+	//      foo http://www.pokemon.com/Pikachu_(Electric) bar
+	//              => http://www.pokemon.com/Pikachu_(Electric)
+	//
+	//      foo (http://www.pokemon.com/Pikachu_(Electric)) bar
+	//              => http://www.pokemon.com/Pikachu_(Electric)
+	//
+	//      foo http://www.pokemon.com/Pikachu_(Electric)) bar
+	//              => http://www.pokemon.com/Pikachu_(Electric)
+	if data[linkEnd-1] == ')' {
+		unmatched := bytes.Count(data[:linkEnd], []byte(")")) - bytes.Count(data[:linkEnd], []byte("("))
+		for unmatched > 0 && data[linkEnd-1] == ')' {
+			linkEnd--
+			unmatched--
+		}
+	}
+
+	// See if the link finishes with another punctuation sign that can be
+	// closed.
 	var copen byte
 	switch data[linkEnd-1] {
 	case '"':
 		copen = '"'
 	case '\'':
 		copen = '\''
-	case ')':
-		copen = '('
 	case ']':
 		copen = '['
 	case '}':
@@ -673,17 +1029,11 @@ func autoLink(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 		 *
 		 * Examples:
 		 *
-		 *      foo http://www.pokemon.com/Pikachu_(Electric) bar
-		 *              => http://www.pokemon.com/Pikachu_(Electric)
-		 *
-		 *      foo (http://www.pokemon.com/Pikachu_(Electric)) bar
-		 *              => http://www.pokemon.com/Pikachu_(Electric)
+		 *      foo http://www.example.com/a[1] bar
+		 *              => http://www.example.com/a[1]
 		 *
-		 *      foo http://www.pokemon.com/Pikachu_(Electric)) bar
-		 *              => http://www.pokemon.com/Pikachu_(Electric))
-		 *
-		 *      (foo http://www.pokemon.com/Pikachu_(Electric)) bar
-		 *              => foo http://www.pokemon.com/Pikachu_(Electric)
+		 *      {foo http://www.example.com/a} bar
+		 *              => http://www.example.com/a
 		 */
 
 		for bufEnd >= 0 && origData[bufEnd] != '\n' && openDelim != 0 {
@@ -732,6 +1082,75 @@ func isSafeLink(link []byte) bool {
 	return false
 }
 
+var safeDataImages = []string{"image/png", "image/gif", "image/jpeg", "image/webp"}
+
+// isDangerousLink reports whether a link would run script when followed:
+// a javascript: or vbscript: link, or a data: link that is not a plain
+// image. The scheme is read the way browsers read it, ignoring case,
+// entities, and any whitespace or control characters.
+func isDangerousLink(link []byte) bool {
+	var url []byte
+	for _, c := range []byte(html.UnescapeString(string(link))) {
+		if c > ' ' && c != 0x7f {
+			url = append(url, c)
+		}
+	}
+	url = bytes.ToLower(url)
+
+	colon := bytes.IndexByte(url, ':')
+	if colon < 0 || bytes.IndexAny(url[:colon], "/?#") >= 0 {
+		// no scheme: a relative link
+		return false
+	}
+	switch string(url[:colon]) {
+	case "javascript", "vbscript":
+		return true
+	case "data":
+		mediaType := url[colon+1:]
+		if end := bytes.IndexAny(mediaType, ";,"); end >= 0 {
+			mediaType = mediaType[:end]
+		}
+		for _, image := range safeDataImages {
+			if string(mediaType) == image {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Find a link title in balanced parentheses starting at data[i], which must
+// be followed by the closing parenthesis of the link. Returns the bounds of
+// the title and the position of that closing parenthesis, or zeroes.
+func linkParenTitle(data []byte, i int) (titleB, titleE, end int) {
+	depth := 0
+	for j := i; j < len(data); j++ {
+		switch data[j] {
+		case '\\':
+			j++
+
+		case '(':
+			depth++
+
+		case ')':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			end = j + 1
+			for end < len(data) && isspace(data[end]) {
+				end++
+			}
+			if end < len(data) && data[end] == ')' {
+				return i + 1, j, end
+			}
+			return 0, 0, 0
+		}
+	}
+	return 0, 0, 0
+}
+
 // return the length of the given tag, or 0 is it's not valid
 func tagLength(data []byte, autolink *int) int {
 	var i, j int
@@ -847,8 +1266,10 @@ func isMailtoAutoLink(data []byte) int {
 }
 
 // look for the next emph char, skipping other constructs
-func helperFindEmphChar(data []byte, c byte) int {
-	i := 1
+// Find the next c in data that may close emphasis, from data[start],
+// skipping code spans and links.
+func helperFindEmphChar(data []byte, c byte, start int) int {
+	i := start
 
 	for i < len(data) {
 		for i < len(data) && data[i] != c && data[i] != '`' && data[i] != '[' {
@@ -905,7 +1326,10 @@ func helperFindEmphChar(data []byte, c byte) int {
 					continue
 				}
 			}
-			cc := data[i]
+			cc := byte(']')
+			if data[i] == '(' {
+				cc = ')'
+			}
 			i++
 			for i < len(data) && data[i] != cc {
 				if tmpI == 0 && data[i] == c {
@@ -922,6 +1346,34 @@ func helperFindEmphChar(data []byte, c byte) int {
 	return 0
 }
 
+// Where helperFindEmphChar starts in data[i:]. data[i] is a delimiter
+// already passed over, except at 0, where it starts the content. In
+// CommonMark a code span or link there binds tighter than the emphasis.
+func (p *parser) emphScanStart(i int) int {
+	if i == 0 && p.flags&EXTENSION_COMMONMARK != 0 {
+		return 0
+	}
+	return 1
+}
+
+// report whether c is an underscore, which with EXTENSION_NO_INTRA_EMPHASIS
+// or in CommonMark does not open emphasis inside a word
+func (p *parser) isIntraWordLiteral(c byte) bool {
+	return c == '_' && p.flags&(EXTENSION_NO_INTRA_EMPHASIS|EXTENSION_COMMONMARK) != 0
+}
+
+// report whether data[i] starts a run of underscores followed by a word,
+// which cannot close emphasis where isIntraWordLiteral holds
+func (p *parser) underscoresBeforeWord(data []byte, i int) bool {
+	if !p.isIntraWordLiteral(data[i]) {
+		return false
+	}
+	for i < len(data) && data[i] == '_' {
+		i++
+	}
+	return i < len(data) && !wordBoundary(data[i])
+}
+
 func helperEmphasis(p *parser, out *bytes.Buffer, data []byte, c byte) int {
 	i := 0
 
@@ -931,7 +1383,7 @@ func helperEmphasis(p *parser, out *bytes.Buffer, data []byte, c byte) int {
 	}
 
 	for i < len(data) {
-		length := helperFindEmphChar(data[i:], c)
+		length := helperFindEmphChar(data[i:], c, p.emphScanStart(i))
 		if length == 0 {
 			return 0
 		}
@@ -947,10 +1399,8 @@ func helperEmphasis(p *parser, out *bytes.Buffer, data []byte, c byte) int {
 
 		if data[i] == c && !isspace(data[i-1]) {
 
-			if p.flags&EXTENSION_NO_INTRA_EMPHASIS != 0 {
-				if !(i+1 == len(data) || isspace(data[i+1]) || ispunct(data[i+1])) {
-					continue
-				}
+			if p.underscoresBeforeWord(data, i) {
+				continue
 			}
 
 			var work bytes.Buffer
@@ -967,13 +1417,14 @@ func helperDoubleEmphasis(p *parser, out *bytes.Buffer, data []byte, c byte) int
 	i := 0
 
 	for i < len(data) {
-		length := helperFindEmphChar(data[i:], c)
+		length := helperFindEmphChar(data[i:], c, p.emphScanStart(i))
 		if length == 0 {
 			return 0
 		}
 		i += length
 
-		if i+1 < len(data) && data[i] == c && data[i+1] == c && i > 0 && !isspace(data[i-1]) {
+		if i+1 < len(data) && data[i] == c && data[i+1] == c && i > 0 && !isspace(data[i-1]) &&
+			!p.underscoresBeforeWord(data, i) {
 			var work bytes.Buffer
 			p.inline(&work, data[:i])
 
@@ -998,7 +1449,7 @@ func helperTripleEmphasis(p *parser, out *bytes.Buffer, data []byte, offset int,
 	data = data[offset:]
 
 	for i < len(data) {
-		length := helperFindEmphChar(data[i:], c)
+		length := helperFindEmphChar(data[i:], c, p.emphScanStart(i))
 		if length == 0 {
 			return 0
 		}
@@ -1008,6 +1459,9 @@ func helperTripleEmphasis(p *parser, out *bytes.Buffer, data []byte, offset int,
 		if data[i] != c || isspace(data[i-1]) {
 			continue
 		}
+		if p.underscoresBeforeWord(data, i) {
+			continue
+		}
 
 		switch {
 		case i+2 < len(data) && data[i+1] == c && data[i+2] == c:
@@ -1039,3 +1493,313 @@ func helperTripleEmphasis(p *parser, out *bytes.Buffer, data []byte, offset int,
 	}
 	return 0
 }
+
+//
+// CommonMark emphasis
+//
+// With EXTENSION_COMMONMARK, * and _ follow the delimiter run rules of the
+// spec: whether a run can open or close emphasis depends on the
+// characters around it, and each closer is matched with the nearest
+// opener before it, so that **foo*bar** and *foo**bar*** nest properly.
+//
+
+// A run of * or _, data[start:end]. data[lo:hi] is what is left of it
+// once matched: an opener is used from its end and a closer from its
+// start.
+type delimRun struct {
+	c                 byte
+	start, end        int
+	lo, hi            int
+	canOpen, canClose bool
+	removed           bool // no longer a possible opener
+}
+
+// A matched opener and closer: the n delimiters at data[open:] start the
+// emphasis and the n at data[close:] end it.
+type emphMatch struct {
+	open, close, n int
+}
+
+// The runs in the text of an inline call from the first one parsed, and
+// their matches in the order of their openers. The inline parser reaches
+// the later runs that are not already rendered, unless it skips them
+// another way, so they are kept for it.
+type emphasisRuns struct {
+	runs    []delimRun
+	matches []emphMatch
+}
+
+// Parse the emphasis started by the run at data[offset], rendering the
+// text up to the end of the last match it opens. A run that opens nothing
+// is text.
+func flankingEmphasis(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	// use the runs found from an earlier run in this text, if this is one
+	found := p.emphasisRuns
+	var i int
+	if found != nil {
+		i = sort.Search(len(found.runs), func(i int) bool { return found.runs[i].end > offset })
+	}
+	if found == nil || i == len(found.runs) || found.runs[i].lo != offset {
+		runs := p.delimRuns(data, offset)
+		found = &emphasisRuns{runs, matchDelimRuns(runs)}
+		p.emphasisRuns = found
+		i = 0
+	}
+	run, matches := found.runs[i], found.matches
+
+	end := run.end
+	m := sort.Search(len(matches), func(m int) bool { return matches[m].open >= offset })
+	for ; m < len(matches) && matches[m].open < run.end; m++ {
+		if stop := matches[m].close + matches[m].n; stop > end {
+			end = stop
+		}
+	}
+	if end == run.end {
+		p.r.NormalText(out, data[offset:end])
+		return end - offset
+	}
+	p.renderEmphasis(out, data, offset, end, found)
+	return end - offset
+}
+
+// Find the runs of * and _ in data from the one at offset, skipping
+// escaped characters, code spans, tags, and links.
+func (p *parser) delimRuns(data []byte, offset int) []delimRun {
+	var runs []delimRun
+	for i := offset; i < len(data); {
+		switch c := data[i]; c {
+		case '*', '_':
+			// the run at offset may be what is left of one partly used
+			// as a closer
+			start, end := i, i
+			for i == offset && start > 0 && data[start-1] == c && (start < 2 || data[start-2] != '\\') {
+				start--
+			}
+			for end < len(data) && data[end] == c {
+				end++
+			}
+			run := delimRun{c: c, start: start, end: end, lo: i, hi: end}
+			run.canOpen, run.canClose = flanking(data, start, end)
+			runs = append(runs, run)
+			i = end
+		case '\\':
+			i += 2
+		case '`':
+			i = codeSpanEnd(data, i)
+		case '<':
+			var kind int
+			if n := tagLength(data[i:], &kind); n > 0 {
+				i += n
+			} else {
+				i++
+			}
+		case '[':
+			i = p.linkEnd(data, i)
+		default:
+			i++
+		}
+	}
+	return runs
+}
+
+// Report whether the run data[start:end] can open and close emphasis:
+// whether it is left- and right-flanking, with the stricter rules for _.
+func flanking(data []byte, start, end int) (canOpen, canClose bool) {
+	before, after := '\n', '\n'
+	if start > 0 {
+		before, _ = utf8.DecodeLastRune(data[:start])
+	}
+	if end < len(data) {
+		after, _ = utf8.DecodeRune(data[end:])
+	}
+	left := !unicode.IsSpace(after) &&
+		(!isPunctRune(after) || unicode.IsSpace(before) || isPunctRune(before))
+	right := !unicode.IsSpace(before) &&
+		(!isPunctRune(before) || unicode.IsSpace(after) || isPunctRune(after))
+	if data[start] == '_' {
+		return left && (!right || isPunctRune(before)), right && (!left || isPunctRune(after))
+	}
+	return left, right
+}
+
+func isPunctRune(r rune) bool {
+	return (r < utf8.RuneSelf && ispunct(byte(r))) || unicode.IsPunct(r)
+}
+
+// Match closers with openers as in the "process emphasis" procedure of
+// the spec, and return the matches in the order of their openers.
+func matchDelimRuns(runs []delimRun) []emphMatch {
+	var matches []emphMatch
+
+	// where the search for an opener stops, by the character and size of
+	// the closer and whether it can open, since one that failed once will
+	// fail again
+	var bottom [12]int
+
+	for ci := range runs {
+		closer := &runs[ci]
+		if !closer.canClose {
+			continue
+		}
+		key := (closer.end - closer.start) % 3 * 2
+		if closer.c == '_' {
+			key += 6
+		}
+		if closer.canOpen {
+			key++
+		}
+
+		for closer.lo < closer.hi {
+			oi := ci - 1
+			for ; oi >= bottom[key]; oi-- {
+				opener := &runs[oi]
+				if opener.removed || opener.lo == opener.hi || opener.c != closer.c || !opener.canOpen {
+					continue
+				}
+				// the rule of 3
+				sizes := [2]int{opener.end - opener.start, closer.end - closer.start}
+				if (opener.canClose || closer.canOpen) && (sizes[0]+sizes[1])%3 == 0 &&
+					(sizes[0]%3 != 0 || sizes[1]%3 != 0) {
+					continue
+				}
+				break
+			}
+			if oi < bottom[key] {
+				bottom[key] = ci
+				break
+			}
+
+			opener := &runs[oi]
+			n := 1
+			if opener.hi-opener.lo >= 2 && closer.hi-closer.lo >= 2 {
+				n = 2
+			}
+			opener.hi -= n
+			matches = append(matches, emphMatch{opener.hi, closer.lo, n})
+			closer.lo += n
+			for k := oi + 1; k < ci; k++ {
+				runs[k].removed = true
+			}
+		}
+		if !closer.canOpen {
+			closer.removed = true
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].open < matches[j].open
+	})
+	return matches
+}
+
+// Render data[from:to] with the matches within it as emphasis.
+func (p *parser) renderEmphasis(out *bytes.Buffer, data []byte, from, to int, found *emphasisRuns) {
+	if p.nesting >= p.maxNesting {
+		p.r.NormalText(out, data[from:to])
+		return
+	}
+	p.nesting++
+
+	matches := found.matches
+	m := sort.Search(len(matches), func(m int) bool { return matches[m].open >= from })
+	for ; m < len(matches) && matches[m].open < to; m++ {
+		match := matches[m]
+		if match.open < from {
+			// nested in the last one
+			continue
+		}
+		p.emphasisText(out, data, from, match.open, found.runs)
+		var work bytes.Buffer
+		p.renderEmphasis(&work, data, match.open+match.n, match.close, found)
+		if match.n == 2 {
+			p.r.DoubleEmphasis(out, work.Bytes())
+		} else {
+			p.r.Emphasis(out, work.Bytes())
+		}
+		from = match.close + match.n
+	}
+	p.emphasisText(out, data, from, to, found.runs)
+
+	p.nesting--
+}
+
+// Render data[from:to], which has no matched delimiters, keeping the
+// unmatched ones as text.
+func (p *parser) emphasisText(out *bytes.Buffer, data []byte, from, to int, runs []delimRun) {
+	r := sort.Search(len(runs), func(r int) bool { return runs[r].end > from })
+	for ; r < len(runs) && runs[r].lo < to; r++ {
+		run := runs[r]
+		if run.lo == run.hi || run.lo < from {
+			continue
+		}
+		p.inline(out, data[from:run.lo])
+		p.r.NormalText(out, data[run.lo:run.hi])
+		from = run.hi
+	}
+	p.inline(out, data[from:to])
+}
+
+// Return where the code span at data[i] ends, or, if the backticks there
+// do not start one, where they end.
+func codeSpanEnd(data []byte, i int) int {
+	n := 0
+	for i+n < len(data) && data[i+n] == '`' {
+		n++
+	}
+	for j := i + n; j < len(data); {
+		if data[j] != '`' {
+			j++
+			continue
+		}
+		m := 0
+		for j+m < len(data) && data[j+m] == '`' {
+			m++
+		}
+		if m == n {
+			return j + m
+		}
+		j += m
+	}
+	return i + n
+}
+
+// Return where the link or image whose text starts at data[i] ends, if it
+// looks like one, or else i+1.
+func (p *parser) linkEnd(data []byte, i int) int {
+	end := bracketEnd(data, i, '[', ']')
+	switch {
+	case end < 0:
+	case end < len(data) && data[end] == '(':
+		if e := bracketEnd(data, end, '(', ')'); e > 0 {
+			return e
+		}
+	case end < len(data) && data[end] == '[':
+		if e := bracketEnd(data, end, '[', ']'); e > 0 {
+			return e
+		}
+	default:
+		if _, ok := p.refs[referenceKey(data[i+1:end-1])]; ok {
+			return end
+		}
+	}
+	return i + 1
+}
+
+// Return the index after the bracket that closes the one at data[i], or
+// -1 if there is none.
+func bracketEnd(data []byte, i int, opening, closing byte) int {
+	depth := 0
+	for ; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case opening:
+			depth++
+		case closing:
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
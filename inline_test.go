@@ -14,6 +14,7 @@
 package blackfriday
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -69,6 +70,30 @@ func doTestsInlineParam(t *testing.T, tests []string, extensions, htmlFlags int)
 	}
 }
 
+func doTestsInlineRenderer(t *testing.T, tests []string, extensions int, renderer func() Renderer) {
+	extensions |= EXTENSION_AUTOLINK
+	extensions |= EXTENSION_STRIKETHROUGH
+
+	for i := 0; i+1 < len(tests); i += 2 {
+		input := tests[i]
+		expected := tests[i+1]
+		actual := string(Markdown([]byte(input), renderer(), extensions))
+		if actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				input, expected, actual)
+		}
+
+		// now test every substring to stress test bounds checking
+		if !testing.Short() {
+			for start := 0; start < len(input); start++ {
+				for end := start + 1; end <= len(input); end++ {
+					_ = Markdown([]byte(input[start:end]), renderer(), extensions)
+				}
+			}
+		}
+	}
+}
+
 func TestRawHtmlTag(t *testing.T) {
 	tests := []string{
 		"zz <style>p {}</style>\n",
@@ -429,6 +454,36 @@ func TestNofollowLink(t *testing.T) {
 	doTestsInlineParam(t, tests, 0, HTML_SAFELINK|HTML_NOFOLLOW_LINKS)
 }
 
+func TestLinkRel(t *testing.T) {
+	renderer := func(flags int, rel string) func() Renderer {
+		return func() Renderer {
+			r := HtmlRenderer(flags, "", "").(*Html)
+			r.SetLinkRel(rel)
+			return r
+		}
+	}
+
+	var tests = []string{
+		"[foo](/bar/) and <http://example.com/>\n",
+		"<p><a href=\"/bar/\" rel=\"noopener\">foo</a> and " +
+			"<a href=\"http://example.com/\" rel=\"noopener\">http://example.com/</a></p>\n",
+	}
+	doTestsInlineRenderer(t, tests, 0, renderer(0, "noopener"))
+
+	tests = []string{
+		"[foo](/bar/) and <http://example.com/>\n",
+		"<p><a href=\"/bar/\" rel=\"nofollow noopener\">foo</a> and " +
+			"<a href=\"http://example.com/\" rel=\"nofollow noopener\">http://example.com/</a></p>\n",
+	}
+	doTestsInlineRenderer(t, tests, 0, renderer(HTML_NOFOLLOW_LINKS, " noopener  nofollow noopener"))
+
+	tests = []string{
+		"[foo](/bar/)\n",
+		"<p><a href=\"/bar/\">foo</a></p>\n",
+	}
+	doTestsInlineRenderer(t, tests, 0, renderer(0, ""))
+}
+
 func TestSafeInlineLink(t *testing.T) {
 	var tests = []string{
 		"[foo](/bar/)\n",
@@ -453,6 +508,430 @@ func TestSafeInlineLink(t *testing.T) {
 	doSafeTestsInline(t, tests)
 }
 
+func TestSafeSchemes(t *testing.T) {
+	renderer := func() Renderer {
+		r := HtmlRenderer(HTML_SAFELINK, "", "").(*Html)
+		r.SetSafeSchemes([]string{"tel", "https", "steam"})
+		return r
+	}
+
+	var tests = []string{
+		"[foo](tel:+15550100)\n",
+		"<p><a href=\"tel:+15550100\">foo</a></p>\n",
+
+		"[foo](STEAM://run/440)\n",
+		"<p><a href=\"STEAM://run/440\">foo</a></p>\n",
+
+		"<steam://run/440>\n",
+		"<p><a href=\"steam://run/440\">steam://run/440</a></p>\n",
+
+		"[foo](https://bar/)\n",
+		"<p><a href=\"https://bar/\">foo</a></p>\n",
+
+		"[foo](/bar/)\n",
+		"<p><a href=\"/bar/\">foo</a></p>\n",
+
+		// Not in the list
+		"[foo](http://bar/)\n",
+		"<p><tt>foo</tt></p>\n",
+
+		"[foo](sms:+15550100)\n",
+		"<p><tt>foo</tt></p>\n",
+
+		"<baz://bar/>\n",
+		"<p><tt>baz://bar/</tt></p>\n",
+
+		"[foo](tel:)\n",
+		"<p><tt>foo</tt></p>\n",
+	}
+	doTestsInlineRenderer(t, tests, 0, renderer)
+}
+
+func TestUnsafeLinkHandler(t *testing.T) {
+	renderer := func() Renderer {
+		r := HtmlRenderer(HTML_SAFELINK, "", "").(*Html)
+		r.SetUnsafeLinkHandler(func(link, content []byte) []byte {
+			return []byte("<span class=\"unsafe\" title=\"" + string(link) + "\">" + string(content) + "</span>")
+		})
+		return r
+	}
+
+	var tests = []string{
+		"[foo *bar*](baz://x/)\n",
+		"<p><span class=\"unsafe\" title=\"baz://x/\">foo <em>bar</em></span></p>\n",
+
+		"<baz://x/?a&b>\n",
+		"<p><span class=\"unsafe\" title=\"baz://x/?a&b\">baz://x/?a&amp;b</span></p>\n",
+
+		"![a & b](baz://x.png)\n",
+		"<p><span class=\"unsafe\" title=\"baz://x.png\">a &amp; b</span></p>\n",
+
+		// safe links are left alone
+		"[foo](http://bar/) ![a](http://bar/x.png) <http://bar/>\n",
+		"<p><a href=\"http://bar/\">foo</a> <img src=\"http://bar/x.png\" alt=\"a\">\n " +
+			"<a href=\"http://bar/\">http://bar/</a></p>\n",
+	}
+	doTestsInlineRenderer(t, tests, 0, renderer)
+
+	// without a handler, images are not checked
+	tests = []string{
+		"[foo](baz://x/) ![a](baz://x.png)\n",
+		"<p><tt>foo</tt> <img src=\"baz://x.png\" alt=\"a\" />\n</p>\n",
+	}
+	doSafeTestsInline(t, tests)
+}
+
+func TestDangerousLink(t *testing.T) {
+	var tests = []string{
+		"[foo](javascript:alert)\n",
+		"<p>foo</p>\n",
+
+		"[foo](JaVaScRiPt:alert)\n",
+		"<p>foo</p>\n",
+
+		"[foo](java\tscript:alert)\n",
+		"<p>foo</p>\n",
+
+		"[foo](java\x01script:alert)\n",
+		"<p>foo</p>\n",
+
+		"[foo](&#106;avascript:alert)\n",
+		"<p>foo</p>\n",
+
+		"[*foo*](vbscript:msgbox)\n",
+		"<p><em>foo</em></p>\n",
+
+		"[foo](data:text/html;base64,PHNjcmlwdD4=)\n",
+		"<p>foo</p>\n",
+
+		"<javascript:alert>\n",
+		"<p>javascript:alert</p>\n",
+
+		"![foo](javascript:alert)\n",
+		"<p></p>\n",
+
+		"![foo](data:image/svg+xml;base64,PHN2Zz4=)\n",
+		"<p></p>\n",
+
+		// Not considered dangerous
+		"![foo](data:image/png;base64,iVBORw0K)\n",
+		"<p><img src=\"data:image/png;base64,iVBORw0K\" alt=\"foo\" />\n</p>\n",
+
+		"[foo](/javascript:alert)\n",
+		"<p><a href=\"/javascript:alert\">foo</a></p>\n",
+
+		"[foo](baz://bar/)\n",
+		"<p><a href=\"baz://bar/\">foo</a></p>\n",
+
+		"[foo](mailto:bar@example.com)\n",
+		"<p><a href=\"mailto:bar@example.com\">foo</a></p>\n",
+	}
+	doTestsInlineParam(t, tests, 0, HTML_SKIP_DANGEROUS_LINKS)
+}
+
+func TestSmartypantsEllipsis(t *testing.T) {
+	var tests = []string{
+		"wait... for it. . . now....\n",
+		"<p>wait&hellip; for it&hellip; now&hellip;.</p>\n",
+	}
+	doTestsInlineParam(t, tests, 0, HTML_USE_SMARTYPANTS)
+
+	tests = []string{
+		"wait... for it. . . now.... ...\n",
+		"<p>wait&hellip; for it. . . now.... &hellip;</p>\n",
+
+		"`...` and ...\n",
+		"<p><code>...</code> and &hellip;</p>\n",
+	}
+	doTestsInlineParam(t, tests, 0, HTML_USE_SMARTYPANTS|HTML_SMARTYPANTS_STRICT_ELLIPSIS)
+
+	tests = []string{
+		"wait... for it ... now. . .\n",
+		"<p>wait&#8239;&hellip; for it &hellip; now&#8239;&hellip;</p>\n",
+	}
+	doTestsInlineParam(t, tests, 0, HTML_USE_SMARTYPANTS|HTML_SMARTYPANTS_ELLIPSIS_NNBSP)
+
+	tests = []string{
+		"wait... now.... (...)\n",
+		"<p>wait&#8239;&hellip; now.... (&hellip;)</p>\n",
+	}
+	doTestsInlineParam(t, tests, 0,
+		HTML_USE_SMARTYPANTS|HTML_SMARTYPANTS_STRICT_ELLIPSIS|HTML_SMARTYPANTS_ELLIPSIS_NNBSP)
+}
+
+func TestSmartypantsLeadingApostrophe(t *testing.T) {
+	var tests = []string{
+		"'Tis the '90s, rock 'n' roll 'til '69.\n",
+		"<p>&rsquo;Tis the &rsquo;90s, rock &rsquo;n&rsquo; roll &rsquo;til &rsquo;69.</p>\n",
+
+		"give 'em 'cause\n",
+		"<p>give &rsquo;em &rsquo;cause</p>\n",
+
+		// quotes stay quotes
+		"'tissue' and '1984'\n",
+		"<p>&lsquo;tissue&rsquo; and &lsquo;1984&rsquo;</p>\n",
+	}
+	doTestsInlineParam(t, tests, 0, HTML_USE_SMARTYPANTS)
+}
+
+func TestMarkdownInline(t *testing.T) {
+	var tests = []string{
+		"*Hello* [world](/w) `code`\n",
+		"<em>Hello</em> <a href=\"/w\">world</a> <code>code</code>",
+
+		"# not a header\n",
+		"# not a header",
+
+		"  two\nlines  \n\n",
+		"two\nlines",
+
+		"",
+		"",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		actual := string(MarkdownInline([]byte(tests[i]), HtmlRenderer(0, "", ""), 0))
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", tests[i], tests[i+1], actual)
+		}
+	}
+
+	// renderers that finish their output in DocumentFooter finish it here
+	printer := MarkdownRenderer(0).(*MarkdownPrinter)
+	printer.SetWrapWidth(10)
+	ansi := AnsiRenderer(20).(*Ansi)
+	ansi.SetNumberedLinks(true)
+	var finished = []struct {
+		renderer Renderer
+		expected string
+	}{
+		{printer, "see\n[a link](http://x.com)\nand more\nwords"},
+		{ansi, "see \x1b[4ma link\x1b[24m[1] and\nmore words\n" +
+			"────────────────────\n[1] http://x.com\n"},
+	}
+	input := "see [a link](http://x.com) and more words"
+	for _, test := range finished {
+		if actual := string(MarkdownInline([]byte(input), test.renderer, 0)); actual != test.expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]", input, test.expected, actual)
+		}
+	}
+}
+
+func TestSmartypantsSkipCodeLike(t *testing.T) {
+	var tests = []string{
+		"Pass --verbose, not -- this.\n",
+		"<p>Pass --verbose, not &mdash; this.</p>\n",
+
+		"\"v1.0\"--\"x\" and ('main.go'), it's ./run--old.sh\n",
+		"<p>&ldquo;v1.0&rdquo;&mdash;&ldquo;x&rdquo; and (&lsquo;main.go&rsquo;), it&rsquo;s ./run--old.sh</p>\n",
+
+		"a/b--c, key=x--y, and 1/2--3/4...\n",
+		"<p>a/b--c, key=x--y, and &frac12;&mdash;&frac34;&hellip;</p>\n",
+	}
+	doTestsInlineParam(t, tests, 0, HTML_USE_SMARTYPANTS|HTML_SMARTYPANTS_SKIP_CODE_LIKE)
+
+	tests = []string{
+		"Pass --verbose to ./run--old.sh\n",
+		"<p>Pass &mdash;verbose to ./run&mdash;old.sh</p>\n",
+	}
+	doTestsInlineParam(t, tests, 0, HTML_USE_SMARTYPANTS)
+}
+
+func TestSmartypantsSelective(t *testing.T) {
+	input := "\"It's\" -- 1/2 of 3/8... (c)\n"
+	var tests = []struct {
+		flags    int
+		expected string
+	}{
+		{HTML_USE_SMARTYPANTS,
+			"<p>&ldquo;It&rsquo;s&rdquo; &mdash; &frac12; of 3/8&hellip; &copy;</p>\n"},
+		{HTML_SMARTYPANTS_QUOTES,
+			"<p>&ldquo;It&rsquo;s&rdquo; -- 1/2 of 3/8... (c)</p>\n"},
+		{HTML_SMARTYPANTS_DASHES,
+			"<p>&quot;It's&quot; &mdash; 1/2 of 3/8... (c)</p>\n"},
+		{HTML_SMARTYPANTS_DASHES | HTML_SMARTYPANTS_LATEX_DASHES,
+			"<p>&quot;It's&quot; &ndash; 1/2 of 3/8... (c)</p>\n"},
+		{HTML_SMARTYPANTS_ELLIPSIS,
+			"<p>&quot;It's&quot; -- 1/2 of 3/8&hellip; (c)</p>\n"},
+		{HTML_SMARTYPANTS_FRACTIONS,
+			"<p>&quot;It's&quot; -- <sup>1</sup>&frasl;<sub>2</sub> of <sup>3</sup>&frasl;<sub>8</sub>... (c)</p>\n"},
+		{HTML_SMARTYPANTS_QUOTES | HTML_SMARTYPANTS_ELLIPSIS,
+			"<p>&ldquo;It&rsquo;s&rdquo; -- 1/2 of 3/8&hellip; (c)</p>\n"},
+
+		// modifiers do nothing without the substitution they change
+		{HTML_SMARTYPANTS_LATEX_DASHES | HTML_SMARTYPANTS_STRICT_ELLIPSIS,
+			"<p>&quot;It's&quot; -- 1/2 of 3/8... (c)</p>\n"},
+	}
+	for _, test := range tests {
+		actual := runMarkdownInline(input, 0, test.flags)
+		if actual != test.expected {
+			t.Errorf("\nFlags   [%#x]\nExpected[%#v]\nActual  [%#v]", test.flags, test.expected, actual)
+		}
+	}
+}
+
+func TestSmartypantsTimesAndArrows(t *testing.T) {
+	var tests = []string{
+		"4x4 and 1920x1080 at 2x\n",
+		"<p>4&times;4 and 1920&times;1080 at 2x</p>\n",
+
+		"4 x 4, 4x4px, 0x10, 0X1F, 10x10, and box\n",
+		"<p>4 x 4, 4x4px, 0x10, 0X1F, 10&times;10, and box</p>\n",
+
+		"a -> b <- c\n",
+		"<p>a &ndash;&gt; b &lt;&ndash; c</p>\n",
+	}
+	doTestsInlineParam(t, tests, 0, HTML_USE_SMARTYPANTS|HTML_SMARTYPANTS_TIMES)
+
+	tests = []string{
+		"a -> b <- c\n",
+		"<p>a &rarr; b &larr; c</p>\n",
+
+		"-> start and end <-\n",
+		"<p>&rarr; start and end &larr;</p>\n",
+
+		"a->b, <--, -->, and a - b & c\n",
+		"<p>a-&gt;b, &lt;&mdash;, &mdash;&gt;, and a &ndash; b &amp; c</p>\n",
+
+		"4x4\n",
+		"<p>4x4</p>\n",
+	}
+	doTestsInlineParam(t, tests, 0, HTML_USE_SMARTYPANTS|HTML_SMARTYPANTS_ARROWS)
+}
+
+func TestLinkTitleDelimiters(t *testing.T) {
+	var tests = []string{
+		"[a](/url \"title\")\n",
+		"<p><a href=\"/url\" title=\"title\">a</a></p>\n",
+
+		"[a](/url 'title')\n",
+		"<p><a href=\"/url\" title=\"title\">a</a></p>\n",
+
+		"[a](/url (title))\n",
+		"<p><a href=\"/url\" title=\"title\">a</a></p>\n",
+
+		"[a](/url (a (nested) title) )\n",
+		"<p><a href=\"/url\" title=\"a (nested) title\">a</a></p>\n",
+
+		"[a](/url (an \\) escaped paren))\n",
+		"<p><a href=\"/url\" title=\"an ) escaped paren\">a</a></p>\n",
+
+		"[a](/url 'it\\'s')\n",
+		"<p><a href=\"/url\" title=\"it's\">a</a></p>\n",
+
+		"[a](/url \"say \\\"hi\\\"\")\n",
+		"<p><a href=\"/url\" title=\"say &quot;hi&quot;\">a</a></p>\n",
+
+		"[a](/url 'C:\\dir')\n",
+		"<p><a href=\"/url\" title=\"C:\\dir\">a</a></p>\n",
+
+		"![a](/img.png (title))\n",
+		"<p><img src=\"/img.png\" alt=\"a\" title=\"title\" />\n</p>\n",
+
+		// not a title without a space before it
+		"[a](/wiki(b))\n",
+		"<p><a href=\"/wiki(b\">a</a>)</p>\n",
+
+		"[a][1] [b][2] [c][3]\n\n[1]: /one \"one\"\n[2]: /two 'two'\n[3]: /three (three)\n",
+		"<p><a href=\"/one\" title=\"one\">a</a> <a href=\"/two\" title=\"two\">b</a> " +
+			"<a href=\"/three\" title=\"three\">c</a></p>\n",
+
+		"[a][1]\n\n[1]: /one 'it\\'s'\n",
+		"<p><a href=\"/one\" title=\"it's\">a</a></p>\n",
+	}
+	doTestsInline(t, tests)
+}
+
+func TestBackslashEscapes(t *testing.T) {
+	var tests []string
+	for _, c := range "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~" {
+		expected := map[rune]string{'"': "&quot;", '&': "&amp;", '<': "&lt;", '>': "&gt;"}[c]
+		if expected == "" {
+			expected = string(c)
+		}
+		tests = append(tests,
+			"a\\"+string(c)+"b\n",
+			"<p>a"+expected+"b</p>\n")
+	}
+	tests = append(tests,
+		"a\\qb \\1\n",
+		"<p>a\\qb \\1</p>\n",
+
+		"\\*not emphasis\\* and \\$5\n",
+		"<p>*not emphasis* and $5</p>\n",
+	)
+	doTestsInline(t, tests)
+
+	tests = []string{
+		"| a \\| b | c |\n|---|---|\n| \\$ | \\| |\n",
+		"<table>\n<thead>\n<tr>\n<th>a | b</th>\n<th>c</th>\n</tr>\n</thead>\n\n" +
+			"<tbody>\n<tr>\n<td>$</td>\n<td>|</td>\n</tr>\n</tbody>\n</table>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_TABLES, 0)
+}
+
+func TestSpaceEscapes(t *testing.T) {
+	var tests = []string{
+		"10\\ km and Mr.\\ Smith\n",
+		"<p>10&nbsp;km and Mr.&nbsp;Smith</p>\n",
+
+		"*a\\ b* \\  c\n",
+		"<p><em>a&nbsp;b</em> &nbsp; c</p>\n",
+
+		"`a\\ b` a\\\\ b\n",
+		"<p><code>a\\ b</code> a\\ b</p>\n",
+
+		// soft hyphens are written as entities
+		"super&shy;cali&#173;fragilistic\n",
+		"<p>super&shy;cali&#173;fragilistic</p>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_SPACE_ESCAPES, 0)
+
+	tests = []string{
+		"10\\ km\n",
+		"<p>10\\ km</p>\n",
+	}
+	doTestsInline(t, tests)
+}
+
+func TestPresentationalTags(t *testing.T) {
+	var tests = []string{
+		"*a* **b** ***c*** ~~d~~\n",
+		"<p><i>a</i> <b>b</b> <b><i>c</i></b> <s>d</s></p>\n",
+
+		"**a *b* c**\n",
+		"<p><b>a <i>b</i> c</b></p>\n",
+	}
+	doTestsInlineParam(t, tests, 0, HTML_PRESENTATIONAL_TAGS)
+
+	// semantic by default
+	tests = []string{
+		"*a* **b** ***c*** ~~d~~\n",
+		"<p><em>a</em> <strong>b</strong> <strong><em>c</em></strong> <del>d</del></p>\n",
+	}
+	doTestsInlineParam(t, tests, 0, 0)
+}
+
+func TestLinkedImage(t *testing.T) {
+	var tests = []string{
+		"a [![alt](/img.png)](/target) b\n",
+		"<p>a <a href=\"/target\"><img src=\"/img.png\" alt=\"alt\" />\n</a> b</p>\n",
+	}
+	doTestsInline(t, tests)
+
+	// a link left with nothing to click on is dropped
+	tests = []string{
+		"a [![alt](/img.png)](/target) b\n",
+		"<p>a  b</p>\n",
+
+		"a [x ![alt](/img.png)](/target) b\n",
+		"<p>a <a href=\"/target\">x </a> b</p>\n",
+
+		"a [](/target) b\n",
+		"<p>a [](/target) b</p>\n",
+	}
+	doTestsInlineParam(t, tests, 0, HTML_SKIP_IMAGES)
+}
+
 func TestReferenceLink(t *testing.T) {
 	var tests = []string{
 		"[link][ref]\n",
@@ -485,6 +964,29 @@ func TestReferenceLink(t *testing.T) {
 	doTestsInline(t, tests)
 }
 
+func TestReferenceLabels(t *testing.T) {
+	var tests = []string{
+		"[foo] and [Foo][] and [x][FOO]\n\n[FOO]: /url\n",
+		"<p><a href=\"/url\">foo</a> and <a href=\"/url\">Foo</a> and <a href=\"/url\">x</a></p>\n",
+
+		"[ÄÖ Label]\n\n[äö label]: /url\n",
+		"<p><a href=\"/url\">ÄÖ Label</a></p>\n",
+
+		"[x][Foo   Bar] and [Foo\nbar]\n\n[ foo\tbar ]: /url\n",
+		"<p><a href=\"/url\">x</a> and <a href=\"/url\">Foo\nbar</a></p>\n",
+
+		"[Baz][Foo bar]\n\n[Foo\n  bar]: /url\n",
+		"<p><a href=\"/url\">Baz</a></p>\n",
+
+		"[Baz][Foo bar]\n\n[Foo\n\nbar]: /url\n",
+		"<p>[Baz][Foo bar]</p>\n\n<p>[Foo</p>\n\n<p>bar]: /url</p>\n",
+
+		"![Logo][IMG ref]\n\n[img  REF]: /logo.png\n",
+		"<p><img src=\"/logo.png\" alt=\"Logo\" />\n</p>\n",
+	}
+	doTestsInline(t, tests)
+}
+
 func TestTags(t *testing.T) {
 	var tests = []string{
 		"a <span>tag</span>\n",
@@ -581,6 +1083,28 @@ func TestAutoLink(t *testing.T) {
 		"even a > can be escaped <http://new.com?q=\\>&etc>\n",
 		"<p>even a &gt; can be escaped <a href=\"http://new.com?q=&gt;&amp;etc\">" +
 			"http://new.com?q=&gt;&amp;etc</a></p>\n",
+
+		"(see https://en.wikipedia.org/wiki/Foo_(bar))\n",
+		"<p>(see <a href=\"https://en.wikipedia.org/wiki/Foo_(bar)\">" +
+			"https://en.wikipedia.org/wiki/Foo_(bar)</a>)</p>\n",
+
+		"see https://en.wikipedia.org/wiki/Foo_(bar)\n",
+		"<p>see <a href=\"https://en.wikipedia.org/wiki/Foo_(bar)\">" +
+			"https://en.wikipedia.org/wiki/Foo_(bar)</a></p>\n",
+
+		"see https://en.wikipedia.org/wiki/Foo_(bar)) here\n",
+		"<p>see <a href=\"https://en.wikipedia.org/wiki/Foo_(bar)\">" +
+			"https://en.wikipedia.org/wiki/Foo_(bar)</a>) here</p>\n",
+
+		"(see https://en.wikipedia.org/wiki/Foo_(bar)).\n",
+		"<p>(see <a href=\"https://en.wikipedia.org/wiki/Foo_(bar)\">" +
+			"https://en.wikipedia.org/wiki/Foo_(bar)</a>).</p>\n",
+
+		"(see http://foo.com/a)\n",
+		"<p>(see <a href=\"http://foo.com/a\">http://foo.com/a</a>)</p>\n",
+
+		"http://foo.com/(a)(b)\n",
+		"<p><a href=\"http://foo.com/(a)(b)\">http://foo.com/(a)(b)</a></p>\n",
 	}
 	doTestsInline(t, tests)
 }
@@ -719,7 +1243,421 @@ what happens here
 
 		"empty footnote[^]\n\n[^]: fn text",
 		"<p>empty footnote<sup class=\"footnote-ref\" id=\"fnref:\"><a rel=\"footnote\" href=\"#fn:\">1</a></sup></p>\n<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:\">fn text\n</li>\n</ol>\n</div>\n",
+
+		// tabs indent a list in a footnote as deeply as their columns
+		"note[^t]\n\n[^t]: text\n\n\t* a\n\t\t* b\n\t \t* c\n",
+		"<p>note<sup class=\"footnote-ref\" id=\"fnref:t\"><a rel=\"footnote\" href=\"#fn:t\">1</a></sup></p>\n<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n" +
+			"<li id=\"fn:t\"><p>text</p>\n\n<ul>\n<li>a\n\n<ul>\n<li>b</li>\n<li>c</li>\n</ul></li>\n</ul>\n</li>\n</ol>\n</div>\n",
 	}
 
 	doTestsInlineParam(t, tests, EXTENSION_FOOTNOTES, 0)
 }
+
+func TestFootnoteBlocks(t *testing.T) {
+	var tests = []string{
+		"Text[^1].\n\n[^1]: First paragraph.\n\n    Second paragraph,\n    on two lines.\n\nAfter.\n",
+		"<p>Text<sup class=\"footnote-ref\" id=\"fnref:1\"><a rel=\"footnote\" href=\"#fn:1\">1</a></sup>.</p>\n\n<p>After.</p>\n" +
+			"<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:1\"><p>First paragraph.</p>\n\n" +
+			"<p>Second paragraph,\non two lines.</p>\n</li>\n</ol>\n</div>\n",
+
+		"Text[^1].\n\n[^1]: Run:\n\n        make all\n\n    Or:\n\n    ```sh\n    make test\n    ```\n",
+		"<p>Text<sup class=\"footnote-ref\" id=\"fnref:1\"><a rel=\"footnote\" href=\"#fn:1\">1</a></sup>.</p>\n" +
+			"<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:1\"><p>Run:</p>\n\n" +
+			"<pre><code>make all\n</code></pre>\n\n<p>Or:</p>\n\n<pre><code class=\"sh\">make test\n</code></pre>\n</li>\n</ol>\n</div>\n",
+
+		"Text[^1].\n\n[^1]: Steps:\n\n    1. one\n    2. two\n        * detail\n\n    Done.\n",
+		"<p>Text<sup class=\"footnote-ref\" id=\"fnref:1\"><a rel=\"footnote\" href=\"#fn:1\">1</a></sup>.</p>\n" +
+			"<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:1\"><p>Steps:</p>\n\n" +
+			"<ol>\n<li>one</li>\n<li>two\n\n<ul>\n<li>detail</li>\n</ul></li>\n</ol>\n\n<p>Done.</p>\n</li>\n</ol>\n</div>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_FOOTNOTES|EXTENSION_FENCED_CODE, 0)
+}
+
+func TestCriticMarkup(t *testing.T) {
+	var tests = []string{
+		"a {++*new*++} b {--old--}\n",
+		"<p>a <ins><em>new</em></ins> b <del>old</del></p>\n",
+
+		"{~~this~>**that**~~} {==look==}{>>a note<<}\n",
+		"<p><del>this</del><ins><strong>that</strong></ins> <mark>look</mark><span class=\"critic-comment\">a note</span></p>\n",
+
+		// unclosed, or a substitution without ~>
+		"{++open\n",
+		"<p>{++open</p>\n",
+
+		"{~~no arrow~~}\n",
+		"<p>{<del>no arrow</del>}</p>\n",
+
+		// edits end in the paragraph they start in
+		"{++a\n\nb++}\n",
+		"<p>{++a</p>\n\n<p>b++}</p>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_CRITIC_MARKUP, 0)
+}
+
+func TestRepoReferences(t *testing.T) {
+	var tests = []string{
+		"fixes #123\n",
+		"<p>fixes <a href=\"https://example.com/issues/123\">#123</a></p>\n",
+
+		"see #1, #22 and (#333).\n",
+		"<p>see <a href=\"https://example.com/issues/1\">#1</a>, " +
+			"<a href=\"https://example.com/issues/22\">#22</a> and " +
+			"(<a href=\"https://example.com/issues/333\">#333</a>).</p>\n",
+
+		"see commit 3f786850e387550fdab836ed7e6dc881de23001b\n",
+		"<p>see commit <a href=\"https://example.com/commit/3f786850e387550fdab836ed7e6dc881de23001b\">" +
+			"3f786850e387550fdab836ed7e6dc881de23001b</a></p>\n",
+
+		"short hash a94a8fe.\n",
+		"<p>short hash <a href=\"https://example.com/commit/a94a8fe\">a94a8fe</a>.</p>\n",
+
+		"*a94a8fe*\n",
+		"<p><em><a href=\"https://example.com/commit/a94a8fe\">a94a8fe</a></em></p>\n",
+
+		// hashtags and words
+		"tags #golang #1st issue#12 &#12\n",
+		"<p>tags #golang #1st issue#12 &amp;#12</p>\n",
+
+		// too short, too long, no letters, no digits, uppercase, inside words
+		"a94a8f 1234567 deadbeef A94A8FE xa94a8fe a94a8fex a94a8fe_1\n",
+		"<p>a94a8f 1234567 deadbeef A94A8FE xa94a8fe a94a8fex a94a8fe_1</p>\n",
+
+		"3f786850e387550fdab836ed7e6dc881de23001b0\n",
+		"<p>3f786850e387550fdab836ed7e6dc881de23001b0</p>\n",
+
+		"[fixes #123](http://example.com/)\n",
+		"<p><a href=\"http://example.com/\">fixes #123</a></p>\n",
+
+		"`#123`\n",
+		"<p><code>#123</code></p>\n",
+	}
+	doTestsInlineRenderer(t, tests, EXTENSION_REPO_REFERENCES, func() Renderer {
+		r := HtmlRenderer(HTML_USE_XHTML, "", "").(*Html)
+		r.SetIssueURLTemplate("https://example.com/issues/%s")
+		r.SetCommitURLTemplate("https://example.com/commit/%s")
+		return r
+	})
+
+	tests = []string{
+		"fixes #123 in a94a8fe\n",
+		"<p>fixes #123 in a94a8fe</p>\n",
+	}
+	doTestsInlineRenderer(t, tests, EXTENSION_REPO_REFERENCES, func() Renderer {
+		return HtmlRenderer(HTML_USE_XHTML, "", "")
+	})
+
+	tests = []string{
+		"fixes #123 in a94a8fe\n",
+		"<p>fixes #123 in a94a8fe</p>\n",
+	}
+	doTestsInlineRenderer(t, tests, 0, func() Renderer {
+		r := HtmlRenderer(HTML_USE_XHTML, "", "").(*Html)
+		r.SetIssueURLTemplate("https://example.com/issues/%s")
+		r.SetCommitURLTemplate("https://example.com/commit/%s")
+		return r
+	})
+}
+
+func TestDates(t *testing.T) {
+	var tests = []string{
+		"released 2024-01-15.\n",
+		"<p>released <time datetime=\"2024-01-15\">2024-01-15</time>.</p>\n",
+
+		"at 2024-01-15T10:30, 2024-01-15T10:30:05Z and 2024-02-29T23:59:59.125+05:30\n",
+		"<p>at <time datetime=\"2024-01-15T10:30\">2024-01-15T10:30</time>, " +
+			"<time datetime=\"2024-01-15T10:30:05Z\">2024-01-15T10:30:05Z</time> and " +
+			"<time datetime=\"2024-02-29T23:59:59.125+05:30\">2024-02-29T23:59:59.125+05:30</time></p>\n",
+
+		"(2024-01-15) *2024-01-15* [on 2024-01-15](/x)\n",
+		"<p>(<time datetime=\"2024-01-15\">2024-01-15</time>) " +
+			"<em><time datetime=\"2024-01-15\">2024-01-15</time></em> " +
+			"<a href=\"/x\">on <time datetime=\"2024-01-15\">2024-01-15</time></a></p>\n",
+
+		// a time that is not one leaves the date alone
+		"2024-01-15T25:00 2024-01-15T10:30:5\n",
+		"<p>2024-01-15T25:00 2024-01-15T10:30:5</p>\n",
+
+		// version numbers, other formats, and impossible dates
+		"v2024-01-15 1.2.3 2024.01.15 2024-1-15 2024-01-150 12024-01-15\n",
+		"<p>v2024-01-15 1.2.3 2024.01.15 2024-1-15 2024-01-150 12024-01-15</p>\n",
+
+		"2024-13-01 2023-02-29 2024-01-15-beta 1.2024-01-15 /posts/2024-01-15 2024-01-15.1\n",
+		"<p>2024-13-01 2023-02-29 2024-01-15-beta 1.2024-01-15 /posts/2024-01-15 2024-01-15.1</p>\n",
+
+		"`2024-01-15`\n",
+		"<p><code>2024-01-15</code></p>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_DATES, 0)
+
+	tests = []string{
+		"released 2024-01-15\n",
+		"<p>released 2024-01-15</p>\n",
+	}
+	doTestsInline(t, tests)
+}
+
+func TestImageAltText(t *testing.T) {
+	var tests = []string{
+		"![*bold* cat](x.png)\n",
+		"<p><img src=\"x.png\" alt=\"bold cat\" />\n</p>\n",
+
+		"![a [link](http://x.com/) and `c*d`](x.png)\n",
+		"<p><img src=\"x.png\" alt=\"a link and c*d\" />\n</p>\n",
+
+		"![AT&amp;T \\*not em\\* <b>x</b>](x.png)\n",
+		"<p><img src=\"x.png\" alt=\"AT&amp;T *not em* x\" />\n</p>\n",
+
+		"[![**logo**](x.png)](http://x.com/)\n",
+		"<p><a href=\"http://x.com/\"><img src=\"x.png\" alt=\"logo\" />\n</a></p>\n",
+	}
+	doTestsInline(t, tests)
+}
+
+func TestInlineBudget(t *testing.T) {
+	budget := func(n int) func() Renderer {
+		return func() Renderer {
+			r := HtmlRenderer(0, "", "").(*Html)
+			r.SetInlineBudget(n)
+			return r
+		}
+	}
+
+	// each possible span start charges the rest of the paragraph: 11, then 7,
+	// then 3 bytes, which is more than is left
+	var tests = []string{
+		"*a* *b* *c*\n\n*d*\n",
+		"<p><em>a</em> <em>b</em> *c*</p>\n\n<p>*d*</p>\n",
+	}
+	doTestsInlineRenderer(t, tests, 0, budget(20))
+
+	tests = []string{
+		"*a* *b* *c*\n\n*d*\n",
+		"<p><em>a</em> <em>b</em> <em>c</em></p>\n\n<p><em>d</em></p>\n",
+	}
+	doTestsInlineRenderer(t, tests, 0, budget(1000))
+	doTestsInlineRenderer(t, tests, 0, budget(0))
+
+	// unclosed markers make span parsing quadratic: without a budget, this
+	// takes tens of seconds
+	for _, marker := range []string{"*a ", "_a ", "[", "<a "} {
+		input := strings.Repeat(marker, 50000) + "\n\n*end*\n"
+		output := string(Markdown([]byte(input), budget(1000000)(), 0))
+		if !strings.HasSuffix(output, "<p>*end*</p>\n") {
+			t.Errorf("%q: the rest was not plain text: %q", marker, output[len(output)-50:])
+		}
+	}
+}
+
+func TestCommonMarkInline(t *testing.T) {
+	var tests = []string{
+		// 6.1 code spans end at a run of as many backticks, and lose one
+		// space from each side
+		"` `` `\n",
+		"<p><code>``</code></p>\n",
+
+		"`  ``  `\n",
+		"<p><code> `` </code></p>\n",
+
+		"` a`\n",
+		"<p><code> a</code></p>\n",
+
+		"`` foo\nbar  \nbaz ``\n",
+		"<p><code>foo bar   baz</code></p>\n",
+
+		"```foo``\n",
+		"<p>```foo``</p>\n",
+
+		"`foo``bar``\n",
+		"<p>`foo<code>bar</code></p>\n",
+
+		// 6.2 underscores do not make emphasis inside words
+		"foo_bar_\n",
+		"<p>foo_bar_</p>\n",
+
+		"snake_case_name\n",
+		"<p>snake_case_name</p>\n",
+
+		"_foo_bar\n",
+		"<p>_foo_bar</p>\n",
+
+		"foo__bar__\n",
+		"<p>foo__bar__</p>\n",
+
+		"___foo___bar\n",
+		"<p>___foo___bar</p>\n",
+
+		"_foo_ and __bar__\n",
+		"<p><em>foo</em> and <strong>bar</strong></p>\n",
+
+		// but asterisks do
+		"foo*bar*\n",
+		"<p>foo<em>bar</em></p>\n",
+
+		// 6.3 links bind tighter than emphasis
+		"*[foo*](/url)\n",
+		"<p>*<a href=\"/url\">foo*</a></p>\n",
+
+		"*foo [bar*](/url)\n",
+		"<p>*foo <a href=\"/url\">bar*</a></p>\n",
+
+		// and so do code spans
+		"*foo`*`\n",
+		"<p>*foo<code>*</code></p>\n",
+
+		// 6.7 a backslash at the end of a line is a hard line break
+		"foo\\\nbar\n",
+		"<p>foo<br />\nbar</p>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_COMMONMARK, 0)
+}
+
+func TestCommonMarkEmphasis(t *testing.T) {
+	// examples from section 6.2 of the spec: runs open and close
+	// emphasis by what is around them, and nest by the nearest opener
+	var tests = []string{
+		"*foo bar*\n",
+		"<p><em>foo bar</em></p>\n",
+
+		"a * foo bar*\n",
+		"<p>a * foo bar*</p>\n",
+
+		"a*\"foo\"*\n",
+		"<p>a*&quot;foo&quot;*</p>\n",
+
+		"5*6*78\n",
+		"<p>5<em>6</em>78</p>\n",
+
+		"_ foo bar_\n",
+		"<p>_ foo bar_</p>\n",
+
+		"пристаням_стремятся_\n",
+		"<p>пристаням_стремятся_</p>\n",
+
+		"foo-_(bar)_\n",
+		"<p>foo-<em>(bar)</em></p>\n",
+
+		"_foo*\n",
+		"<p>_foo*</p>\n",
+
+		"*foo bar *\n",
+		"<p>*foo bar *</p>\n",
+
+		"*(*foo)\n",
+		"<p>*(*foo)</p>\n",
+
+		"*(*foo*)*\n",
+		"<p><em>(<em>foo</em>)</em></p>\n",
+
+		"_(_foo_)_\n",
+		"<p><em>(<em>foo</em>)</em></p>\n",
+
+		"_foo_bar_baz_\n",
+		"<p><em>foo_bar_baz</em></p>\n",
+
+		"_(bar)_.\n",
+		"<p><em>(bar)</em>.</p>\n",
+
+		"a**\"foo\"**\n",
+		"<p>a**&quot;foo&quot;**</p>\n",
+
+		"foo**bar**\n",
+		"<p>foo<strong>bar</strong></p>\n",
+
+		"__foo, __bar__, baz__\n",
+		"<p><strong>foo, <strong>bar</strong>, baz</strong></p>\n",
+
+		"*foo**bar**baz*\n",
+		"<p><em>foo<strong>bar</strong>baz</em></p>\n",
+
+		"*foo**bar*\n",
+		"<p><em>foo**bar</em></p>\n",
+
+		"**foo*bar**\n",
+		"<p><strong>foo*bar</strong></p>\n",
+
+		"***foo** bar*\n",
+		"<p><em><strong>foo</strong> bar</em></p>\n",
+
+		"*foo **bar***\n",
+		"<p><em>foo <strong>bar</strong></em></p>\n",
+
+		"*foo**bar***\n",
+		"<p><em>foo<strong>bar</strong></em></p>\n",
+
+		"foo***bar***baz\n",
+		"<p>foo<em><strong>bar</strong></em>baz</p>\n",
+
+		"foo******bar*********baz\n",
+		"<p>foo<strong><strong><strong>bar</strong></strong></strong>***baz</p>\n",
+
+		"*foo *bar**\n",
+		"<p><em>foo <em>bar</em></em></p>\n",
+
+		"**foo \"*bar*\" foo**\n",
+		"<p><strong>foo &quot;<em>bar</em>&quot; foo</strong></p>\n",
+
+		"**foo*\n",
+		"<p>*<em>foo</em></p>\n",
+
+		"*foo**\n",
+		"<p><em>foo</em>*</p>\n",
+
+		"***foo**\n",
+		"<p>*<strong>foo</strong></p>\n",
+
+		"****foo*\n",
+		"<p>***<em>foo</em></p>\n",
+
+		"**foo***\n",
+		"<p><strong>foo</strong>*</p>\n",
+
+		"*foo****\n",
+		"<p><em>foo</em>***</p>\n",
+
+		"*foo _bar* baz_\n",
+		"<p><em>foo _bar</em> baz_</p>\n",
+
+		"*foo __bar *baz bim__ bam*\n",
+		"<p><em>foo <strong>bar *baz bim</strong> bam</em></p>\n",
+
+		"**foo **bar baz**\n",
+		"<p>**foo <strong>bar baz</strong></p>\n",
+
+		"*foo *bar baz*\n",
+		"<p>*foo <em>bar baz</em></p>\n",
+
+		"_foo [bar_](/url)\n",
+		"<p>_foo <a href=\"/url\">bar_</a></p>\n",
+
+		"*a `*`*\n",
+		"<p><em>a <code>*</code></em></p>\n",
+
+		"_____foo_____\n",
+		"<p><em><strong><strong>foo</strong></strong></em></p>\n",
+
+		"__foo_ bar_\n",
+		"<p><em><em>foo</em> bar</em></p>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_COMMONMARK, 0)
+}
+
+func TestNoIntraEmphasis(t *testing.T) {
+	var tests = []string{
+		"snake_case_var and __init__ and a___b___c\n",
+		"<p>snake_case_var and <strong>init</strong> and a___b___c</p>\n",
+
+		"call my__init__ or _private_name\n",
+		"<p>call my__init__ or _private_name</p>\n",
+
+		"foo_bar_ and _foo_bar\n",
+		"<p>foo_bar_ and _foo_bar</p>\n",
+
+		"a*b*c and a**b**c\n",
+		"<p>a<em>b</em>c and a<strong>b</strong>c</p>\n",
+
+		"_emphasis_, (_inside_) and __strong__\n",
+		"<p><em>emphasis</em>, (<em>inside</em>) and <strong>strong</strong></p>\n",
+	}
+	doTestsInlineParam(t, tests, EXTENSION_NO_INTRA_EMPHASIS, 0)
+}
@@ -0,0 +1,180 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// JSON form of the document tree
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// JSON_SCHEMA_VERSION is the version of the JSON form of the document tree
+// written by MarshalJSON. Attributes may be added without changing it; it
+// changes only if existing ones change meaning.
+const JSON_SCHEMA_VERSION = 1
+
+// The type names of nodes in JSON, indexed by NODE_* value.
+var jsonNodeTypes = []string{
+	NODE_DOCUMENT:           "document",
+	NODE_BLOCK_CODE:         "block_code",
+	NODE_BLOCK_QUOTE:        "block_quote",
+	NODE_ALERT:              "alert",
+	NODE_BLOCK_HTML:         "block_html",
+	NODE_HEADER:             "header",
+	NODE_HRULE:              "hrule",
+	NODE_LIST:               "list",
+	NODE_LIST_ITEM:          "list_item",
+	NODE_PARAGRAPH:          "paragraph",
+	NODE_TABLE:              "table",
+	NODE_TABLE_HEAD:         "table_head",
+	NODE_TABLE_BODY:         "table_body",
+	NODE_TABLE_ROW:          "table_row",
+	NODE_TABLE_HEADER_CELL:  "table_header_cell",
+	NODE_TABLE_CELL:         "table_cell",
+	NODE_FOOTNOTES:          "footnotes",
+	NODE_FOOTNOTE_ITEM:      "footnote_item",
+	NODE_DETAILS:            "details",
+	NODE_DETAILS_SUMMARY:    "details_summary",
+	NODE_DIRECTIVE:          "directive",
+	NODE_AUTO_LINK:          "auto_link",
+	NODE_CODE_SPAN:          "code_span",
+	NODE_DOUBLE_EMPHASIS:    "double_emphasis",
+	NODE_EMPHASIS:           "emphasis",
+	NODE_IMAGE:              "image",
+	NODE_LINE_BREAK:         "line_break",
+	NODE_LINK:               "link",
+	NODE_RAW_HTML_TAG:       "raw_html_tag",
+	NODE_TRIPLE_EMPHASIS:    "triple_emphasis",
+	NODE_STRIKETHROUGH:      "strikethrough",
+	NODE_CRITIC:             "critic",
+	NODE_CRITIC_REPLACEMENT: "critic_replacement",
+	NODE_TIME:               "time",
+	NODE_FOOTNOTE_REF:       "footnote_ref",
+	NODE_ENTITY:             "entity",
+	NODE_TEXT:               "text",
+}
+
+// MarshalJSON writes node and its descendants as JSON, so that tools in
+// other languages can read the tree. Each node is an object:
+//
+//	{"type": "header", "attributes": {"level": 1}, "children": [...]}
+//
+// type is the name of the NODE_* constant in lower case without the
+// prefix, such as "list_item". attributes holds the fields of the Node that
+// are set, under the names literal, level, id (HeaderID), flags, info,
+// destination, title, columns, index, and attrs, a list of {"key": ...,
+// "value": ...} objects; flags and columns hold the values of the
+// constants documented for those fields. children is an array, empty for a
+// leaf. A document node also has "version", set to JSON_SCHEMA_VERSION.
+// Nodes do not record where they were in the input.
+func (node *Node) MarshalJSON() ([]byte, error) {
+	var out bytes.Buffer
+	node.writeJSON(&out)
+	return out.Bytes(), nil
+}
+
+func (node *Node) writeJSON(out *bytes.Buffer) {
+	out.WriteString(`{"type":`)
+	writeJSONString(out, jsonNodeTypes[node.Type])
+	if node.Type == NODE_DOCUMENT {
+		out.WriteString(`,"version":`)
+		out.WriteString(strconv.Itoa(JSON_SCHEMA_VERSION))
+	}
+
+	out.WriteString(`,"attributes":{`)
+	first := true
+	attr := func(name string) {
+		if !first {
+			out.WriteByte(',')
+		}
+		first = false
+		writeJSONString(out, name)
+		out.WriteByte(':')
+	}
+	if node.Literal != nil {
+		attr("literal")
+		writeJSONString(out, string(node.Literal))
+	}
+	if node.Level != 0 {
+		attr("level")
+		out.WriteString(strconv.Itoa(node.Level))
+	}
+	if node.HeaderID != "" {
+		attr("id")
+		writeJSONString(out, node.HeaderID)
+	}
+	if node.Flags != 0 {
+		attr("flags")
+		out.WriteString(strconv.Itoa(node.Flags))
+	}
+	if node.Info != "" {
+		attr("info")
+		writeJSONString(out, node.Info)
+	}
+	if node.Destination != nil {
+		attr("destination")
+		writeJSONString(out, string(node.Destination))
+	}
+	if node.Title != nil {
+		attr("title")
+		writeJSONString(out, string(node.Title))
+	}
+	if node.Columns != nil {
+		attr("columns")
+		out.WriteByte('[')
+		for i, align := range node.Columns {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			out.WriteString(strconv.Itoa(align))
+		}
+		out.WriteByte(']')
+	}
+	if node.Index != 0 {
+		attr("index")
+		out.WriteString(strconv.Itoa(node.Index))
+	}
+	if node.Attrs != nil {
+		attr("attrs")
+		out.WriteByte('[')
+		for i, a := range node.Attrs {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			out.WriteString(`{"key":`)
+			writeJSONString(out, a.Key)
+			out.WriteString(`,"value":`)
+			writeJSONString(out, a.Value)
+			out.WriteByte('}')
+		}
+		out.WriteByte(']')
+	}
+
+	out.WriteString(`},"children":[`)
+	for i, child := range node.Children {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		child.writeJSON(out)
+	}
+	out.WriteString("]}")
+}
+
+func writeJSONString(out *bytes.Buffer, s string) {
+	// marshaling a string cannot fail
+	text, _ := json.Marshal(s)
+	out.Write(text)
+}
@@ -0,0 +1,69 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for the JSON form of the document tree
+//
+
+package blackfriday
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	var tests = []string{
+		"# Title {#top}\n",
+		`{"type":"document","version":1,"attributes":{},"children":[` +
+			`{"type":"header","attributes":{"level":1,"id":"top"},"children":[` +
+			`{"type":"text","attributes":{"literal":"Title"},"children":[]}]}]}`,
+
+		"[a *b*](/c \"d\") <x>\n",
+		`{"type":"document","version":1,"attributes":{},"children":[` +
+			`{"type":"paragraph","attributes":{},"children":[` +
+			`{"type":"link","attributes":{"destination":"/c","title":"d"},"children":[` +
+			`{"type":"text","attributes":{"literal":"a "},"children":[]},` +
+			`{"type":"emphasis","attributes":{},"children":[` +
+			`{"type":"text","attributes":{"literal":"b"},"children":[]}]}]},` +
+			`{"type":"text","attributes":{"literal":" "},"children":[]},` +
+			`{"type":"raw_html_tag","attributes":{"literal":"\u003cx\u003e"},"children":[]}]}]}`,
+
+		"a | b\n--- | --:\n",
+		`{"type":"document","version":1,"attributes":{},"children":[` +
+			`{"type":"table","attributes":{"columns":[0,2]},"children":[` +
+			`{"type":"table_head","attributes":{},"children":[` +
+			`{"type":"table_row","attributes":{},"children":[` +
+			`{"type":"table_header_cell","attributes":{},"children":[` +
+			`{"type":"text","attributes":{"literal":"a"},"children":[]}]},` +
+			`{"type":"table_header_cell","attributes":{"flags":2},"children":[` +
+			`{"type":"text","attributes":{"literal":"b"},"children":[]}]}]}]},` +
+			`{"type":"table_body","attributes":{},"children":[]}]}]}`,
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		input, expected := tests[i], tests[i+1]
+		actual, err := json.Marshal(Parse([]byte(input), commonExtensions|EXTENSION_HEADER_IDS))
+		if err != nil {
+			t.Errorf("\nInput   [%#v]\nError   [%v]", input, err)
+		} else if string(actual) != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%s]\nActual  [%s]", input, expected, actual)
+		}
+	}
+}
+
+func TestJSONNodeTypes(t *testing.T) {
+	if len(jsonNodeTypes) != len(nodeNames) {
+		t.Fatalf("%d JSON node types for %d node types", len(jsonNodeTypes), len(nodeNames))
+	}
+	for i, name := range jsonNodeTypes {
+		if name == "" {
+			t.Errorf("node type %s has no JSON name", nodeNames[i])
+		}
+	}
+}
@@ -35,7 +35,11 @@ func LatexRenderer(flags int) Renderer {
 }
 
 // render code chunks using verbatim, or listings if we have a language
-func (options *Latex) BlockCode(out *bytes.Buffer, text []byte, lang string) {
+func (options *Latex) BlockCode(out *bytes.Buffer, text []byte, info string) {
+	lang := ""
+	if langs := infoLanguages(info); len(langs) > 0 {
+		lang = langs[0]
+	}
 	if lang == "" {
 		out.WriteString("\n\\begin{verbatim}\n")
 	} else {
@@ -57,6 +61,29 @@ func (options *Latex) BlockQuote(out *bytes.Buffer, text []byte) {
 	out.WriteString("\n\\end{quotation}\n")
 }
 
+func (options *Latex) Alert(out *bytes.Buffer, text []byte, kind int) {
+	out.WriteString("\n\\begin{quotation}\n\\textbf{")
+	out.WriteString(alertTitle(kind))
+	out.WriteString("}\n")
+	out.Write(text)
+	out.WriteString("\n\\end{quotation}\n")
+}
+
+func (options *Latex) Details(out *bytes.Buffer, summary, text []byte, flags int) {
+	out.WriteString("\n\\textbf{")
+	if len(summary) == 0 {
+		out.WriteString("Details")
+	} else {
+		out.Write(summary)
+	}
+	out.WriteString("}\n")
+	out.Write(text)
+}
+
+func (options *Latex) Directive(out *bytes.Buffer, name string, attrs []DirectiveAttr, text []byte) {
+	out.Write(text)
+}
+
 func (options *Latex) BlockHtml(out *bytes.Buffer, text []byte) {
 	// a pretty lame thing to do...
 	out.WriteString("\n\\begin{verbatim}\n")
@@ -64,7 +91,7 @@ func (options *Latex) BlockHtml(out *bytes.Buffer, text []byte) {
 	out.WriteString("\n\\end{verbatim}\n")
 }
 
-func (options *Latex) Header(out *bytes.Buffer, text func() bool, level int) {
+func (options *Latex) Header(out *bytes.Buffer, text func() bool, level int, id string) {
 	marker := out.Len()
 
 	switch level {
@@ -86,6 +113,11 @@ func (options *Latex) Header(out *bytes.Buffer, text func() bool, level int) {
 		return
 	}
 	out.WriteString("}\n")
+	if id != "" {
+		out.WriteString("\\label{")
+		out.WriteString(id)
+		out.WriteString("}\n")
+	}
 }
 
 func (options *Latex) HRule(out *bytes.Buffer) {
@@ -110,8 +142,13 @@ func (options *Latex) List(out *bytes.Buffer, text func() bool, flags int) {
 	}
 }
 
-func (options *Latex) ListItem(out *bytes.Buffer, text []byte, flags int) {
+func (options *Latex) ListItem(out *bytes.Buffer, text []byte, flags, index, level int) {
 	out.WriteString("\n\\item ")
+	if flags&LIST_ITEM_TASK_DONE != 0 {
+		out.WriteString("$\\boxtimes$ ")
+	} else if flags&LIST_ITEM_TASK != 0 {
+		out.WriteString("$\\square$ ")
+	}
 	out.Write(text)
 }
 
@@ -175,6 +212,16 @@ func (options *Latex) FootnoteItem(out *bytes.Buffer, name, text []byte, flags i
 }
 
 func (options *Latex) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	switch kind {
+	case LINK_TYPE_ISSUE:
+		out.WriteString("\\#")
+		out.Write(link)
+		return
+	case LINK_TYPE_COMMIT:
+		out.Write(link)
+		return
+	}
+
 	out.WriteString("\\href{")
 	if kind == LINK_TYPE_EMAIL {
 		out.WriteString("mailto:")
@@ -245,6 +292,34 @@ func (options *Latex) StrikeThrough(out *bytes.Buffer, text []byte) {
 	out.WriteString("}")
 }
 
+func (options *Latex) CriticMarkup(out *bytes.Buffer, text, replacement []byte, kind int) {
+	switch kind {
+	case CRITIC_INSERTION:
+		out.WriteString("\\uline{")
+		out.Write(text)
+		out.WriteString("}")
+	case CRITIC_DELETION:
+		options.StrikeThrough(out, text)
+	case CRITIC_SUBSTITUTION:
+		options.StrikeThrough(out, text)
+		out.WriteString("\\uline{")
+		out.Write(replacement)
+		out.WriteString("}")
+	case CRITIC_HIGHLIGHT:
+		out.WriteString("\\hl{")
+		out.Write(text)
+		out.WriteString("}")
+	case CRITIC_COMMENT:
+		out.WriteString("\\marginpar{")
+		out.Write(text)
+		out.WriteString("}")
+	}
+}
+
+func (options *Latex) Time(out *bytes.Buffer, datetime []byte) {
+	options.NormalText(out, datetime)
+}
+
 // TODO: this
 func (options *Latex) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
 
@@ -293,12 +368,14 @@ func (options *Latex) NormalText(out *bytes.Buffer, text []byte) {
 func (options *Latex) DocumentHeader(out *bytes.Buffer) {
 	out.WriteString("\\documentclass{article}\n")
 	out.WriteString("\n")
+	out.WriteString("\\usepackage{amssymb}\n")
 	out.WriteString("\\usepackage{graphicx}\n")
 	out.WriteString("\\usepackage{listings}\n")
 	out.WriteString("\\usepackage[margin=1in]{geometry}\n")
 	out.WriteString("\\usepackage[utf8]{inputenc}\n")
 	out.WriteString("\\usepackage{verbatim}\n")
 	out.WriteString("\\usepackage[normalem]{ulem}\n")
+	out.WriteString("\\usepackage{soul}\n")
 	out.WriteString("\\usepackage{hyperref}\n")
 	out.WriteString("\n")
 	out.WriteString("\\hypersetup{colorlinks,%\n")
@@ -20,6 +20,8 @@ package blackfriday
 
 import (
 	"bytes"
+	"sort"
+	"strings"
 	"unicode/utf8"
 )
 
@@ -28,7 +30,7 @@ const VERSION = "1.1"
 // These are the supported markdown parsing extensions.
 // OR these values together to select multiple extensions.
 const (
-	EXTENSION_NO_INTRA_EMPHASIS          = 1 << iota // ignore emphasis markers inside words
+	EXTENSION_NO_INTRA_EMPHASIS          = 1 << iota // ignore underscores inside words as emphasis markers
 	EXTENSION_TABLES                                 // render tables
 	EXTENSION_FENCED_CODE                            // render fenced code blocks
 	EXTENSION_AUTOLINK                               // detect embedded URLs that are not explicitly marked
@@ -39,6 +41,20 @@ const (
 	EXTENSION_TAB_SIZE_EIGHT                         // expand tabs to eight spaces instead of four
 	EXTENSION_FOOTNOTES                              // Pandoc-style footnotes
 	EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK             // No need to insert an empty line to start a (code, quote, order list, unorder list)block
+	EXTENSION_LETTERED_LISTS                         // accept a., A., i., and I. style ordered list markers
+	EXTENSION_REPO_REFERENCES                        // link #123 issue numbers and commit hashes
+	EXTENSION_FRONT_MATTER                           // skip a leading YAML or TOML front matter block
+	EXTENSION_TASK_LISTS                             // render list items starting with [ ] or [x] as checkboxes
+	EXTENSION_ALERTS                                 // render blockquotes starting with [!NOTE] and the like as alerts
+	EXTENSION_MARKDOWN_IN_HTML                       // parse the contents of block tags marked markdown="1"
+	EXTENSION_DETAILS                                // render ::: details fenced sections as collapsible sections
+	EXTENSION_GRID_TABLES                            // render tables drawn with +---+ borders
+	EXTENSION_HEADER_IDS                             // take header ids from a trailing {#id}
+	EXTENSION_DIRECTIVES                             // render ::: name {attrs} fenced containers
+	EXTENSION_CRITIC_MARKUP                          // render CriticMarkup edits such as {++added++} and {--removed--}
+	EXTENSION_COMMONMARK                             // follow CommonMark where it differs most, with fenced code and space headers
+	EXTENSION_DATES                                  // render ISO 8601 dates and times such as 2024-01-15 with Time
+	EXTENSION_SPACE_ESCAPES                          // render a backslash before a space as a non-breaking space
 )
 
 // These are the possible flag values for the link renderer.
@@ -48,6 +64,9 @@ const (
 	LINK_TYPE_NOT_AUTOLINK = iota
 	LINK_TYPE_NORMAL
 	LINK_TYPE_EMAIL
+	LINK_TYPE_ISSUE  // link is an issue number, without the leading #
+	LINK_TYPE_COMMIT // link is an abbreviated or full commit hash
+	LINK_TYPE_IMAGE  // link is an image source, only given to the Html link callback
 )
 
 // These are the possible flag values for the ListItem renderer.
@@ -58,6 +77,12 @@ const (
 	LIST_ITEM_CONTAINS_BLOCK
 	LIST_ITEM_BEGINNING_OF_LIST
 	LIST_ITEM_END_OF_LIST
+	LIST_TYPE_LOWER_ALPHA // ordered list marked a., b., c.
+	LIST_TYPE_UPPER_ALPHA // ordered list marked A., B., C.
+	LIST_TYPE_LOWER_ROMAN // ordered list marked i., ii., iii.
+	LIST_TYPE_UPPER_ROMAN // ordered list marked I., II., III.
+	LIST_ITEM_TASK        // task list item, which starts with a checkbox
+	LIST_ITEM_TASK_DONE   // task list item whose checkbox is checked
 )
 
 // These are the possible flag values for the table cell renderer.
@@ -69,6 +94,50 @@ const (
 	TABLE_ALIGNMENT_CENTER = (TABLE_ALIGNMENT_LEFT | TABLE_ALIGNMENT_RIGHT)
 )
 
+// These are the possible kinds of alert for the Alert renderer.
+// Only a single one of these values will be used; they are not ORed together.
+// These are mostly of interest if you are writing a new output format.
+const (
+	ALERT_NOTE = iota
+	ALERT_TIP
+	ALERT_IMPORTANT
+	ALERT_WARNING
+	ALERT_CAUTION
+)
+
+// These are the possible flag values for the Details renderer.
+// These are mostly of interest if you are writing a new output format.
+const (
+	DETAILS_OPEN = 1 << iota // the section starts out expanded
+)
+
+// These are the possible kinds of edit for the CriticMarkup renderer.
+// Only a single one of these values will be used; they are not ORed together.
+// These are mostly of interest if you are writing a new output format.
+const (
+	CRITIC_INSERTION    = iota // {++text++}
+	CRITIC_DELETION            // {--text--}
+	CRITIC_SUBSTITUTION        // {~~text~>replacement~~}
+	CRITIC_HIGHLIGHT           // {==text==}
+	CRITIC_COMMENT             // {>>text<<}
+)
+
+// DirectiveAttr is an attribute of a directive, given in braces after its
+// name: #id gives the key id, .name the key class, and key=value or
+// key="value" any key.
+type DirectiveAttr struct {
+	Key, Value string
+}
+
+// The alert markers, without the [! and ], indexed by kind.
+var alertTypes = []string{"NOTE", "TIP", "IMPORTANT", "WARNING", "CAUTION"}
+
+// The title shown at the top of an alert, such as "Note".
+func alertTitle(kind int) string {
+	name := alertTypes[kind]
+	return name[:1] + strings.ToLower(name[1:])
+}
+
 // The size of a tab stop.
 const (
 	TAB_SIZE_DEFAULT = 4
@@ -127,16 +196,28 @@ var blockTags = map[string]bool{
 // If the callback returns false, the rendering function should reset the
 // output buffer as though it had never been called.
 //
+// BlockCode receives the complete info string of a fenced code block, i.e.,
+// everything after the opening fence marker with surrounding whitespace
+// removed, so custom renderers can interpret attributes beyond the language.
+// It is empty for indented code blocks.
+//
+// ListItem receives the number of the item in its list, counting from 1,
+// and the nesting level of the list, 1 for a list that is not inside
+// another, so that renderers can number items themselves, as 1., a., or i.
+//
 // Currently Html and Latex implementations are provided
 type Renderer interface {
 	// block-level callbacks
-	BlockCode(out *bytes.Buffer, text []byte, lang string)
+	BlockCode(out *bytes.Buffer, text []byte, info string)
 	BlockQuote(out *bytes.Buffer, text []byte)
+	Alert(out *bytes.Buffer, text []byte, kind int)
+	Details(out *bytes.Buffer, summary, text []byte, flags int)
+	Directive(out *bytes.Buffer, name string, attrs []DirectiveAttr, text []byte)
 	BlockHtml(out *bytes.Buffer, text []byte)
-	Header(out *bytes.Buffer, text func() bool, level int)
+	Header(out *bytes.Buffer, text func() bool, level int, id string)
 	HRule(out *bytes.Buffer)
 	List(out *bytes.Buffer, text func() bool, flags int)
-	ListItem(out *bytes.Buffer, text []byte, flags int)
+	ListItem(out *bytes.Buffer, text []byte, flags, index, level int)
 	Paragraph(out *bytes.Buffer, text func() bool)
 	Table(out *bytes.Buffer, header []byte, body []byte, columnData []int)
 	TableRow(out *bytes.Buffer, text []byte)
@@ -156,6 +237,8 @@ type Renderer interface {
 	RawHtmlTag(out *bytes.Buffer, tag []byte)
 	TripleEmphasis(out *bytes.Buffer, text []byte)
 	StrikeThrough(out *bytes.Buffer, text []byte)
+	CriticMarkup(out *bytes.Buffer, text, replacement []byte, kind int)
+	Time(out *bytes.Buffer, datetime []byte)
 	FootnoteRef(out *bytes.Buffer, ref []byte, id int)
 
 	// Low-level callbacks
@@ -171,6 +254,48 @@ type Renderer interface {
 // for each character that triggers a response when parsing inline data.
 type inlineParser func(p *parser, out *bytes.Buffer, data []byte, offset int) int
 
+// The nesting depth at which the parser stops descending into blocks and
+// spans, unless the renderer sets its own.
+const defaultMaxNesting = 16
+
+// parserLimiter is a renderer that sets limits on the parser, as Html does
+// with SetMaxNestingDepth and SetInlineBudget.
+type parserLimiter interface {
+	maxNestingDepth() int
+	inlineBudget() int
+}
+
+// sourceMarker is a renderer that marks blocks with where they came from in
+// the input, as Html does with SetSourcePos and SetTaskLines. If
+// marksSource is true, the parser calls blockSource just before each call
+// for a header, paragraph, list, list item, code block, block quote, alert,
+// horizontal rule, or table, with the line and column of its first and
+// last characters, counting from 1, or zeros if they are not known.
+type sourceMarker interface {
+	marksSource() bool
+	blockSource(startLine, startCol, endLine, endCol int)
+}
+
+// codeTabKeeper is a renderer that expands the tabs of code blocks itself,
+// as Html does with SetCodeTabWidth. If keepsCodeTabs is true, firstPass
+// leaves the tabs of fenced code blocks at the top level as they are.
+type codeTabKeeper interface {
+	keepsCodeTabs() bool
+}
+
+// inlineFinisher is a renderer that finishes its output in DocumentFooter,
+// as MarkdownPrinter and Ansi do, so that MarkdownInline, which does not
+// call it, must have the output finished with finishInline.
+type inlineFinisher interface {
+	finishInline(out *bytes.Buffer)
+}
+
+// inlineExtender is a renderer with inline parsers of its own, as Html has
+// with SetInlineParser.
+type inlineExtender interface {
+	inlineParsers() map[byte]func(out *bytes.Buffer, data []byte, offset int) int
+}
+
 // Parser holds runtime state used by the parser.
 // This is constructed by the Markdown function.
 type parser struct {
@@ -179,13 +304,47 @@ type parser struct {
 	inlineCallback [256]inlineParser
 	flags          int
 	nesting        int
+	listLevel      int // lists the parser is inside
 	maxNesting     int
+	inlineBudget   int // bytes left for span parsers to scan: 0 for no limit, -1 once spent
 	insideLink     bool
+	keepCodeTabs   bool          // firstPass leaves tabs in top-level fenced code
+	emphasisRuns   *emphasisRuns // CommonMark emphasis found in the text of the current inline call
 
 	// Footnotes need to be ordered as well as available to quickly check for
 	// presence. If a ref is also a footnote, it's stored both in refs and here
 	// in notes. Slice is nil if footnotes not enabled.
 	notes []*reference
+
+	// for Document: with trackLines, firstPass records where each line
+	// it writes came from, and block calls topBlock before each top-level
+	// block, stopping if it returns false
+	trackLines  bool
+	sourceLines []sourceLine
+	topBlock    func(out *bytes.Buffer, data []byte) bool
+	skipInline  bool // parse blocks without their text
+
+	// for sourceMarker: the number of the
+	// first line of the input after any front matter, and, with
+	// trackLines, the buffers being parsed with where their lines came from
+	firstLine    int
+	lineMaps     []lineMap
+	sourceMarker sourceMarker
+}
+
+// where a line of the first pass output starts, and where it came from in
+// the input, as a byte offset and a line number; col is the number of
+// columns of that line before it, with tabs expanded
+type sourceLine struct {
+	pos, src, line, col int
+}
+
+// A buffer that blocks are parsed from, with where its lines came from.
+// The first pass output has one, as do the copies of the lines of list
+// items and block quotes, with src unset.
+type lineMap struct {
+	buf   []byte
+	lines []sourceLine
 }
 
 //
@@ -207,12 +366,23 @@ func MarkdownBasic(input []byte) []byte {
 	return Markdown(input, renderer, extensions)
 }
 
+// the extensions used by MarkdownCommon
+const commonExtensions = 0 |
+	EXTENSION_NO_INTRA_EMPHASIS |
+	EXTENSION_TABLES |
+	EXTENSION_FENCED_CODE |
+	EXTENSION_AUTOLINK |
+	EXTENSION_STRIKETHROUGH |
+	EXTENSION_SPACE_HEADERS
+
 // Call Markdown with most useful extensions enabled
 // MarkdownCommon is a convenience function for simple rendering.
 // It processes markdown input with common extensions enabled, including:
 //
 // * Smartypants processing with smart fractions and LaTeX dashes
 //
+// * Escaping of entities that HTML does not define, such as &bogus;
+//
 // * Intra-word emphasis suppression
 //
 // * Tables
@@ -225,25 +395,20 @@ func MarkdownBasic(input []byte) []byte {
 //
 // * Strict header parsing
 func MarkdownCommon(input []byte) []byte {
-	// set up the HTML renderer
+	return Markdown(input, commonHtmlRenderer(), commonExtensions)
+}
+
+// The HTML renderer used by MarkdownCommon.
+func commonHtmlRenderer() Renderer {
 	htmlFlags := 0
 	htmlFlags |= HTML_USE_XHTML
 	htmlFlags |= HTML_USE_SMARTYPANTS
 	htmlFlags |= HTML_SMARTYPANTS_FRACTIONS
 	htmlFlags |= HTML_SMARTYPANTS_LATEX_DASHES
 	htmlFlags |= HTML_SKIP_SCRIPT
-	renderer := HtmlRenderer(htmlFlags, "", "")
-
-	// set up the parser
-	extensions := 0
-	extensions |= EXTENSION_NO_INTRA_EMPHASIS
-	extensions |= EXTENSION_TABLES
-	extensions |= EXTENSION_FENCED_CODE
-	extensions |= EXTENSION_AUTOLINK
-	extensions |= EXTENSION_STRIKETHROUGH
-	extensions |= EXTENSION_SPACE_HEADERS
-
-	return Markdown(input, renderer, extensions)
+	renderer := HtmlRenderer(htmlFlags, "", "").(*Html)
+	renderer.SetEntities(ENTITIES_ESCAPE_UNKNOWN)
+	return renderer
 }
 
 // Markdown is the main rendering function.
@@ -259,13 +424,69 @@ func Markdown(input []byte, renderer Renderer, extensions int) []byte {
 		return nil
 	}
 
+	p := newParser(renderer, extensions)
+	if extensions&EXTENSION_FRONT_MATTER != 0 {
+		_, body := FrontMatter(input)
+		p.firstLine += bytes.Count(input[:len(input)-len(body)], []byte("\n"))
+		input = body
+	}
+
+	first := firstPass(p, input)
+	second := secondPass(p, first)
+
+	return second
+}
+
+// MarkdownInline renders input as the text of one paragraph, without the
+// paragraph around it, for titles, table cells, and other places that only
+// take inline markup such as emphasis, links, and code spans. Block markup
+// such as headers and lists is rendered as text, and the renderer's
+// DocumentHeader and DocumentFooter are not called; the MarkdownPrinter and
+// Ansi renderers still wrap the text as they would a paragraph, and Ansi
+// lists numbered links after it. Reference definitions are not read, and
+// EXTENSION_FOOTNOTES is ignored. Space around the input is dropped.
+func MarkdownInline(input []byte, renderer Renderer, extensions int) []byte {
+	if renderer == nil {
+		return nil
+	}
+
+	p := newParser(renderer, extensions&^EXTENSION_FOOTNOTES)
+	var out bytes.Buffer
+	p.inline(&out, bytes.TrimSpace(input))
+	if finisher, ok := renderer.(inlineFinisher); ok {
+		finisher.finishInline(&out)
+	}
+	return out.Bytes()
+}
+
+// Set up a parser for rendering with renderer.
+func newParser(renderer Renderer, extensions int) *parser {
 	// fill in the render structure
 	p := new(parser)
 	p.r = renderer
+	if extensions&EXTENSION_COMMONMARK != 0 {
+		extensions |= EXTENSION_FENCED_CODE | EXTENSION_SPACE_HEADERS
+	}
 	p.flags = extensions
 	p.refs = make(map[string]*reference)
-	p.maxNesting = 16
+	p.maxNesting = defaultMaxNesting
+	if limiter, ok := renderer.(parserLimiter); ok {
+		if limiter.maxNestingDepth() > 0 {
+			p.maxNesting = limiter.maxNestingDepth()
+		}
+		if limiter.inlineBudget() > 0 {
+			p.inlineBudget = limiter.inlineBudget()
+		}
+	}
 	p.insideLink = false
+	if keeper, ok := renderer.(codeTabKeeper); ok && extensions&EXTENSION_FENCED_CODE != 0 {
+		p.keepCodeTabs = keeper.keepsCodeTabs()
+	}
+	p.firstLine = 1
+	if marker, ok := renderer.(sourceMarker); ok && marker.marksSource() {
+		p.sourceMarker = marker
+		p.trackLines = true
+	}
 
 	// register inline parsers
 	p.inlineCallback['*'] = emphasis
@@ -284,14 +505,88 @@ func Markdown(input []byte, renderer Renderer, extensions int) []byte {
 		p.inlineCallback[':'] = autoLink
 	}
 
+	if extensions&EXTENSION_CRITIC_MARKUP != 0 {
+		p.inlineCallback['{'] = criticMarkup
+	}
+
+	// the renderer's own parsers come before the built-in ones
+	if extender, ok := renderer.(inlineExtender); ok {
+		for trigger, handler := range extender.inlineParsers() {
+			p.inlineCallback[trigger] = customInline(handler, p.inlineCallback[trigger])
+		}
+	}
+
 	if extensions&EXTENSION_FOOTNOTES != 0 {
 		p.notes = make([]*reference, 0)
 	}
 
-	first := firstPass(p, input)
-	second := secondPass(p, first)
+	return p
+}
 
-	return second
+// Wrap an inline parser of the renderer's own, so that the built-in parser
+// for the same trigger, if any, runs when it consumes nothing.
+func customInline(handler func(out *bytes.Buffer, data []byte, offset int) int, builtin inlineParser) inlineParser {
+	return func(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+		if consumed := handler(out, data, offset); consumed > 0 {
+			if consumed > len(data)-offset {
+				consumed = len(data) - offset
+			}
+			return consumed
+		}
+		if builtin == nil {
+			return 0
+		}
+		return builtin(p, out, data, offset)
+	}
+}
+
+// FrontMatter splits a YAML or TOML front matter block off the beginning of
+// a markdown document, returning the raw contents of the block and the rest
+// of the document. If there is no front matter block, matter is nil and
+// body is the whole input.
+//
+// A front matter block must start on the first line of the input with a
+// line containing only "---" (YAML) or "+++" (TOML), and ends with the
+// next line containing only the same delimiter. Trailing spaces and tabs
+// are allowed on both delimiter lines. Without a closing delimiter there
+// is no front matter block.
+//
+// With EXTENSION_FRONT_MATTER, Markdown skips the block and renders only
+// the body; call FrontMatter on the same input to retrieve the metadata.
+func FrontMatter(input []byte) (matter, body []byte) {
+	delim := frontMatterDelimiter(input)
+	if delim == 0 {
+		return nil, input
+	}
+
+	// skip past the opening line
+	beg := bytes.IndexByte(input, '\n') + 1
+	for end := beg; end < len(input); {
+		next := bytes.IndexByte(input[end:], '\n') + 1
+		if next == 0 {
+			next = len(input) - end
+		}
+		if frontMatterDelimiter(input[end:end+next]) == delim {
+			return input[beg:end], input[end+next:]
+		}
+		end += next
+	}
+	return nil, input
+}
+
+// Check whether a line consists of a front matter delimiter and return
+// the delimiter character, or 0 if it does not.
+func frontMatterDelimiter(line []byte) byte {
+	if len(line) < 3 || (line[0] != '-' && line[0] != '+') ||
+		line[1] != line[0] || line[2] != line[0] {
+		return 0
+	}
+	for i := 3; i < len(line) && line[i] != '\n'; i++ {
+		if line[i] != ' ' && line[i] != '\t' && line[i] != '\r' {
+			return 0
+		}
+	}
+	return line[0]
 }
 
 // first pass:
@@ -306,8 +601,12 @@ func firstPass(p *parser, input []byte) []byte {
 		tabSize = TAB_SIZE_EIGHT
 	}
 	beg, end := 0, 0
+	line := p.firstLine
+	// the marker of the fenced code block the line is in, with keepCodeTabs
+	fence := ""
 	for beg < len(input) { // iterate over lines
 		if end = isReference(p, input[beg:], tabSize); end > 0 {
+			line += bytes.Count(input[beg:beg+end], []byte("\n"))
 			beg += end
 		} else { // skip to the next line
 			end = beg
@@ -315,11 +614,24 @@ func firstPass(p *parser, input []byte) []byte {
 				end++
 			}
 
-			// add the line body if present
+			if p.trackLines {
+				p.sourceLines = append(p.sourceLines, sourceLine{out.Len(), beg, line, 0})
+			}
+
+			// add the line body if present, keeping the tabs of code
+			// for a renderer that expands them itself
+			lineStart := out.Len()
 			if end > beg {
-				expandTabs(&out, input[beg:end], tabSize)
+				if fence != "" {
+					out.Write(input[beg:end])
+				} else {
+					expandTabs(&out, input[beg:end], tabSize)
+				}
 			}
 			out.WriteByte('\n')
+			if p.keepCodeTabs {
+				fence = p.fenceAfter(out.Bytes()[lineStart:], fence)
+			}
 
 			if end < len(input) && input[end] == '\r' {
 				end++
@@ -329,17 +641,99 @@ func firstPass(p *parser, input []byte) []byte {
 			}
 
 			beg = end
+			line++
 		}
 	}
 
 	// empty input?
 	if out.Len() == 0 {
+		if p.trackLines {
+			p.sourceLines = append(p.sourceLines, sourceLine{0, 0, line, 0})
+		}
 		out.WriteByte('\n')
 	}
 
+	if p.trackLines {
+		p.lineMaps = append(p.lineMaps[:0], lineMap{out.Bytes(), p.sourceLines})
+	}
 	return out.Bytes()
 }
 
+// Return the marker of the fenced code block that the lines after line are
+// in, given fence, the marker of the block line is in, if any.
+func (p *parser) fenceAfter(line []byte, fence string) string {
+	if fence != "" {
+		if end, _ := p.isFencedCode(line, nil, fence); end > 0 {
+			return ""
+		}
+		return fence
+	}
+	var info *string
+	if beg, marker := p.isFencedCode(line, &info, ""); beg > 0 {
+		return marker
+	}
+	return ""
+}
+
+// Return the line and column of the input where data, a slice of a
+// buffer being parsed, starts, counting from 1, or zeros if that is not
+// known. A slice of a buffer shares the end of its array, which tells
+// which buffer it is.
+func (p *parser) inputPos(data []byte) (line, col int) {
+	if cap(data) == 0 {
+		return 0, 0
+	}
+	end := &data[:cap(data)][cap(data)-1]
+	for i := len(p.lineMaps) - 1; i >= 0; i-- {
+		m := p.lineMaps[i]
+		if cap(m.buf) == 0 || &m.buf[:cap(m.buf)][cap(m.buf)-1] != end {
+			continue
+		}
+		pos := cap(m.buf) - cap(data)
+		n := sort.Search(len(m.lines), func(n int) bool { return m.lines[n].pos > pos })
+		if n == 0 {
+			return 0, 0
+		}
+		start := m.lines[n-1]
+		return start.line, start.col + pos - start.pos + 1
+	}
+	return 0, 0
+}
+
+// Return where a line copied to pos in another buffer came from, given
+// data, the rest of the line in the buffer it was copied from.
+func (p *parser) copiedLine(pos int, data []byte) sourceLine {
+	line, col := p.inputPos(data)
+	if line == 0 {
+		return sourceLine{pos: pos}
+	}
+	return sourceLine{pos: pos, line: line, col: col - 1}
+}
+
+// Tell the sourceMarker, if any, that the block about to be rendered is
+// data[beg:end], without the space around it.
+func (p *parser) markSource(data []byte, beg, end int) {
+	if p.sourceMarker == nil {
+		return
+	}
+	for beg < end && isspace(data[beg]) {
+		beg++
+	}
+	for end > beg && isspace(data[end-1]) {
+		end--
+	}
+	if beg == end {
+		p.sourceMarker.blockSource(0, 0, 0, 0)
+		return
+	}
+	startLine, startCol := p.inputPos(data[beg:])
+	endLine, endCol := p.inputPos(data[end-1:])
+	if startLine == 0 || endLine == 0 {
+		startLine, startCol, endLine, endCol = 0, 0, 0, 0
+	}
+	p.sourceMarker.blockSource(startLine, startCol, endLine, endCol)
+}
+
 // second pass: actual rendering
 func secondPass(p *parser, input []byte) []byte {
 	var output bytes.Buffer
@@ -412,6 +806,22 @@ type reference struct {
 	hasBlock bool
 }
 
+// Check whether a footnote has been referred to already.
+func (p *parser) hasNote(ref *reference) bool {
+	for _, note := range p.notes {
+		if note == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// The key of a reference id in parser.refs. Ids match regardless of case
+// and of the spaces, tabs, and newlines between their words.
+func referenceKey(id []byte) string {
+	return strings.Join(strings.Fields(strings.ToLower(string(id))), " ")
+}
+
 // Check whether or not data starts with a reference link.
 // If so, it is parsed and stored in the list of references
 // (in the render struct).
@@ -443,7 +853,11 @@ func isReference(p *parser, data []byte, tabSize int) int {
 		}
 	}
 	idOffset := i
-	for i < len(data) && data[i] != '\n' && data[i] != '\r' && data[i] != ']' {
+	for i < len(data) && data[i] != ']' {
+		// the id of a link may go on over lines, but not over a blank one
+		if (data[i] == '\n' || data[i] == '\r') && (noteId != 0 || p.isEmpty(data[i+1:]) > 0) {
+			return 0
+		}
 		i++
 	}
 	if i >= len(data) || data[i] != ']' {
@@ -508,10 +922,7 @@ func isReference(p *parser, data []byte, tabSize int) int {
 		ref.title = data[titleOffset:titleEnd]
 	}
 
-	// id matches are case-insensitive
-	id := string(bytes.ToLower(data[idOffset:idEnd]))
-
-	p.refs[id] = ref
+	p.refs[referenceKey(data[idOffset:idEnd])] = ref
 
 	return lineEnd
 }
@@ -635,8 +1046,7 @@ gatherLines:
 			continue
 		}
 
-		n := 0
-		if n = isIndented(data[blockEnd:i], indentSize); n == 0 {
+		if isIndented(data[blockEnd:i], indentSize) == 0 {
 			// this is the end of the block.
 			// we don't want to include this last line in the index.
 			break gatherLines
@@ -648,8 +1058,12 @@ gatherLines:
 			containsBlankLine = false
 		}
 
-		// get rid of that first tab, write to buffer
-		raw.Write(data[blockEnd+n : i])
+		// expand tabs, as the first pass does for the rest of the
+		// document, so that a tab nests as deeply as its columns, then
+		// get rid of the indentation
+		var line bytes.Buffer
+		expandTabs(&line, data[blockEnd:i], indentSize)
+		raw.Write(line.Bytes()[indentSize:])
 		hasBlock = true
 
 		blockEnd = i
@@ -697,6 +1111,12 @@ func isalnum(c byte) bool {
 	return (c >= '0' && c <= '9') || isletter(c)
 }
 
+// Test if a character can be part of a word: an ASCII letter or digit,
+// an underscore, or any byte of a multi-byte utf-8 sequence.
+func isWordChar(c byte) bool {
+	return isalnum(c) || c == '_' || c >= 0x80
+}
+
 // Replace tab characters with spaces, aligning to the next TAB_SIZE column.
 // always ends output with a newline
 func expandTabs(out *bytes.Buffer, line []byte, tabSize int) {
@@ -775,6 +1195,53 @@ func isIndented(data []byte, indentSize int) int {
 	return indentSize
 }
 
+// Extract the language names from a code block info string. The info string
+// either starts with a brace-delimited list of names, as in
+// {.python .numbered}, or with a single language name that may be followed
+// by arbitrary text, as in go title="main.go". Leading dots are removed,
+// and key=value attributes within the braces are skipped.
+func infoLanguages(info string) []string {
+	var fields []string
+	if strings.HasPrefix(info, "{") {
+		end := strings.IndexByte(info, '}')
+		if end < 0 {
+			end = len(info)
+		}
+		fields = strings.Fields(info[1:end])
+	} else if fields = strings.Fields(info); len(fields) > 1 {
+		fields = fields[:1]
+	}
+
+	langs := fields[:0]
+	for _, elt := range fields {
+		if strings.IndexByte(elt, '=') >= 0 {
+			continue
+		}
+		if elt = strings.TrimPrefix(elt, "."); elt != "" {
+			langs = append(langs, elt)
+		}
+	}
+	return langs
+}
+
+// Find the value of a key=value attribute in a code block info string, as
+// in go {firstline=42}. Surrounding quotes are removed from the value.
+func infoAttribute(info, key string) (value string, ok bool) {
+	fields := strings.FieldsFunc(info, func(r rune) bool {
+		return r == '{' || r == '}' || r == ' ' || r == '\t'
+	})
+	for _, elt := range fields {
+		if strings.HasPrefix(elt, key+"=") {
+			value = elt[len(key)+1:]
+			if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+				value = value[1 : len(value)-1]
+			}
+			return value, true
+		}
+	}
+	return "", false
+}
+
 // Create a url-safe slug for fragments
 func slugify(in []byte) []byte {
 	if len(in) == 0 {
@@ -0,0 +1,714 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Document tree
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Node types, one for each Renderer callback, plus containers for the
+// document and the parts of a table.
+const (
+	NODE_DOCUMENT = iota
+	NODE_BLOCK_CODE
+	NODE_BLOCK_QUOTE
+	NODE_ALERT
+	NODE_BLOCK_HTML
+	NODE_HEADER
+	NODE_HRULE
+	NODE_LIST
+	NODE_LIST_ITEM
+	NODE_PARAGRAPH
+	NODE_TABLE
+	NODE_TABLE_HEAD
+	NODE_TABLE_BODY
+	NODE_TABLE_ROW
+	NODE_TABLE_HEADER_CELL
+	NODE_TABLE_CELL
+	NODE_FOOTNOTES
+	NODE_FOOTNOTE_ITEM
+	NODE_DETAILS
+	NODE_DETAILS_SUMMARY
+	NODE_DIRECTIVE
+	NODE_AUTO_LINK
+	NODE_CODE_SPAN
+	NODE_DOUBLE_EMPHASIS
+	NODE_EMPHASIS
+	NODE_IMAGE
+	NODE_LINE_BREAK
+	NODE_LINK
+	NODE_RAW_HTML_TAG
+	NODE_TRIPLE_EMPHASIS
+	NODE_STRIKETHROUGH
+	NODE_CRITIC
+	NODE_CRITIC_REPLACEMENT
+	NODE_TIME
+	NODE_FOOTNOTE_REF
+	NODE_ENTITY
+	NODE_TEXT
+)
+
+// Return values of a Walk visitor
+const (
+	WALK_CONTINUE      = iota // visit the children, then the following nodes
+	WALK_SKIP_CHILDREN        // skip the children and exit of the node just entered
+	WALK_STOP                 // end the walk
+)
+
+// Node is an element of the document tree built by Parse. Which fields
+// are set depends on Type; they hold the values the parser would have
+// passed to the matching Renderer callback.
+type Node struct {
+	Type     int
+	Parent   *Node
+	Children []*Node
+
+	Literal     []byte // text, code, html, entity, date, image alt text, or footnote name
+	Level       int    // header level, or nesting level of a list item
+	HeaderID    string // header id given with EXTENSION_HEADER_IDS
+	Flags       int    // list, footnote, and details flags, cell alignment, or autolink, alert, or CriticMarkup kind
+	Info        string // code block info string or directive name
+	Destination []byte // link, image, and autolink target
+	Title       []byte // link and image title
+	Columns     []int  // table column alignments
+	Index       int    // footnote reference number, or number of a list item
+
+	Attrs []DirectiveAttr // directive attributes
+}
+
+// Parse parses markdown input into a document tree, using the same
+// extensions as Markdown. A NUL byte in the text is replaced with U+FFFD.
+func Parse(input []byte, extensions int) *Node {
+	builder := &nodeBuilder{}
+	output := Markdown(input, builder, extensions)
+
+	document := &Node{Type: NODE_DOCUMENT}
+	for _, child := range builder.children(output) {
+		document.appendChild(child)
+	}
+	return document
+}
+
+// Walk visits node and its descendants depth first, calling visitor once
+// when entering each node and once when leaving it. The visitor returns one
+// of the WALK_* values; Walk returns WALK_STOP if the walk was ended early.
+func (node *Node) Walk(visitor func(node *Node, entering bool) int) int {
+	switch visitor(node, true) {
+	case WALK_STOP:
+		return WALK_STOP
+	case WALK_SKIP_CHILDREN:
+		return WALK_CONTINUE
+	}
+	for _, child := range node.Children {
+		if child.Walk(visitor) == WALK_STOP {
+			return WALK_STOP
+		}
+	}
+	if visitor(node, false) == WALK_STOP {
+		return WALK_STOP
+	}
+	return WALK_CONTINUE
+}
+
+func (node *Node) appendChild(child *Node) {
+	child.Parent = node
+	node.Children = append(node.Children, child)
+}
+
+// Render renders a document tree, possibly modified after Parse, with any
+// Renderer, making the same calls the parser would have made.
+func Render(document *Node, renderer Renderer) []byte {
+	var output bytes.Buffer
+	renderer.DocumentHeader(&output)
+	renderChildren(&output, document, renderer)
+	renderer.DocumentFooter(&output)
+	return output.Bytes()
+}
+
+// RenderSection renders the part of a document tree between the HTML
+// comments <!-- begin:name --> and <!-- end:name -->, each written as a
+// block of its own, with any Renderer. References and footnotes are
+// resolved against the whole document.
+//
+// A section can be inside a container such as a blockquote, and then
+// ends with it if its end marker is missing; a section at the top level
+// without an end marker runs to the end of the document. Every section
+// with the name is rendered, in order. Sections with the same name can
+// nest, and the markers of any sections are left out of the output.
+// Returns nil if there is no such section.
+func RenderSection(document *Node, renderer Renderer, name string) []byte {
+	var blocks []*Node
+	if !collectSection(document, name, &blocks) {
+		return nil
+	}
+	return Render(&Node{Type: NODE_DOCUMENT, Children: blocks}, renderer)
+}
+
+// Add the children of node that are in the sections named name to blocks,
+// looking inside the other children. Returns whether a section was found.
+func collectSection(node *Node, name string, blocks *[]*Node) bool {
+	found, depth := false, 0
+	for _, child := range node.Children {
+		kind, marker := sectionMarker(child)
+		switch {
+		case kind == "begin" && marker == name:
+			found = true
+			depth++
+		case kind == "end" && marker == name && depth > 0:
+			depth--
+		case kind != "":
+			// the marker of another section
+		case depth > 0:
+			*blocks = append(*blocks, child)
+		default:
+			if collectSection(child, name, blocks) {
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+// Check whether node is a section marker, an HTML block holding only a
+// comment such as <!-- begin:name -->. Returns begin or end and the name,
+// or "" if it is not a marker.
+func sectionMarker(node *Node) (kind, name string) {
+	if node.Type != NODE_BLOCK_HTML {
+		return "", ""
+	}
+	text := strings.TrimSpace(string(node.Literal))
+	if !strings.HasPrefix(text, "<!--") || !strings.HasSuffix(text, "-->") || len(text) < len("<!---->") {
+		return "", ""
+	}
+	text = strings.TrimSpace(text[len("<!--") : len(text)-len("-->")])
+	for _, kind := range []string{"begin", "end"} {
+		if strings.HasPrefix(text, kind+":") {
+			if name = strings.TrimSpace(text[len(kind)+1:]); name != "" && !strings.ContainsAny(name, " \t\n") {
+				return kind, name
+			}
+		}
+	}
+	return "", ""
+}
+
+// Heading is a document header, as listed by Headings.
+type Heading struct {
+	Level int    // 1 to 6
+	Text  string // plain text, without markup
+	Slug  string // the id given to the header with HTML_TOC
+}
+
+// Headings lists the headers of a document in order, parsing it with
+// extensions, which should be those the document is rendered with for the
+// slugs to match its ids. With EXTENSION_HEADER_IDS, a header ending with
+// {#id} has that id as its slug; the others are numbered in order, so any
+// extension that changes which lines are headers, such as
+// EXTENSION_SPACE_HEADERS or EXTENSION_FENCED_CODE, changes their slugs.
+func Headings(input []byte, extensions int) []Heading {
+	var headings []Heading
+	Parse(input, extensions).Walk(func(node *Node, entering bool) int {
+		if entering && node.Type == NODE_HEADER {
+			headings = append(headings, Heading{
+				Level: node.Level,
+				Text:  plainText(node),
+				Slug:  "toc_" + strconv.Itoa(len(headings)),
+			})
+			if node.HeaderID != "" {
+				headings[len(headings)-1].Slug = node.HeaderID
+			}
+			return WALK_SKIP_CHILDREN
+		}
+		return WALK_CONTINUE
+	})
+	return headings
+}
+
+// The reading speed Stats assumes, in words per minute.
+const wordsPerMinute = 200
+
+// Stats counts the words of the prose in a document, parsing it with the
+// extensions used by MarkdownCommon, and estimates how long it takes to
+// read. Code and HTML are left out.
+func Stats(input []byte) (words int, readingTime time.Duration) {
+	words = proseWords(Parse(input, commonExtensions))
+	return words, time.Duration(words) * time.Minute / wordsPerMinute
+}
+
+// Count the words of the prose in node and its descendants, as Stats does.
+func proseWords(node *Node) (words int) {
+	var text bytes.Buffer
+	node.Walk(func(node *Node, entering bool) int {
+		if !entering {
+			return WALK_CONTINUE
+		}
+		switch node.Type {
+		case NODE_BLOCK_CODE, NODE_CODE_SPAN, NODE_BLOCK_HTML, NODE_RAW_HTML_TAG, NODE_IMAGE:
+			return WALK_SKIP_CHILDREN
+		case NODE_TEXT:
+			text.Write(node.Literal)
+		case NODE_ENTITY:
+			text.WriteString(html.UnescapeString(string(node.Literal)))
+		case NODE_AUTO_LINK:
+			text.Write(node.Destination)
+		default:
+			// keep the text of separate blocks and cells apart
+			text.WriteByte(' ')
+		}
+		return WALK_CONTINUE
+	})
+
+	for _, field := range strings.Fields(text.String()) {
+		// a word has at least one letter or digit, so a lone dash is not one
+		if strings.IndexFunc(field, func(r rune) bool {
+			return unicode.IsLetter(r) || unicode.IsDigit(r)
+		}) >= 0 {
+			words++
+		}
+	}
+	return words
+}
+
+// ExcerptOptions says where Excerpt ends. The units are top-level blocks:
+// each paragraph, list, code block, table, and so on counts as one, while
+// headers and horizontal rules do not count. A block is never cut in two.
+type ExcerptOptions struct {
+	Blocks   int    // the most blocks to keep, or 0 for no limit
+	Words    int    // end with the block that reaches this many words, or 0 for no limit
+	Ellipsis string // ends an excerpt that leaves part of the document out; "…" if empty
+}
+
+// Excerpt renders the lead of a document, for a listing of articles, with
+// the extensions and HTML renderer used by MarkdownCommon. The excerpt
+// ends at a <!-- more --> comment written as a block of its own, or else
+// where opts says; the whole document is rendered if neither cuts it
+// short. Headers and horizontal rules left at the end of an excerpt are
+// dropped, so that it does not end with a title for text it leaves out.
+func Excerpt(input []byte, opts ExcerptOptions) []byte {
+	document := Parse(input, commonExtensions)
+	var blocks []*Node
+	cut := false
+	for i, child := range document.Children {
+		if child.Type == NODE_BLOCK_HTML && isMoreMarker(child.Literal) {
+			blocks, cut = document.Children[:i], true
+			break
+		}
+	}
+	if !cut {
+		count, words := 0, 0
+		for i, child := range document.Children {
+			blocks = append(blocks, child)
+			if child.Type == NODE_HEADER || child.Type == NODE_HRULE {
+				continue
+			}
+			count++
+			words += proseWords(child)
+			if (opts.Blocks > 0 && count >= opts.Blocks) || (opts.Words > 0 && words >= opts.Words) {
+				cut = i+1 < len(document.Children)
+				break
+			}
+		}
+	}
+	for len(blocks) > 0 && (blocks[len(blocks)-1].Type == NODE_HEADER || blocks[len(blocks)-1].Type == NODE_HRULE) {
+		blocks = blocks[:len(blocks)-1]
+	}
+
+	if cut && len(blocks) > 0 {
+		ellipsis := &Node{Type: NODE_TEXT, Literal: []byte(opts.Ellipsis)}
+		if opts.Ellipsis == "" {
+			ellipsis.Literal = []byte("…")
+		}
+		if last := blocks[len(blocks)-1]; last.Type == NODE_PARAGRAPH {
+			ellipsis.Literal = append([]byte(" "), ellipsis.Literal...)
+			last.appendChild(ellipsis)
+		} else {
+			blocks = append(blocks, &Node{Type: NODE_PARAGRAPH, Children: []*Node{ellipsis}})
+		}
+	}
+	return Render(&Node{Type: NODE_DOCUMENT, Children: blocks}, commonHtmlRenderer())
+}
+
+// Check whether text is the comment <!-- more -->, with any spacing.
+func isMoreMarker(text []byte) bool {
+	text = bytes.TrimSpace(text)
+	if !bytes.HasPrefix(text, []byte("<!--")) || !bytes.HasSuffix(text, []byte("-->")) || len(text) < len("<!---->") {
+		return false
+	}
+	return string(bytes.TrimSpace(text[len("<!--"):len(text)-len("-->")])) == "more"
+}
+
+// The text of the descendants of a node, without markup.
+func plainText(node *Node) string {
+	var text bytes.Buffer
+	node.Walk(func(node *Node, entering bool) int {
+		if entering {
+			switch node.Type {
+			case NODE_TEXT, NODE_CODE_SPAN, NODE_IMAGE:
+				text.Write(node.Literal)
+			case NODE_ENTITY:
+				text.WriteString(html.UnescapeString(string(node.Literal)))
+			}
+		}
+		return WALK_CONTINUE
+	})
+	return text.String()
+}
+
+func renderChildren(out *bytes.Buffer, node *Node, r Renderer) {
+	for _, child := range node.Children {
+		renderNode(out, child, r)
+	}
+}
+
+// Render the children of a node into a buffer of their own.
+func renderContent(node *Node, r Renderer) []byte {
+	var buf bytes.Buffer
+	renderChildren(&buf, node, r)
+	return buf.Bytes()
+}
+
+func renderNode(out *bytes.Buffer, node *Node, r Renderer) {
+	text := func() bool {
+		renderChildren(out, node, r)
+		return true
+	}
+
+	switch node.Type {
+	case NODE_BLOCK_CODE:
+		r.BlockCode(out, node.Literal, node.Info)
+	case NODE_BLOCK_QUOTE:
+		r.BlockQuote(out, renderContent(node, r))
+	case NODE_ALERT:
+		r.Alert(out, renderContent(node, r), node.Flags)
+	case NODE_BLOCK_HTML:
+		r.BlockHtml(out, node.Literal)
+	case NODE_HEADER:
+		r.Header(out, text, node.Level, node.HeaderID)
+	case NODE_HRULE:
+		r.HRule(out)
+	case NODE_LIST:
+		r.List(out, text, node.Flags)
+	case NODE_LIST_ITEM:
+		// like the parser, strip trailing newlines
+		r.ListItem(out, bytes.TrimRight(renderContent(node, r), "\n"), node.Flags, node.Index, node.Level)
+	case NODE_PARAGRAPH:
+		r.Paragraph(out, text)
+	case NODE_TABLE:
+		var header, body []byte
+		for _, child := range node.Children {
+			if child.Type == NODE_TABLE_HEAD {
+				header = renderContent(child, r)
+			} else {
+				body = renderContent(child, r)
+			}
+		}
+		r.Table(out, header, body, node.Columns)
+	case NODE_TABLE_ROW:
+		r.TableRow(out, renderContent(node, r))
+	case NODE_TABLE_HEADER_CELL:
+		r.TableHeaderCell(out, renderContent(node, r), node.Flags)
+	case NODE_TABLE_CELL:
+		r.TableCell(out, renderContent(node, r), node.Flags)
+	case NODE_FOOTNOTES:
+		r.Footnotes(out, text)
+	case NODE_FOOTNOTE_ITEM:
+		r.FootnoteItem(out, node.Literal, renderContent(node, r), node.Flags)
+	case NODE_DETAILS:
+		var summary []byte
+		var body bytes.Buffer
+		for _, child := range node.Children {
+			if child.Type == NODE_DETAILS_SUMMARY {
+				summary = renderContent(child, r)
+			} else {
+				renderNode(&body, child, r)
+			}
+		}
+		r.Details(out, summary, body.Bytes(), node.Flags)
+	case NODE_DIRECTIVE:
+		r.Directive(out, node.Info, node.Attrs, renderContent(node, r))
+	case NODE_AUTO_LINK:
+		r.AutoLink(out, node.Destination, node.Flags)
+	case NODE_CODE_SPAN:
+		r.CodeSpan(out, node.Literal)
+	case NODE_DOUBLE_EMPHASIS:
+		r.DoubleEmphasis(out, renderContent(node, r))
+	case NODE_EMPHASIS:
+		r.Emphasis(out, renderContent(node, r))
+	case NODE_IMAGE:
+		r.Image(out, node.Destination, node.Title, node.Literal)
+	case NODE_LINE_BREAK:
+		r.LineBreak(out)
+	case NODE_LINK:
+		r.Link(out, node.Destination, node.Title, renderContent(node, r))
+	case NODE_RAW_HTML_TAG:
+		r.RawHtmlTag(out, node.Literal)
+	case NODE_TRIPLE_EMPHASIS:
+		r.TripleEmphasis(out, renderContent(node, r))
+	case NODE_STRIKETHROUGH:
+		r.StrikeThrough(out, renderContent(node, r))
+	case NODE_CRITIC:
+		var text bytes.Buffer
+		var replacement []byte
+		for _, child := range node.Children {
+			if child.Type == NODE_CRITIC_REPLACEMENT {
+				replacement = renderContent(child, r)
+			} else {
+				renderNode(&text, child, r)
+			}
+		}
+		r.CriticMarkup(out, text.Bytes(), replacement, node.Flags)
+	case NODE_TIME:
+		r.Time(out, node.Literal)
+	case NODE_FOOTNOTE_REF:
+		r.FootnoteRef(out, node.Literal, node.Index)
+	case NODE_ENTITY:
+		r.Entity(out, node.Literal)
+	case NODE_TEXT:
+		r.NormalText(out, node.Literal)
+	default:
+		renderChildren(out, node, r)
+	}
+}
+
+// nodeBuilder is the Renderer behind Parse. Each callback records a node and
+// writes a token referring to it, so the content a parent receives can be
+// decoded back into its children. Text is written as is, since the parser
+// edits text it has already output, after an empty token that keeps each
+// NormalText call a node of its own.
+type nodeBuilder struct {
+	nodes []*Node
+}
+
+const nodeToken = '\x00'
+
+func (b *nodeBuilder) add(out *bytes.Buffer, node *Node) *Node {
+	b.nodes = append(b.nodes, node)
+	out.WriteByte(nodeToken)
+	out.WriteString(strconv.Itoa(len(b.nodes) - 1))
+	out.WriteByte(nodeToken)
+	return node
+}
+
+// Decode rendered content into nodes.
+func (b *nodeBuilder) children(content []byte) []*Node {
+	var nodes []*Node
+	for len(content) > 0 {
+		if content[0] == nodeToken {
+			end := bytes.IndexByte(content[1:], nodeToken) + 1
+			if end > 1 {
+				index, _ := strconv.Atoi(string(content[1:end]))
+				nodes = append(nodes, b.nodes[index])
+			}
+			content = content[end+1:]
+			continue
+		}
+
+		end := bytes.IndexByte(content, nodeToken)
+		if end < 0 {
+			end = len(content)
+		}
+		text := append([]byte(nil), content[:end]...)
+		nodes = append(nodes, &Node{Type: NODE_TEXT, Literal: text})
+		content = content[end:]
+	}
+	return nodes
+}
+
+// Add a node holding the decoded content as its children.
+func (b *nodeBuilder) addParent(out *bytes.Buffer, node *Node, content []byte) *Node {
+	for _, child := range b.children(content) {
+		node.appendChild(child)
+	}
+	return b.add(out, node)
+}
+
+// Add a node holding what text writes to out as its children.
+func (b *nodeBuilder) addCallback(out *bytes.Buffer, node *Node, text func() bool) {
+	marker := out.Len()
+	ok := text()
+	content := append([]byte(nil), out.Bytes()[marker:]...)
+	out.Truncate(marker)
+	if ok {
+		b.addParent(out, node, content)
+	}
+}
+
+func (b *nodeBuilder) BlockCode(out *bytes.Buffer, text []byte, info string) {
+	b.add(out, &Node{Type: NODE_BLOCK_CODE, Literal: copyBytes(text), Info: info})
+}
+
+func (b *nodeBuilder) BlockQuote(out *bytes.Buffer, text []byte) {
+	b.addParent(out, &Node{Type: NODE_BLOCK_QUOTE}, text)
+}
+
+func (b *nodeBuilder) Alert(out *bytes.Buffer, text []byte, kind int) {
+	b.addParent(out, &Node{Type: NODE_ALERT, Flags: kind}, text)
+}
+
+func (b *nodeBuilder) BlockHtml(out *bytes.Buffer, text []byte) {
+	b.add(out, &Node{Type: NODE_BLOCK_HTML, Literal: copyBytes(text)})
+}
+
+func (b *nodeBuilder) Header(out *bytes.Buffer, text func() bool, level int, id string) {
+	b.addCallback(out, &Node{Type: NODE_HEADER, Level: level, HeaderID: id}, text)
+}
+
+func (b *nodeBuilder) HRule(out *bytes.Buffer) {
+	b.add(out, &Node{Type: NODE_HRULE})
+}
+
+func (b *nodeBuilder) List(out *bytes.Buffer, text func() bool, flags int) {
+	b.addCallback(out, &Node{Type: NODE_LIST, Flags: flags}, text)
+}
+
+func (b *nodeBuilder) ListItem(out *bytes.Buffer, text []byte, flags, index, level int) {
+	b.addParent(out, &Node{Type: NODE_LIST_ITEM, Flags: flags, Index: index, Level: level}, text)
+}
+
+func (b *nodeBuilder) Paragraph(out *bytes.Buffer, text func() bool) {
+	b.addCallback(out, &Node{Type: NODE_PARAGRAPH}, text)
+}
+
+func (b *nodeBuilder) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int) {
+	var parts bytes.Buffer
+	b.addParent(&parts, &Node{Type: NODE_TABLE_HEAD}, header)
+	b.addParent(&parts, &Node{Type: NODE_TABLE_BODY}, body)
+	columns := append([]int(nil), columnData...)
+	b.addParent(out, &Node{Type: NODE_TABLE, Columns: columns}, parts.Bytes())
+}
+
+func (b *nodeBuilder) TableRow(out *bytes.Buffer, text []byte) {
+	b.addParent(out, &Node{Type: NODE_TABLE_ROW}, text)
+}
+
+func (b *nodeBuilder) TableHeaderCell(out *bytes.Buffer, text []byte, flags int) {
+	b.addParent(out, &Node{Type: NODE_TABLE_HEADER_CELL, Flags: flags}, text)
+}
+
+func (b *nodeBuilder) TableCell(out *bytes.Buffer, text []byte, flags int) {
+	b.addParent(out, &Node{Type: NODE_TABLE_CELL, Flags: flags}, text)
+}
+
+func (b *nodeBuilder) Footnotes(out *bytes.Buffer, text func() bool) {
+	b.addCallback(out, &Node{Type: NODE_FOOTNOTES}, text)
+}
+
+func (b *nodeBuilder) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	b.addParent(out, &Node{Type: NODE_FOOTNOTE_ITEM, Literal: copyBytes(name), Flags: flags}, text)
+}
+
+func (b *nodeBuilder) Details(out *bytes.Buffer, summary, text []byte, flags int) {
+	var parts bytes.Buffer
+	b.addParent(&parts, &Node{Type: NODE_DETAILS_SUMMARY}, summary)
+	parts.Write(text)
+	b.addParent(out, &Node{Type: NODE_DETAILS, Flags: flags}, parts.Bytes())
+}
+
+func (b *nodeBuilder) Directive(out *bytes.Buffer, name string, attrs []DirectiveAttr, text []byte) {
+	b.addParent(out, &Node{Type: NODE_DIRECTIVE, Info: name, Attrs: attrs}, text)
+}
+
+func (b *nodeBuilder) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	b.add(out, &Node{Type: NODE_AUTO_LINK, Destination: copyBytes(link), Flags: kind})
+}
+
+func (b *nodeBuilder) CodeSpan(out *bytes.Buffer, text []byte) {
+	b.add(out, &Node{Type: NODE_CODE_SPAN, Literal: copyBytes(text)})
+}
+
+func (b *nodeBuilder) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	b.addParent(out, &Node{Type: NODE_DOUBLE_EMPHASIS}, text)
+}
+
+func (b *nodeBuilder) Emphasis(out *bytes.Buffer, text []byte) {
+	b.addParent(out, &Node{Type: NODE_EMPHASIS}, text)
+}
+
+func (b *nodeBuilder) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+	b.add(out, &Node{
+		Type:        NODE_IMAGE,
+		Destination: copyBytes(link),
+		Title:       copyBytes(title),
+		Literal:     copyBytes(alt),
+	})
+}
+
+func (b *nodeBuilder) LineBreak(out *bytes.Buffer) {
+	b.add(out, &Node{Type: NODE_LINE_BREAK})
+}
+
+func (b *nodeBuilder) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	node := &Node{Type: NODE_LINK, Destination: copyBytes(link), Title: copyBytes(title)}
+	b.addParent(out, node, content)
+}
+
+func (b *nodeBuilder) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+	b.add(out, &Node{Type: NODE_RAW_HTML_TAG, Literal: copyBytes(tag)})
+}
+
+func (b *nodeBuilder) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	b.addParent(out, &Node{Type: NODE_TRIPLE_EMPHASIS}, text)
+}
+
+func (b *nodeBuilder) StrikeThrough(out *bytes.Buffer, text []byte) {
+	b.addParent(out, &Node{Type: NODE_STRIKETHROUGH}, text)
+}
+
+func (b *nodeBuilder) CriticMarkup(out *bytes.Buffer, text, replacement []byte, kind int) {
+	parts := bytes.NewBuffer(append([]byte(nil), text...))
+	if kind == CRITIC_SUBSTITUTION {
+		b.addParent(parts, &Node{Type: NODE_CRITIC_REPLACEMENT}, replacement)
+	}
+	b.addParent(out, &Node{Type: NODE_CRITIC, Flags: kind}, parts.Bytes())
+}
+
+func (b *nodeBuilder) Time(out *bytes.Buffer, datetime []byte) {
+	b.add(out, &Node{Type: NODE_TIME, Literal: copyBytes(datetime)})
+}
+
+func (b *nodeBuilder) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	b.add(out, &Node{Type: NODE_FOOTNOTE_REF, Literal: copyBytes(ref), Index: id})
+}
+
+func (b *nodeBuilder) Entity(out *bytes.Buffer, entity []byte) {
+	b.add(out, &Node{Type: NODE_ENTITY, Literal: copyBytes(entity)})
+}
+
+func (b *nodeBuilder) NormalText(out *bytes.Buffer, text []byte) {
+	if len(text) == 0 {
+		return
+	}
+	out.WriteByte(nodeToken)
+	out.WriteByte(nodeToken)
+	out.Write(bytes.Replace(text, []byte{nodeToken}, []byte("\uFFFD"), -1))
+}
+
+func (b *nodeBuilder) DocumentHeader(out *bytes.Buffer) {
+}
+
+func (b *nodeBuilder) DocumentFooter(out *bytes.Buffer) {
+}
+
+func copyBytes(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	return append([]byte{}, data...)
+}
@@ -0,0 +1,294 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for the document tree
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var nodeNames = []string{
+	"Document", "BlockCode", "BlockQuote", "Alert", "BlockHtml", "Header", "HRule",
+	"List", "ListItem", "Paragraph", "Table", "TableHead", "TableBody",
+	"TableRow", "TableHeaderCell", "TableCell", "Footnotes", "FootnoteItem",
+	"Details", "DetailsSummary", "Directive", "AutoLink", "CodeSpan", "DoubleEmphasis", "Emphasis", "Image",
+	"LineBreak", "Link", "RawHtmlTag", "TripleEmphasis", "StrikeThrough", "Critic", "CriticReplacement",
+	"Time", "FootnoteRef", "Entity", "Text",
+}
+
+// Describe a tree as nested node names, with the literal text of leaves.
+func dumpNode(node *Node) string {
+	var out bytes.Buffer
+	node.Walk(func(node *Node, entering bool) int {
+		switch {
+		case !entering:
+			out.WriteString(")")
+		case node.Literal != nil && len(node.Children) == 0:
+			fmt.Fprintf(&out, "%s%q", nodeNames[node.Type], node.Literal)
+			return WALK_SKIP_CHILDREN
+		default:
+			out.WriteString(nodeNames[node.Type] + "(")
+		}
+		return WALK_CONTINUE
+	})
+	return out.String()
+}
+
+func TestParseTree(t *testing.T) {
+	var tests = []string{
+		"# Title *em*\n\ntext [link](/url) `code`\n",
+		"Document(Header(Text\"Title \"Emphasis(Text\"em\"))" +
+			"Paragraph(Text\"text \"Link(Text\"link\")Text\" \"CodeSpan\"code\"))",
+
+		"> * one\n> * two\n\n---\n",
+		"Document(BlockQuote(List(ListItem(Text\"one\")ListItem(Text\"two\")))HRule())",
+
+		"| a |\n|---|\n| b |\n",
+		"Document(Table(TableHead(TableRow(TableHeaderCell(Text\"a\")))" +
+			"TableBody(TableRow(TableCell(Text\"b\")))))",
+
+		"note[^1]\n\n[^1]: text\n",
+		"Document(Paragraph(Text\"note\"FootnoteRef\"1\")" +
+			"Footnotes(FootnoteItem(Text\"text\"Text\"\\n\")))",
+
+		"a\x00b\n",
+		"Document(Paragraph(Text\"a\uFFFDb\"))",
+
+		"{~~*a*~>b~~}\n",
+		"Document(Paragraph(Critic(Emphasis(Text\"a\")CriticReplacement(Text\"b\"))))",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		actual := dumpNode(Parse([]byte(tests[i]), EXTENSION_TABLES|EXTENSION_FOOTNOTES|EXTENSION_CRITIC_MARKUP))
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				tests[i], tests[i+1], actual)
+		}
+	}
+}
+
+func TestWalkStop(t *testing.T) {
+	document := Parse([]byte("one\n\ntwo\n\nthree\n"), 0)
+	var texts []string
+	document.Walk(func(node *Node, entering bool) int {
+		if entering && node.Type == NODE_TEXT {
+			texts = append(texts, string(node.Literal))
+			if len(texts) == 2 {
+				return WALK_STOP
+			}
+		}
+		return WALK_CONTINUE
+	})
+	if len(texts) != 2 || texts[1] != "two" {
+		t.Errorf("Expected the walk to stop at [two], visited %q", texts)
+	}
+}
+
+func TestListItemNumbers(t *testing.T) {
+	document := Parse([]byte("1. a\n2. b\n    1. c\n    2. d\n        * e\n3. f\n\n> * g\n"), 0)
+	var items []string
+	document.Walk(func(node *Node, entering bool) int {
+		if entering && node.Type == NODE_LIST_ITEM {
+			items = append(items, fmt.Sprintf("%d.%d", node.Level, node.Index))
+		}
+		return WALK_CONTINUE
+	})
+	expected := "[1.1 1.2 2.1 2.2 3.1 1.3 1.1]"
+	if actual := fmt.Sprint(items); actual != expected {
+		t.Errorf("Expected list items at %s, got %s", expected, actual)
+	}
+}
+
+func TestRenderReference(t *testing.T) {
+	extensions := EXTENSION_TABLES | EXTENSION_FENCED_CODE | EXTENSION_AUTOLINK |
+		EXTENSION_STRIKETHROUGH | EXTENSION_FOOTNOTES
+	files, _ := filepath.Glob(filepath.Join("upskirtref", "*.text"))
+	for _, filename := range files {
+		input, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Errorf("Couldn't open '%s', error: %v\n", filename, err)
+			continue
+		}
+
+		// rendering the tree must match rendering the input directly
+		for _, renderer := range []func() Renderer{
+			func() Renderer {
+				return HtmlRenderer(HTML_USE_XHTML|HTML_USE_SMARTYPANTS|HTML_TOC, "", "")
+			},
+			func() Renderer { return LatexRenderer(0) },
+			func() Renderer { return MarkdownRenderer(0) },
+		} {
+			expected := Markdown(input, renderer(), extensions)
+			actual := Render(Parse(input, extensions), renderer())
+			if !bytes.Equal(actual, expected) {
+				t.Errorf("\n    [%#v]\nExpected[%#v]\nActual  [%#v]",
+					filename, string(expected), string(actual))
+			}
+		}
+	}
+}
+
+func TestRenderSmartypants(t *testing.T) {
+	// smartypants looks at the text of each NormalText call on its own, so
+	// the tree must not join text the parser passed in separate calls
+	var tests = []string{
+		"a ``` b\n",
+		"-[x]\n",
+		"\"quoted\" and 'single' -- dash...\n",
+		"it's *\"em\"* \"`code`\"\n",
+		"1/2 ![\"alt\"](/img) \"see http://example.com\"\n",
+		"trailing  \n\"break\"\n",
+	}
+	extensions := EXTENSION_AUTOLINK | EXTENSION_STRIKETHROUGH | EXTENSION_TASK_LISTS
+	for _, input := range tests {
+		renderer := func() Renderer {
+			return HtmlRenderer(HTML_USE_SMARTYPANTS|HTML_SMARTYPANTS_FRACTIONS, "", "")
+		}
+		expected := Markdown([]byte(input), renderer(), extensions)
+		actual := Render(Parse([]byte(input), extensions), renderer())
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				input, string(expected), string(actual))
+		}
+	}
+}
+
+func TestRenderSection(t *testing.T) {
+	input := "Intro [link].\n\n<!-- begin:usage -->\n\nUse [link].\n\n<!-- begin:inner -->\n\n" +
+		"    code\n\n<!-- end:inner -->\n\n<!-- end:usage -->\n\nMiddle.\n\n" +
+		"> <!-- begin:usage -->\n>\n> Quoted.\n\n<!-- begin:rest -->\n\nRest.\n\n[link]: /url\n"
+	var tests = []struct {
+		name     string
+		expected string
+	}{
+		{"usage", "<p>Use <a href=\"/url\">link</a>.</p>\n\n<pre><code>code\n</code></pre>\n\n<p>Quoted.</p>\n"},
+		{"inner", "<pre><code>code\n</code></pre>\n"},
+		{"rest", "<p>Rest.</p>\n"},
+		{"missing", ""},
+	}
+	document := Parse([]byte(input), 0)
+	for _, test := range tests {
+		actual := RenderSection(document, HtmlRenderer(0, "", ""), test.name)
+		if string(actual) != test.expected {
+			t.Errorf("\nSection [%s]\nExpected[%#v]\nActual  [%#v]", test.name, test.expected, string(actual))
+		}
+		if test.expected == "" && actual != nil {
+			t.Errorf("missing section %s rendered as %#v", test.name, string(actual))
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	var tests = []struct {
+		input string
+		words int
+	}{
+		{"", 0},
+		{"One two three.\n", 3},
+		{"# Title\n\nSome *emphasized* text -- and a [link](http://x.com/).\n", 7},
+		{"Call `fmt.Println(x)` now.\n\n```go\nfunc main() {}\n```\n\n    indented code\n", 2},
+		{"<div>\nraw html words\n</div>\n\nText <b>bold</b> here.\n", 3},
+		{"* one\n* two\n\n| a | b |\n|---|---|\n| c | d |\n", 6},
+		{"cats&amp;dogs ![alt text](x.png) end\n", 2},
+	}
+	for _, test := range tests {
+		words, readingTime := Stats([]byte(test.input))
+		if words != test.words {
+			t.Errorf("\nInput   [%#v]\nExpected[%d words]\nActual  [%d words]", test.input, test.words, words)
+		}
+		if expected := time.Duration(test.words) * time.Minute / 200; readingTime != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%v]\nActual  [%v]", test.input, expected, readingTime)
+		}
+	}
+
+	// a long article
+	words, readingTime := Stats(bytes.Repeat([]byte("word "), 1000))
+	if words != 1000 || readingTime != 5*time.Minute {
+		t.Errorf("1000 words: got %d words, %v", words, readingTime)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	input := "# Title\n\nFirst paragraph with five words.\n\n## Code\n\n```\ncode here\n```\n\n" +
+		"Second paragraph.\n\n## Later\n\nThird.\n"
+	var tests = []struct {
+		opts     ExcerptOptions
+		expected string
+	}{
+		{ExcerptOptions{Blocks: 1}, "<h1>Title</h1>\n\n<p>First paragraph with five words. …</p>\n"},
+		{ExcerptOptions{Blocks: 2, Ellipsis: "[more]"}, "<h1>Title</h1>\n\n<p>First paragraph with five words.</p>\n\n" +
+			"<h2>Code</h2>\n\n<pre><code>code here\n</code></pre>\n\n<p>[more]</p>\n"},
+
+		// the block that reaches the limit is kept whole
+		{ExcerptOptions{Words: 3}, "<h1>Title</h1>\n\n<p>First paragraph with five words. …</p>\n"},
+		{ExcerptOptions{Words: 7}, "<h1>Title</h1>\n\n<p>First paragraph with five words.</p>\n\n" +
+			"<h2>Code</h2>\n\n<pre><code>code here\n</code></pre>\n\n<p>Second paragraph. …</p>\n"},
+		{ExcerptOptions{Blocks: 1, Words: 100}, "<h1>Title</h1>\n\n<p>First paragraph with five words. …</p>\n"},
+
+		// nothing left out
+		{ExcerptOptions{Blocks: 4}, string(MarkdownCommon([]byte(input)))},
+		{ExcerptOptions{}, string(MarkdownCommon([]byte(input)))},
+	}
+	for _, test := range tests {
+		if actual := string(Excerpt([]byte(input), test.opts)); actual != test.expected {
+			t.Errorf("\nOptions [%+v]\nExpected[%#v]\nActual  [%#v]", test.opts, test.expected, actual)
+		}
+	}
+
+	// the more marker takes precedence, and a header before it is dropped
+	input = "Lead.\n\n## Next\n\n<!-- more -->\n\nRest.\n"
+	expected := "<p>Lead. …</p>\n"
+	for _, opts := range []ExcerptOptions{{}, {Blocks: 5}, {Words: 1}} {
+		if actual := string(Excerpt([]byte(input), opts)); actual != expected {
+			t.Errorf("\nOptions [%+v]\nExpected[%#v]\nActual  [%#v]", opts, expected, actual)
+		}
+	}
+}
+
+func TestHeadings(t *testing.T) {
+	input := "# Title *em* & `code`\n\ntext\n\nSub {#sub}\n---\n\n> ### Quoted\n\n#no space\n"
+	var tests = []struct {
+		extensions int
+		expected   []Heading
+	}{
+		{commonExtensions | EXTENSION_HEADER_IDS, []Heading{
+			{1, "Title em & code", "toc_0"},
+			{2, "Sub", "sub"},
+			{3, "Quoted", "toc_2"},
+		}},
+		{0, []Heading{
+			{1, "Title em & code", "toc_0"},
+			{2, "Sub {#sub}", "toc_1"},
+			{3, "Quoted", "toc_2"},
+			{1, "no space", "toc_3"},
+		}},
+	}
+	for _, test := range tests {
+		actual := Headings([]byte(input), test.extensions)
+		if fmt.Sprint(actual) != fmt.Sprint(test.expected) {
+			t.Errorf("\nInput   [%#v]\nExpected[%v]\nActual  [%v]", input, test.expected, actual)
+		}
+
+		// the slugs are the ids of the headers in the table of contents
+		output := Markdown([]byte(input), HtmlRenderer(HTML_TOC, "", ""), test.extensions)
+		for _, heading := range actual {
+			if !bytes.Contains(output, []byte("<h"+fmt.Sprint(heading.Level)+" id=\""+heading.Slug+"\">")) {
+				t.Errorf("slug %q is not the id of its header:\n%s", heading.Slug, output)
+			}
+		}
+	}
+}
@@ -0,0 +1,770 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Markdown rendering backend
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// MarkdownPrinter is a type that implements the Renderer interface for
+// normalized Markdown output.
+//
+// The output uses a single canonical form for each construct: prefixed
+// headers, * for list items and emphasis, fenced code only when a language
+// is given, inline links, and escaped punctuation wherever it could
+// otherwise be mistaken for markup. Rendering the output again with the same
+// extensions yields the same text.
+//
+// Do not create this directly, instead use the MarkdownRenderer function.
+type MarkdownPrinter struct {
+	wrapWidth int // column to wrap paragraph text at, or 0 not to wrap
+
+	// where the most recent text ending in a newline ended
+	lastText    *bytes.Buffer
+	lastTextEnd int
+
+	// a character ending the most recent text that needs escaping only
+	// if the text after it continues an entity, autolink, or CriticMarkup
+	// edit
+	pending    byte
+	pendingOut *bytes.Buffer
+	pendingEnd int
+}
+
+// MarkdownRenderer creates and configures a MarkdownPrinter object, which
+// satisfies the Renderer interface.
+//
+// flags is a set of MARKDOWN_* options ORed together (currently no such
+// options are defined).
+func MarkdownRenderer(flags int) Renderer {
+	return &MarkdownPrinter{}
+}
+
+// SetWrapWidth makes the renderer break the lines of paragraphs and of
+// the text of tight list items and footnotes at spaces so they fit within
+// width columns where possible, counting the markers of enclosing blocks.
+// Links and code spans are never broken, and no line is made to start
+// with text that would be taken for block markup. With width <= 0, the
+// default, lines are kept as they are in the input.
+func (options *MarkdownPrinter) SetWrapWidth(width int) {
+	options.wrapWidth = width
+}
+
+// the indentation of list item and footnote continuation lines
+const printerIndent = "    "
+
+func (options *MarkdownPrinter) BlockCode(out *bytes.Buffer, text []byte, info string) {
+	options.blockStart(out)
+	text = stripPrinterMarks(text)
+	info = string(stripPrinterMarks([]byte(info)))
+
+	// without an info string, an indented block needs no extensions
+	if info == "" {
+		writePrefixedLines(out, text, printerIndent, printerIndent)
+		return
+	}
+
+	// the fence must not match any line inside the block
+	fence := 3
+	for _, line := range bytes.Split(text, []byte("\n")) {
+		n := 0
+		for n < len(line) && line[n] == '`' {
+			n++
+		}
+		if n >= fence {
+			fence = n + 1
+		}
+	}
+
+	out.WriteString(strings.Repeat("`", fence))
+	out.WriteByte(' ')
+	out.WriteString(info)
+	out.WriteByte('\n')
+	out.Write(text)
+	out.WriteString(strings.Repeat("`", fence))
+	out.WriteByte('\n')
+}
+
+func (options *MarkdownPrinter) BlockQuote(out *bytes.Buffer, text []byte) {
+	options.blockStart(out)
+	writePrefixedLines(out, bytes.TrimRight(text, "\n"), "> ", "> ")
+}
+
+func (options *MarkdownPrinter) Alert(out *bytes.Buffer, text []byte, kind int) {
+	options.blockStart(out)
+	out.WriteString("> [!" + alertTypes[kind] + "]\n")
+	if text = bytes.TrimRight(text, "\n"); len(text) > 0 {
+		writePrefixedLines(out, text, "> ", "> ")
+	}
+}
+
+// Return a fence for a container of text, which must not match the fence
+// of any container inside it.
+func containerFence(text []byte) string {
+	fence := 3
+	for _, line := range bytes.Split(text, []byte("\n")) {
+		n := 0
+		for n < len(line) && line[n] == ':' {
+			n++
+		}
+		if n >= fence {
+			fence = n + 1
+		}
+	}
+	return strings.Repeat(":", fence)
+}
+
+func (options *MarkdownPrinter) Details(out *bytes.Buffer, summary, text []byte, flags int) {
+	options.blockStart(out)
+
+	fence := containerFence(text)
+	out.WriteString(fence)
+	out.WriteString(" details")
+	if flags&DETAILS_OPEN != 0 {
+		out.WriteString(" open")
+	}
+	if len(summary) > 0 {
+		out.WriteByte(' ')
+		out.Write(summary)
+	}
+	out.WriteByte('\n')
+	if text = bytes.TrimRight(text, "\n"); len(text) > 0 {
+		out.Write(text)
+		out.WriteByte('\n')
+	}
+	out.WriteString(fence)
+	out.WriteByte('\n')
+}
+
+func (options *MarkdownPrinter) Directive(out *bytes.Buffer, name string, attrs []DirectiveAttr, text []byte) {
+	options.blockStart(out)
+
+	fence := containerFence(text)
+	out.WriteString(fence)
+	out.WriteByte(' ')
+	out.WriteString(name)
+	if len(attrs) > 0 {
+		out.WriteString(" {")
+		for i, attr := range attrs {
+			if i > 0 {
+				out.WriteByte(' ')
+			}
+			switch {
+			case attr.Key == "id" && !strings.ContainsAny(attr.Value, " \"'="):
+				out.WriteByte('#')
+				out.WriteString(attr.Value)
+			case attr.Key == "class" && !strings.ContainsAny(attr.Value, " \"'="):
+				out.WriteByte('.')
+				out.WriteString(attr.Value)
+			default:
+				out.WriteString(attr.Key)
+				out.WriteString("=\"")
+				out.WriteString(attr.Value)
+				out.WriteByte('"')
+			}
+		}
+		out.WriteByte('}')
+	}
+	out.WriteByte('\n')
+	if text = bytes.TrimRight(text, "\n"); len(text) > 0 {
+		out.Write(text)
+		out.WriteByte('\n')
+	}
+	out.WriteString(fence)
+	out.WriteByte('\n')
+}
+
+func (options *MarkdownPrinter) BlockHtml(out *bytes.Buffer, text []byte) {
+	options.blockStart(out)
+	out.Write(stripPrinterMarks(text))
+	out.WriteByte('\n')
+}
+
+func (options *MarkdownPrinter) Header(out *bytes.Buffer, text func() bool, level int, id string) {
+	marker := out.Len()
+	options.blockStart(out)
+
+	out.WriteString(strings.Repeat("#", level))
+	out.WriteByte(' ')
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+
+	// a closing sequence keeps trailing #s in the header text
+	if bytes.HasSuffix(out.Bytes(), []byte("#")) {
+		out.WriteString(" #")
+	}
+	if id != "" {
+		out.WriteString(" {#")
+		out.WriteString(id)
+		out.WriteByte('}')
+	}
+	out.WriteByte('\n')
+}
+
+func (options *MarkdownPrinter) HRule(out *bytes.Buffer) {
+	options.blockStart(out)
+	out.WriteString("---\n")
+}
+
+func (options *MarkdownPrinter) List(out *bytes.Buffer, text func() bool, flags int) {
+	marker := out.Len()
+	options.blockStart(out)
+
+	if !text() {
+		out.Truncate(marker)
+	}
+}
+
+func (options *MarkdownPrinter) ListItem(out *bytes.Buffer, text []byte, flags, index, level int) {
+	if flags&LIST_ITEM_CONTAINS_BLOCK != 0 && flags&LIST_ITEM_BEGINNING_OF_LIST == 0 {
+		out.WriteByte('\n')
+	}
+
+	itemMarker := "*"
+	if flags&LIST_TYPE_ORDERED != 0 {
+		itemMarker = listOrdinal(index, flags) + "."
+	}
+	if len(itemMarker) < len(printerIndent) {
+		itemMarker += printerIndent[len(itemMarker):]
+	} else {
+		itemMarker += " "
+	}
+
+	if options.wrapWidth > 0 && flags&LIST_ITEM_CONTAINS_BLOCK == 0 {
+		text = markInlineLine(text)
+	}
+	if flags&LIST_ITEM_TASK != 0 {
+		// the checkbox goes after any wrap mark, so that it wraps with the text
+		box := "[ ] "
+		if flags&LIST_ITEM_TASK_DONE != 0 {
+			box = "[x] "
+		}
+		i := 0
+		if len(text) > 0 && text[0] == wrapMark {
+			i = 1
+		}
+		text = append(append(append([]byte(nil), text[:i]...), box...), text[i:]...)
+	}
+	writePrefixedLines(out, text, itemMarker, printerIndent)
+}
+
+func (options *MarkdownPrinter) Paragraph(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	options.blockStart(out)
+
+	if options.wrapWidth > 0 {
+		out.WriteByte(wrapMark)
+	}
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteByte('\n')
+}
+
+// starts a table cell, followed by the cell's alignment as a digit
+const printerAlignMark = '\x1c'
+
+// Test if c is one of the marks the printer writes, for wrapping or for
+// table cells, which must not be taken from the input.
+func isPrinterMark(c byte) bool {
+	return isMark(c) || c == printerAlignMark
+}
+
+// Return text without any of the printer's marks.
+func stripPrinterMarks(text []byte) []byte {
+	return stripBytes(text, isPrinterMark)
+}
+
+func (options *MarkdownPrinter) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int) {
+	options.blockStart(out)
+	writeCellAlignments(out, header, columnData)
+	for _, align := range columnData {
+		switch align {
+		case TABLE_ALIGNMENT_LEFT:
+			out.WriteString("| :--- ")
+		case TABLE_ALIGNMENT_RIGHT:
+			out.WriteString("| ---: ")
+		case TABLE_ALIGNMENT_CENTER:
+			out.WriteString("| :---: ")
+		default:
+			out.WriteString("| --- ")
+		}
+	}
+	out.WriteString("|\n")
+	writeCellAlignments(out, body, columnData)
+}
+
+// Copy table rows, replacing the alignment mark of each cell with an
+// override marker where the cell is aligned differently from its column.
+func writeCellAlignments(out *bytes.Buffer, rows []byte, columnData []int) {
+	col := 0
+	for i := 0; i < len(rows); i++ {
+		switch rows[i] {
+		case '\n':
+			col = 0
+			out.WriteByte('\n')
+		case printerAlignMark:
+			i++
+			align := int(rows[i] - '0')
+			if align != 0 && (col >= len(columnData) || align != columnData[col]) {
+				switch align {
+				case TABLE_ALIGNMENT_LEFT:
+					out.WriteString("{:-} ")
+				case TABLE_ALIGNMENT_RIGHT:
+					out.WriteString("{-:} ")
+				case TABLE_ALIGNMENT_CENTER:
+					out.WriteString("{:-:} ")
+				}
+			}
+			col++
+		default:
+			out.WriteByte(rows[i])
+		}
+	}
+}
+
+func (options *MarkdownPrinter) TableRow(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+	out.WriteString("|\n")
+}
+
+func (options *MarkdownPrinter) TableHeaderCell(out *bytes.Buffer, text []byte, align int) {
+	options.TableCell(out, text, align)
+}
+
+func (options *MarkdownPrinter) TableCell(out *bytes.Buffer, text []byte, align int) {
+	out.WriteString("| ")
+	out.WriteByte(printerAlignMark)
+	out.WriteByte(byte('0' + align))
+	if _, size := cellAlignment(text); size > 0 {
+		out.WriteByte('\\')
+	}
+	out.Write(text)
+	out.WriteByte(' ')
+}
+
+func (options *MarkdownPrinter) Footnotes(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	options.blockStart(out)
+	if !text() {
+		out.Truncate(marker)
+	}
+}
+
+func (options *MarkdownPrinter) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	if flags&LIST_ITEM_BEGINNING_OF_LIST == 0 {
+		out.WriteByte('\n')
+	}
+	if options.wrapWidth > 0 && flags&LIST_ITEM_CONTAINS_BLOCK == 0 {
+		text = markInlineLine(text)
+	}
+	prefix := "[^" + string(name) + "]: "
+	writePrefixedLines(out, bytes.TrimRight(text, "\n"), prefix, printerIndent)
+}
+
+func (options *MarkdownPrinter) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	link = stripPrinterMarks(link)
+	switch kind {
+	case LINK_TYPE_ISSUE:
+		out.WriteByte('#')
+		out.Write(link)
+	case LINK_TYPE_COMMIT:
+		out.Write(link)
+	default:
+		out.WriteByte('<')
+		out.Write(link)
+		out.WriteByte('>')
+	}
+}
+
+func (options *MarkdownPrinter) CodeSpan(out *bytes.Buffer, text []byte) {
+	if text = stripPrinterMarks(text); len(text) == 0 {
+		return
+	}
+	defer options.keepUnbroken(out, out.Len())
+
+	// the delimiter must be longer than any run of backticks in the code
+	delim, run := 1, 0
+	for _, c := range text {
+		if c == '`' {
+			run++
+			if run >= delim {
+				delim = run + 1
+			}
+		} else {
+			run = 0
+		}
+	}
+
+	out.WriteString(strings.Repeat("`", delim))
+	if text[0] == '`' {
+		out.WriteByte(' ')
+	}
+	out.Write(text)
+	if text[len(text)-1] == '`' {
+		out.WriteByte(' ')
+	}
+	out.WriteString(strings.Repeat("`", delim))
+}
+
+func (options *MarkdownPrinter) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("**")
+	out.Write(text)
+	out.WriteString("**")
+}
+
+func (options *MarkdownPrinter) Emphasis(out *bytes.Buffer, text []byte) {
+	if len(text) == 0 {
+		return
+	}
+	out.WriteString("*")
+	out.Write(text)
+	out.WriteString("*")
+}
+
+func (options *MarkdownPrinter) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+	defer options.keepUnbroken(out, out.Len())
+	out.WriteString("![")
+
+	// the alt text is plain, so any markup in it must stay text
+	for i, c := range alt {
+		if isPrinterMark(c) {
+			continue
+		}
+		if c == '\\' || c == '`' || c == '*' || c == '_' || c == '[' || c == ']' || c == '<' ||
+			c == '&' && isEntityLike(alt[i:]) {
+			out.WriteByte('\\')
+		}
+		out.WriteByte(c)
+	}
+	out.WriteString("](")
+	printerLinkDestination(out, link, title)
+}
+
+func (options *MarkdownPrinter) LineBreak(out *bytes.Buffer) {
+	out.WriteString("  \n")
+	if options.wrapWidth > 0 {
+		out.WriteByte(wrapMark)
+	}
+}
+
+func (options *MarkdownPrinter) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	defer options.keepUnbroken(out, out.Len())
+	out.WriteByte('[')
+	out.Write(content)
+	out.WriteString("](")
+	printerLinkDestination(out, link, title)
+}
+
+func (options *MarkdownPrinter) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+	defer options.keepUnbroken(out, out.Len())
+	out.Write(stripPrinterMarks(tag))
+}
+
+func (options *MarkdownPrinter) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	out.WriteString("***")
+	out.Write(text)
+	out.WriteString("***")
+}
+
+func (options *MarkdownPrinter) StrikeThrough(out *bytes.Buffer, text []byte) {
+	out.WriteString("~~")
+	out.Write(text)
+	out.WriteString("~~")
+}
+
+func (options *MarkdownPrinter) CriticMarkup(out *bytes.Buffer, text, replacement []byte, kind int) {
+	markers := criticMarkers[kind]
+	out.WriteString(markers[0])
+	out.Write(text)
+	if kind == CRITIC_SUBSTITUTION {
+		out.WriteString("~>")
+		out.Write(replacement)
+	}
+	out.WriteString(markers[1])
+}
+
+func (options *MarkdownPrinter) Time(out *bytes.Buffer, datetime []byte) {
+	out.Write(datetime)
+}
+
+func (options *MarkdownPrinter) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	out.WriteString("[^")
+	out.Write(ref)
+	out.WriteByte(']')
+}
+
+func (options *MarkdownPrinter) Entity(out *bytes.Buffer, entity []byte) {
+	out.Write(entity)
+}
+
+func (options *MarkdownPrinter) NormalText(out *bytes.Buffer, text []byte) {
+	// an escaped character arrives alone, without the text after it
+	if options.pendingOut == out && options.pendingEnd == out.Len() && len(text) > 0 {
+		c := options.pending
+		if c == '&' && isEntityLike(append([]byte{c}, text...)) ||
+			c == ':' && bytes.HasPrefix(text, []byte("//")) ||
+			c == '{' && isCriticStart(append([]byte{c}, text...)) {
+			out.Truncate(out.Len() - 1)
+			out.WriteByte('\\')
+			out.WriteByte(c)
+		}
+	}
+	options.pendingOut = nil
+
+	lineStart := out.Len() == 0
+	if !lineStart {
+		last := out.Bytes()[out.Len()-1]
+		lineStart = last == '\n' || last == wrapMark
+	}
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if lineStart && needsLineStartEscape(text[i:]) {
+			out.WriteByte('\\')
+		}
+		lineStart = c == '\n'
+
+		// wrapped lines are joined, to be broken again later where
+		// they can start without escapes
+		if c == '\n' && options.wrapWidth > 0 {
+			out.WriteByte(' ')
+			lineStart = false
+			continue
+		}
+		if isPrinterMark(c) {
+			continue
+		}
+
+		switch {
+		case c == '\\' || c == '`' || c == '*' || c == '_' || c == '[' || c == ']' ||
+			c == '<' || c == '|':
+			out.WriteByte('\\')
+		case c == '&' && isEntityLike(text[i:]):
+			out.WriteByte('\\')
+		case c == ':' && bytes.HasPrefix(text[i+1:], []byte("//")):
+			out.WriteByte('\\')
+		case c == '{' && isCriticStart(text[i:]):
+			out.WriteByte('\\')
+		case c == '.' && isListOrdinal(out.Bytes()):
+			out.WriteByte('\\')
+		}
+		out.WriteByte(c)
+	}
+
+	if len(text) == 0 {
+		return
+	}
+	if last := text[len(text)-1]; last == '&' || last == '{' || last == ':' && endsWithScheme(out.Bytes()[:out.Len()-1]) {
+		options.pending, options.pendingOut, options.pendingEnd = last, out, out.Len()
+	}
+	if out.Len() > 0 && out.Bytes()[out.Len()-1] == '\n' {
+		options.lastText, options.lastTextEnd = out, out.Len()
+	}
+}
+
+func (options *MarkdownPrinter) DocumentHeader(out *bytes.Buffer) {
+}
+
+func (options *MarkdownPrinter) DocumentFooter(out *bytes.Buffer) {
+	if options.wrapWidth > 0 {
+		wrapMarkedLines(out, options.wrapWidth, ">", runeWidth, printerCanStartLine)
+	}
+}
+
+func (options *MarkdownPrinter) finishInline(out *bytes.Buffer) {
+	if options.wrapWidth > 0 {
+		markInlineOutput(out)
+	}
+	options.DocumentFooter(out)
+}
+
+// Make what was written to out since start one word for wrapping.
+func (options *MarkdownPrinter) keepUnbroken(out *bytes.Buffer, start int) {
+	if options.wrapWidth > 0 {
+		text := nonBreaking(out.Bytes()[start:])
+		out.Truncate(start)
+		out.Write(text)
+	}
+}
+
+// Separate a block element from what precedes it, except for a newline
+// already ending the text of a tight list item.
+func (options *MarkdownPrinter) blockStart(out *bytes.Buffer) {
+	if out == options.lastText && out.Len() == options.lastTextEnd {
+		return
+	}
+	doubleSpace(out)
+}
+
+// Write text line by line, starting the first line with first and every
+// other non-blank line with rest, and end the output with a newline.
+func writePrefixedLines(out *bytes.Buffer, text []byte, first, rest string) {
+	prefix := first
+	for {
+		end := bytes.IndexByte(text, '\n')
+		if end < 0 {
+			end = len(text)
+		}
+		line := text[:end]
+		if len(line) > 0 {
+			out.WriteString(prefix)
+		} else {
+			out.WriteString(strings.TrimRight(prefix, " "))
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+		prefix = rest
+
+		if end+1 >= len(text) {
+			break
+		}
+		text = text[end+1:]
+	}
+}
+
+// Write the parenthesized destination and title of an inline link or image,
+// starting just after the opening parenthesis.
+func printerLinkDestination(out *bytes.Buffer, link []byte, title []byte) {
+	for _, c := range link {
+		if isPrinterMark(c) {
+			continue
+		}
+		if c == '\\' || c == '(' || c == ')' || c == '"' || c == '\'' ||
+			c == '<' || c == '>' {
+			out.WriteByte('\\')
+		}
+		out.WriteByte(c)
+	}
+	if len(title) > 0 {
+		out.WriteString(" \"")
+		for _, c := range title {
+			if isPrinterMark(c) {
+				continue
+			}
+			if c == '\\' || c == '"' {
+				out.WriteByte('\\')
+			}
+			out.WriteByte(c)
+		}
+		out.WriteByte('"')
+	}
+	out.WriteByte(')')
+}
+
+// Test if text at the start of a line would be taken as the start of a
+// block element (other than through characters that are always escaped).
+func needsLineStartEscape(text []byte) bool {
+	switch text[0] {
+	case '#', '>', '=':
+		return true
+	case '-', '+':
+		return len(text) == 1 || text[1] == ' ' || text[1] == '\n' || text[1] == text[0]
+	}
+	return false
+}
+
+// Test if a word can start a wrapped line without being taken for the
+// start of a block element.
+func printerCanStartLine(word string) bool {
+	w := []byte(word)
+	switch {
+	case needsLineStartEscape(w), bytes.HasPrefix(w, []byte("~~~")):
+		return false
+	case len(w) > 1 && w[0] == '<' && isletter(w[1]):
+		return false
+	case w[len(w)-1] == '.' && isListOrdinal(w[:len(w)-1]):
+		return false
+	}
+	return true
+}
+
+// Test if the output before a period ends with a list item ordinal at the
+// start of a line, such as 12, b, or iv.
+func isListOrdinal(text []byte) bool {
+	start := len(text)
+	for start > 0 && isalnum(text[start-1]) {
+		start--
+	}
+	if start == len(text) || (start > 0 && text[start-1] != '\n' && text[start-1] != wrapMark) {
+		return false
+	}
+	ordinal := text[start:]
+	for _, c := range ordinal {
+		if !isdigit(c) {
+			return letteredListType(ordinal) != 0
+		}
+	}
+	return true
+}
+
+// Test if the output ends with a URI scheme that an autolink could start with.
+func endsWithScheme(text []byte) bool {
+	if len(text) > len("mailto") {
+		text = text[len(text)-len("mailto"):]
+	}
+	text = bytes.ToLower(text)
+	for _, prefix := range validUris {
+		scheme := bytes.TrimSuffix(prefix, []byte("://"))
+		if len(scheme) < len(prefix) && bytes.HasSuffix(text, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Test if text starting with & would be parsed as an entity.
+func isEntityLike(text []byte) bool {
+	end := 1
+	if end < len(text) && text[end] == '#' {
+		end++
+	}
+	for end < len(text) && isalnum(text[end]) {
+		end++
+	}
+	return end < len(text) && text[end] == ';'
+}
+
+// Format the ordinal of a list item in the numbering style of its list,
+// or as a number if the style has no marker for it that parses back: a
+// lettered list has one letter, and roman numerals stop before 4000.
+func listOrdinal(n int, flags int) string {
+	switch {
+	case flags&(LIST_TYPE_LOWER_ALPHA|LIST_TYPE_UPPER_ALPHA) != 0 && n >= 1 && n <= 26:
+		s := string(rune('a' + n - 1))
+		if flags&LIST_TYPE_UPPER_ALPHA != 0 {
+			s = strings.ToUpper(s)
+		}
+		return s
+	case flags&(LIST_TYPE_LOWER_ROMAN|LIST_TYPE_UPPER_ROMAN) != 0 && n >= 1 && n < 4000:
+		var s bytes.Buffer
+		for _, digit := range romanDigits {
+			for n >= digit.value {
+				s.WriteString(digit.symbol)
+				n -= digit.value
+			}
+		}
+		if flags&LIST_TYPE_UPPER_ROMAN != 0 {
+			return strings.ToUpper(s.String())
+		}
+		return s.String()
+	}
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,277 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+// Unit tests for Markdown rendering
+//
+
+package blackfriday
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runMarkdownPrinter(input string, extensions int) string {
+	return string(Markdown([]byte(input), MarkdownRenderer(0), extensions))
+}
+
+func doTestsPrinter(t *testing.T, tests []string, extensions int) {
+	for i := 0; i+1 < len(tests); i += 2 {
+		input := tests[i]
+		expected := tests[i+1]
+		actual := runMarkdownPrinter(input, extensions)
+		if actual != expected {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				input, expected, actual)
+		}
+
+		// the output must come back unchanged
+		if again := runMarkdownPrinter(actual, extensions); again != actual {
+			t.Errorf("\nInput   [%#v]\nFirst   [%#v]\nSecond  [%#v]",
+				input, actual, again)
+		}
+	}
+}
+
+func TestMarkdownPrinterBlocks(t *testing.T) {
+	var tests = []string{
+		"Setext\n======\n\nSub\n---\n",
+		"# Setext\n\n## Sub\n",
+
+		"# C# #\n",
+		"# C# #\n",
+
+		"> quote\n>\n>     code\n",
+		"> quote\n>\n>     code\n",
+
+		"+ one\n+ two\n    - nested\n",
+		"*   one\n*   two\n    *   nested\n",
+
+		"- one\n\n- two\n\n    more\n",
+		"*   one\n\n*   two\n\n    more\n",
+
+		"3. three\n7. four\n",
+		"1.  three\n2.  four\n",
+
+		"***\n\n- - -\n",
+		"---\n\n---\n",
+
+		"    code\n\n<div>\nhtml\n</div>\n",
+		"    code\n\n<div>\nhtml\n</div>\n",
+	}
+	doTestsPrinter(t, tests, 0)
+}
+
+func TestMarkdownPrinterExtensions(t *testing.T) {
+	var tests = []string{
+		"| a | b |\n|:--|--:|\n| c \\| d | *e* |\n",
+		"| a | b |\n| :--- | ---: |\n| c \\| d | *e* |\n",
+
+		"Header {#intro}\n===\n",
+		"# Header {#intro}\n",
+
+		"::: note {#n .a title=\"x y\"}\n::: tip\nInner\n:::\n:::\n",
+		":::: note {#n .a title=\"x y\"}\n::: tip\nInner\n:::\n::::\n",
+
+		"~~~ go\n```\n~~~\n",
+		"```` go\n```\n````\n",
+
+		"Text ^[inline note] here.\n",
+		"Text [^inline-note] here.\n\n[^inline-note]: inline note\n",
+
+		"b. one\nc. two\n",
+		"a.  one\nb.  two\n",
+
+		"~~gone~~ and http://example.com/\n",
+		"~~gone~~ and <http://example.com/>\n",
+
+		"- [X] done\n- [ ] todo\n",
+		"*   [x] done\n*   [ ] todo\n",
+
+		"> [!note]\n> Read *this*.\n",
+		"> [!NOTE]\n> Read *this*.\n",
+
+		"::: details open *Summary*\n::: details\nInner\n:::\n:::\n",
+		":::: details open *Summary*\n::: details\nInner\n:::\n::::\n",
+
+		"a | b\n:-- | ---\n{-:} c | {:-:} d\n{:-} e | \\{:-} f\n",
+		"| a | b |\n| :--- | --- |\n| {-:} c | {:-:} d |\n| e | \\{:-} f |\n",
+		"{++*added*++} {~~old~>new~~} {>>why<<} \\{++not++}\n",
+		"{++*added*++} {~~old~>new~~} {>>why<<} \\{++not++}\n",
+	}
+	doTestsPrinter(t, tests,
+		EXTENSION_TABLES|EXTENSION_FENCED_CODE|EXTENSION_AUTOLINK|
+			EXTENSION_STRIKETHROUGH|EXTENSION_FOOTNOTES|EXTENSION_LETTERED_LISTS|
+			EXTENSION_TASK_LISTS|EXTENSION_ALERTS|EXTENSION_DETAILS|EXTENSION_HEADER_IDS|
+			EXTENSION_DIRECTIVES|EXTENSION_CRITIC_MARKUP)
+}
+
+func TestMarkdownPrinterTableMarks(t *testing.T) {
+	// the input cannot pass for the alignment mark of a cell
+	var tests = []string{
+		"a | b\n---|---\nc\x1c1 | d\n",
+		"| a | b |\n| --- | --- |\n| c1 | d |\n",
+
+		"a | b\n---|---\n`c\x1c3` | d\n",
+		"| a | b |\n| --- | --- |\n| `c3` | d |\n",
+	}
+	doTestsPrinter(t, tests, EXTENSION_TABLES)
+}
+
+func TestMarkdownPrinterListOrdinals(t *testing.T) {
+	// items past z are numbered, and the list still parses as lettered
+	input := "a. one\n" + strings.Repeat("1. more\n", 29)
+	extensions := EXTENSION_LETTERED_LISTS
+	printed := runMarkdownPrinter(input, extensions)
+	expected := string(Markdown([]byte(input), HtmlRenderer(0, "", ""), extensions))
+	actual := string(Markdown([]byte(printed), HtmlRenderer(0, "", ""), extensions))
+	if actual != expected {
+		t.Errorf("\nInput   [%#v]\nPrinted [%#v]\nExpected[%#v]\nActual  [%#v]",
+			input, printed, expected, actual)
+	}
+	if again := runMarkdownPrinter(printed, extensions); again != printed {
+		t.Errorf("\nInput   [%#v]\nFirst   [%#v]\nSecond  [%#v]",
+			input, printed, again)
+	}
+
+	// so are items past the largest roman numeral
+	for n, expected := range map[int]string{3999: "MMMCMXCIX", 4000: "4000"} {
+		if actual := listOrdinal(n, LIST_TYPE_ORDERED|LIST_TYPE_UPPER_ROMAN); actual != expected {
+			t.Errorf("Expected item %d to be %q, got %q", n, expected, actual)
+		}
+	}
+}
+
+func TestMarkdownPrinterInline(t *testing.T) {
+	var tests = []string{
+		"_em_ __strong__ ___both___\n",
+		"*em* **strong** ***both***\n",
+
+		"`` a ` b `` and ```` ``` ````\n",
+		"``a ` b`` and ```` ``` ````\n",
+
+		"[link][ref] and ![alt](/img.png 'title')\n\n[ref]: /url(1) \"Title\"\n",
+		"[link](/url\\(1\\) \"Title\") and ![alt](/img.png \"title\")\n",
+
+		"![*bold* [cat](/c) a\\*b](/img.png)\n",
+		"![bold cat a\\*b](/img.png)\n",
+
+		"[link](/url 'say \"hi\" \\\\o/')\n",
+		"[link](/url \"say \\\"hi\\\" \\\\o/\")\n",
+
+		"line  \nbreak\n",
+		"line  \nbreak\n",
+
+		"\\*not em\\* snake\\_case \\[not link\\] \\<not html>\n",
+		"\\*not em\\* snake\\_case \\[not link\\] \\<not html>\n",
+
+		"1\\. not a list\n\n\\# not a header\n\n\\- not a bullet\n",
+		"1\\. not a list\n\n\\# not a header\n\n\\- not a bullet\n",
+
+		"&amp; &copy; AT&T \\&amp;\n",
+		"&amp; &copy; AT&T \\&amp;\n",
+	}
+	doTestsPrinter(t, tests, 0)
+}
+
+func TestMarkdownPrinterReference(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join("upskirtref", "*.text"))
+	for _, filename := range files {
+		input, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Errorf("Couldn't open '%s', error: %v\n", filename, err)
+			continue
+		}
+
+		// the printed document must render to the same HTML
+		printed := runMarkdownPrinter(string(input), 0)
+		expected := runMarkdownReference(string(input), 0)
+		if actual := runMarkdownReference(printed, 0); actual != expected {
+			t.Errorf("\n    [%#v]\nExpected[%#v]\nActual  [%#v]",
+				filename, expected, actual)
+		}
+		if again := runMarkdownPrinter(printed, 0); again != printed {
+			t.Errorf("\n    [%#v]\nFirst   [%#v]\nSecond  [%#v]",
+				filename, printed, again)
+		}
+	}
+}
+
+func TestMarkdownPrinterWrap(t *testing.T) {
+	var tests = []string{
+		"one two three four five six\n",
+		"one two three\nfour five six\n",
+
+		"a [link with spaces](/url) b `code with spaces` c\n",
+		"a\n[link with spaces](/url)\nb\n`code with spaces`\nc\n",
+
+		"> one two three four\n\n* one two three four\n",
+		"> one two\n> three four\n\n*   one two\n    three four\n",
+
+		"one two three four  \nfive\n",
+		"one two three\nfour  \nfive\n",
+
+		"wrapping never starts a line with - or 1. markup\n",
+		"wrapping never\nstarts a line\nwith - or 1.\nmarkup\n",
+
+		// the input cannot pass for the marks of wrapping, so code is
+		// never wrapped
+		"```go\nfoo\x1fbar baz qux quux\n```\n",
+		"``` go\nfoobar baz qux quux\n```\n",
+
+		"    code\x1f a b c d e f g h i\n",
+		"    code a b c d e f g h i\n",
+
+		"a `b\x1f c\x1d d` e\x1f f\n",
+		"a `b c d` e f\n",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		r := MarkdownRenderer(0).(*MarkdownPrinter)
+		r.SetWrapWidth(14)
+		actual := string(Markdown([]byte(tests[i]), r, EXTENSION_FENCED_CODE))
+		if actual != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nActual  [%#v]",
+				tests[i], tests[i+1], actual)
+		}
+	}
+}
+
+func TestMarkdownPrinterWrapReference(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join("upskirtref", "*.text"))
+	for _, width := range []int{1, 20, 72} {
+		for _, filename := range files {
+			input, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Errorf("Couldn't open '%s', error: %v\n", filename, err)
+				continue
+			}
+			print := func(input []byte) string {
+				r := MarkdownRenderer(0).(*MarkdownPrinter)
+				r.SetWrapWidth(width)
+				return string(Markdown(input, r, 0))
+			}
+
+			// only the whitespace between words may change
+			printed := print(input)
+			expected := strings.Fields(runMarkdownReference(string(input), 0))
+			actual := strings.Fields(runMarkdownReference(printed, 0))
+			if strings.Join(actual, " ") != strings.Join(expected, " ") {
+				t.Errorf("\n    [%#v] width %d\nExpected[%#v]\nActual  [%#v]",
+					filename, width, expected, actual)
+			}
+			if again := print([]byte(printed)); again != printed {
+				t.Errorf("\n    [%#v] width %d\nFirst   [%#v]\nSecond  [%#v]",
+					filename, width, printed, again)
+			}
+		}
+	}
+}
@@ -107,7 +107,33 @@ func smartQuoteHelper(out *bytes.Buffer, previousChar byte, nextChar byte, quote
 	return true
 }
 
+// Words that start with an apostrophe standing for left out letters.
+var elidedWords = []string{"tis", "twas", "twere", "twill", "til", "em", "n", "cause"}
+
+// Check whether an apostrophe starting a word is followed by letters left
+// after an elision, as in 'tis or rock 'n' roll, or by a decade, as in '90s.
+func isElision(text []byte) bool {
+	if len(text) >= 2 && isdigit(text[0]) && isdigit(text[1]) {
+		return len(text) == 2 || tolower(text[2]) == 's' || !isalnum(text[2])
+	}
+	for _, word := range elidedWords {
+		if len(text) < len(word) || !bytes.EqualFold(text[:len(word)], []byte(word)) {
+			continue
+		}
+		if len(text) == len(word) || text[len(word)] == '\'' || wordBoundary(text[len(word)]) {
+			return true
+		}
+	}
+	return false
+}
+
 func smartSingleQuote(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
+	// an apostrophe, not an opening quote
+	if !isalnum(previousChar) && isElision(text[1:]) {
+		out.WriteString("&rsquo;")
+		return 0
+	}
+
 	if len(text) >= 2 {
 		t1 := tolower(text[1])
 
@@ -239,6 +265,99 @@ func smartPeriod(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, te
 	return 0
 }
 
+// The period callback for the HTML_SMARTYPANTS_STRICT_ELLIPSIS and
+// HTML_SMARTYPANTS_ELLIPSIS_NNBSP styles. Strictly, runs of four or more
+// periods and spaced periods are left alone.
+func smartEllipsis(flags int) smartCallback {
+	return func(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
+		size := 0
+		if len(text) >= 3 && text[1] == '.' && text[2] == '.' {
+			size = 3
+		} else if len(text) >= 5 && text[1] == ' ' && text[2] == '.' && text[3] == ' ' && text[4] == '.' {
+			size = 5
+		}
+
+		if flags&HTML_SMARTYPANTS_STRICT_ELLIPSIS != 0 {
+			if size == 5 {
+				size = 0
+			}
+			run := size
+			for size > 0 && run < len(text) && text[run] == '.' {
+				run++
+			}
+			if run > 3 {
+				out.Write(text[:run])
+				return run - 1
+			}
+		}
+
+		if size == 0 {
+			out.WriteByte(text[0])
+			return 0
+		}
+		if flags&HTML_SMARTYPANTS_ELLIPSIS_NNBSP != 0 && !wordBoundary(previousChar) {
+			out.WriteString("&#8239;")
+		}
+		out.WriteString("&hellip;")
+		return size - 1
+	}
+}
+
+// x between digits, as in 4x4 or 1920x1080, is a multiplication sign when
+// the digits after it end at a word boundary
+func smartTimes(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
+	if isdigit(previousChar) {
+		end := 1
+		for end < len(text) && isdigit(text[end]) {
+			end++
+		}
+		if end > 1 && (end == len(text) || wordBoundary(text[end])) {
+			out.WriteString("&times;")
+			return 0
+		}
+	}
+
+	out.WriteByte(text[0])
+	return 0
+}
+
+// skip hexadecimal numbers such as 0x10, whose x is not a multiplication
+func smartZero(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
+	if wordBoundary(previousChar) && len(text) >= 2 && tolower(text[1]) == 'x' {
+		out.Write(text[:2])
+		return 1
+	}
+
+	out.WriteByte(text[0])
+	return 0
+}
+
+var smartArrowTable = [][2]string{
+	{"-&gt;", "&rarr;"},
+	{"&lt;-", "&larr;"},
+}
+
+// Turn -> and <- into arrows when they stand alone between spaces, leaving
+// all other text to fallback. Angle brackets are already escaped here.
+func smartArrows(fallback smartCallback) smartCallback {
+	return func(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
+		if previousChar == 0 || isspace(previousChar) {
+			for _, arrow := range smartArrowTable {
+				size := len(arrow[0])
+				if bytes.HasPrefix(text, []byte(arrow[0])) && (len(text) == size || isspace(text[size])) {
+					out.WriteString(arrow[1])
+					return size - 1
+				}
+			}
+		}
+		if fallback == nil {
+			out.WriteByte(text[0])
+			return 0
+		}
+		return fallback(out, smrt, previousChar, text)
+	}
+}
+
 func smartBacktick(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int {
 	if len(text) >= 2 && text[1] == '`' {
 		nextChar := byte(0)
@@ -346,31 +465,145 @@ func smartLeftAngle(out *bytes.Buffer, smrt *smartypantsData, previousChar byte,
 	return i
 }
 
+// The quotes and brackets that may come before a code-like word, as they
+// appear in escaped text, with what closes each after it. A double quote
+// already ends the word.
+var codeLikeWrappers = [][2]string{
+	{"&quot;", ""},
+	{"'", "'"},
+	{"(", ")"},
+	{"[", "]"},
+}
+
+// Find the code-like part of the word that starts at text[start], for
+// HTML_SMARTYPANTS_SKIP_CODE_LIKE, returning -1, -1 if there is none. A
+// word ends at a space or a double quote. Quotes and brackets around it
+// and punctuation after it are not part of it, so they are still
+// substituted. The word looks like code if it is a command-line option
+// such as -v or --flag, if it has a slash or backslash next to a letter or
+// a period, as in ./run or a/b, or if it has a period or an equals sign
+// between letters or digits, as in v1.0, main.go, or key=value. Fractions
+// such as 1/2 do not look like code.
+func codeLikeWord(text []byte, start int) (int, int) {
+	beg := start
+	var closers []string
+	for again := true; again; {
+		again = false
+		for _, wrapper := range codeLikeWrappers {
+			if bytes.HasPrefix(text[beg:], []byte(wrapper[0])) {
+				beg += len(wrapper[0])
+				closers = append(closers, wrapper[1])
+				again = true
+				break
+			}
+		}
+	}
+	end := beg
+	for end < len(text) && !isspace(text[end]) && !bytes.HasPrefix(text[end:], []byte("&quot;")) {
+		end++
+	}
+	trimPunct := func() {
+		for end > beg && bytes.IndexByte([]byte(".,;:!?"), text[end-1]) >= 0 {
+			end--
+		}
+	}
+	trimPunct()
+	for i := 0; i < len(closers) && bytes.HasSuffix(text[beg:end], []byte(closers[i])); i++ {
+		end -= len(closers[i])
+		trimPunct()
+	}
+
+	if looksLikeCode(text[beg:end]) {
+		return beg, end
+	}
+	return -1, -1
+}
+
+func looksLikeCode(word []byte) bool {
+	if len(word) < 2 {
+		return false
+	}
+	dashes := 0
+	for dashes < len(word) && dashes < 2 && word[dashes] == '-' {
+		dashes++
+	}
+	if dashes > 0 && dashes < len(word) && isletter(word[dashes]) {
+		return true
+	}
+
+	for i, c := range word {
+		prev, next := byte(0), byte(0)
+		if i > 0 {
+			prev = word[i-1]
+		}
+		if i+1 < len(word) {
+			next = word[i+1]
+		}
+		switch c {
+		case '/', '\\':
+			if isletter(prev) || isletter(next) || prev == '.' {
+				return true
+			}
+		case '.', '=':
+			if isalnum(prev) && isalnum(next) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type smartCallback func(out *bytes.Buffer, smrt *smartypantsData, previousChar byte, text []byte) int
 
 type smartypantsRenderer [256]smartCallback
 
+// The flags that each turn on some substitutions. The other smartypants
+// flags only change how the substitutions they go with are made.
+const smartypantsFlags = HTML_USE_SMARTYPANTS | HTML_SMARTYPANTS_QUOTES | HTML_SMARTYPANTS_DASHES |
+	HTML_SMARTYPANTS_ELLIPSIS | HTML_SMARTYPANTS_FRACTIONS | HTML_SMARTYPANTS_TIMES | HTML_SMARTYPANTS_ARROWS
+
 func smartypants(flags int) *smartypantsRenderer {
+	all := flags&HTML_USE_SMARTYPANTS != 0
 	r := new(smartypantsRenderer)
-	r['"'] = smartDoubleQuote
-	r['&'] = smartAmp
-	r['\''] = smartSingleQuote
-	r['('] = smartParens
-	if flags&HTML_SMARTYPANTS_LATEX_DASHES == 0 {
-		r['-'] = smartDash
-	} else {
-		r['-'] = smartDashLatex
+	if all || flags&HTML_SMARTYPANTS_QUOTES != 0 {
+		r['"'] = smartDoubleQuote
+		r['&'] = smartAmp
+		r['\''] = smartSingleQuote
+		r['`'] = smartBacktick
 	}
-	r['.'] = smartPeriod
-	if flags&HTML_SMARTYPANTS_FRACTIONS == 0 {
-		r['1'] = smartNumber
-		r['3'] = smartNumber
-	} else {
+	if all {
+		r['('] = smartParens
+	}
+	if all || flags&HTML_SMARTYPANTS_DASHES != 0 {
+		if flags&HTML_SMARTYPANTS_LATEX_DASHES == 0 {
+			r['-'] = smartDash
+		} else {
+			r['-'] = smartDashLatex
+		}
+	}
+	if all || flags&HTML_SMARTYPANTS_ELLIPSIS != 0 {
+		if flags&(HTML_SMARTYPANTS_STRICT_ELLIPSIS|HTML_SMARTYPANTS_ELLIPSIS_NNBSP) == 0 {
+			r['.'] = smartPeriod
+		} else {
+			r['.'] = smartEllipsis(flags)
+		}
+	}
+	if flags&HTML_SMARTYPANTS_FRACTIONS != 0 {
 		for ch := '1'; ch <= '9'; ch++ {
 			r[ch] = smartNumberGeneric
 		}
+	} else if all {
+		r['1'] = smartNumber
+		r['3'] = smartNumber
 	}
 	r['<'] = smartLeftAngle
-	r['`'] = smartBacktick
+	if flags&HTML_SMARTYPANTS_TIMES != 0 {
+		r['0'] = smartZero
+		r['x'] = smartTimes
+	}
+	if flags&HTML_SMARTYPANTS_ARROWS != 0 {
+		r['-'] = smartArrows(r['-'])
+		r['&'] = smartArrows(r['&'])
+	}
 	return r
 }
@@ -0,0 +1,191 @@
+//
+// Blackfriday Markdown Processor
+// Available at http://github.com/russross/blackfriday
+//
+// Copyright © 2011 Russ Ross <russ@russross.com>.
+// Distributed under the Simplified BSD License.
+// See README.md for details.
+//
+
+//
+//
+// Paragraph wrapping
+//
+//
+
+package blackfriday
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	wrapMark         = '\x1f' // starts an output line that may be wrapped
+	nonBreakingSpace = '\x1d' // a space that must not become a line break
+)
+
+// Write words separated by spaces, starting a new line with indent before
+// any word that would pass width. A word that may not start a line stays
+// on the current one, however wide it gets.
+func wrapWords(out *bytes.Buffer, words []string, column, width int, indent string,
+	measure func(string) int, canStartLine func(string) bool) {
+	lineStart := true
+	for _, word := range words {
+		n := measure(word)
+		if !lineStart && column+1+n > width && canStartLine(word) {
+			out.WriteByte('\n')
+			out.WriteString(indent)
+			column = measure(indent)
+			lineStart = true
+		}
+		if !lineStart {
+			out.WriteByte(' ')
+			column++
+		}
+		out.WriteString(word)
+		column += n
+		lineStart = false
+	}
+}
+
+// Wrap every output line containing a wrap mark: the text before the mark
+// is kept as the prefix of the first line, and continuation lines keep the
+// characters of the prefix found in keep, replacing the others with
+// spaces. A marked line ending in two spaces keeps them, as they end a hard
+// line break. Non-breaking spaces become plain spaces on all lines.
+func wrapMarkedLines(out *bytes.Buffer, width int, keep string,
+	measure func(string) int, canStartLine func(string) bool) {
+	var wrapped bytes.Buffer
+	for _, line := range strings.SplitAfter(out.String(), "\n") {
+		mark := strings.IndexByte(line, wrapMark)
+		if mark < 0 {
+			wrapped.WriteString(strings.Replace(line, string(nonBreakingSpace), " ", -1))
+			continue
+		}
+
+		prefix, text := line[:mark], strings.TrimSuffix(line[mark+1:], "\n")
+		var indent bytes.Buffer
+		for _, c := range prefix {
+			if strings.ContainsRune(keep, c) {
+				indent.WriteRune(c)
+			} else {
+				indent.WriteString(strings.Repeat(" ", measure(string(c))))
+			}
+		}
+
+		var words []string
+		for _, word := range strings.Split(text, " ") {
+			if word != "" {
+				words = append(words, strings.Replace(word, string(nonBreakingSpace), " ", -1))
+			}
+		}
+
+		wrapped.WriteString(prefix)
+		if width > 0 {
+			wrapWords(&wrapped, words, measure(prefix), width, indent.String(), measure, canStartLine)
+		} else {
+			wrapped.WriteString(strings.Join(words, " "))
+		}
+		if strings.HasSuffix(text, "  ") {
+			wrapped.WriteString("  ")
+		}
+		if strings.HasSuffix(line, "\n") {
+			wrapped.WriteByte('\n')
+		}
+	}
+	out.Reset()
+	out.Write(wrapped.Bytes())
+}
+
+// Mark the first line of inline list item or footnote text for wrapping.
+func markInlineLine(text []byte) []byte {
+	end := bytes.IndexByte(text, '\n')
+	if end < 0 {
+		end = len(text)
+	}
+	if len(text) == 0 || bytes.IndexByte(text[:end], wrapMark) >= 0 {
+		return text
+	}
+	return append([]byte{wrapMark}, text...)
+}
+
+// Test if c is one of the marks that renderers write for wrapping, which
+// must not be taken from the input.
+func isMark(c byte) bool {
+	return c == wrapMark || c == nonBreakingSpace
+}
+
+// Return text without the bytes for which drop is true.
+func stripBytes(text []byte, drop func(c byte) bool) []byte {
+	var clean []byte
+	for i, c := range text {
+		if drop(c) {
+			if clean == nil {
+				clean = append(make([]byte, 0, len(text)), text[:i]...)
+			}
+		} else if clean != nil {
+			clean = append(clean, c)
+		}
+	}
+	if clean == nil {
+		return text
+	}
+	return clean
+}
+
+// Mark the first line of out for wrapping, for text rendered by
+// MarkdownInline.
+func markInlineOutput(out *bytes.Buffer) {
+	text := markInlineLine(append([]byte(nil), out.Bytes()...))
+	out.Reset()
+	out.Write(text)
+}
+
+// Replace the spaces and newlines in text with non-breaking spaces.
+func nonBreaking(text []byte) []byte {
+	text = bytes.Replace(text, []byte(" "), []byte{nonBreakingSpace}, -1)
+	return bytes.Replace(text, []byte("\n"), []byte{nonBreakingSpace}, -1)
+}
+
+// Split rendered HTML into the words of wrapped text: at spaces and
+// newlines, but not inside tags, links, or code spans.
+func htmlWords(text []byte) []string {
+	var words []string
+	start, inTag, inLink, inCode := 0, false, false, false
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '<':
+			inTag = true
+			if bytes.HasPrefix(text[i:], []byte("<a ")) {
+				inLink = true
+			} else if bytes.HasPrefix(text[i:], []byte("</a>")) {
+				inLink = false
+			} else if bytes.HasPrefix(text[i:], []byte("<code>")) {
+				inCode = true
+			} else if bytes.HasPrefix(text[i:], []byte("</code>")) {
+				inCode = false
+			}
+		case c == '>':
+			inTag = false
+		case (c == ' ' || c == '\n') && !inTag && !inLink && !inCode:
+			if i > start {
+				words = append(words, string(text[start:i]))
+			}
+			start = i + 1
+		}
+	}
+	if start < len(text) {
+		words = append(words, string(text[start:]))
+	}
+	return words
+}
+
+func runeWidth(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+func anyWordStartsLine(string) bool {
+	return true
+}